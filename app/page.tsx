@@ -1,7 +1,7 @@
-"use client"
-
-import { POST } from "../frontend/app/api/webhook/stripe/route"
-
 export default function SyntheticV0PageForDeployment() {
-  return <POST />
-}
\ No newline at end of file
+  return (
+    <main className="flex min-h-screen items-center justify-center p-4">
+      <p className="font-mono text-sm">Full-Stack Boilerplate</p>
+    </main>
+  )
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/your-username/your-repo/internal/api"
 	"github.com/your-username/your-repo/internal/config"
 	"github.com/your-username/your-repo/internal/database"
+	"github.com/your-username/your-repo/internal/migrations"
+	"github.com/your-username/your-repo/internal/models"
 )
 
 func main() {
@@ -21,11 +28,22 @@ func main() {
 	cfg := config.New()
 
 	// Initialize database connection
-	db, err := database.New(cfg.DatabaseURL)
+	db, err := database.New(context.Background(), cfg.DatabaseURL, cfg.DBStartupTimeout)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
-	defer db.Close()
+
+	// Apply pending migrations if configured to
+	if cfg.MigrateOnStart {
+		migrator, err := migrations.New(db)
+		if err != nil {
+			log.Fatalf("Failed to load migrations: %v", err)
+		}
+		if err := migrator.Up(context.Background()); err != nil {
+			log.Fatalf("Failed to apply migrations: %v", err)
+		}
+		log.Printf("Database schema at version %d", migrator.Latest())
+	}
 
 	// Initialize API server
 	server := api.NewServer(cfg, db)
@@ -36,8 +54,119 @@ func main() {
 		port = "8080"
 	}
 
-	log.Printf("Server starting on port %s", port)
-	if err := http.ListenAndServe(":"+port, server.Router); err != nil {
+	httpServer := &http.Server{
+		Addr:              ":" + port,
+		Handler:           server.Router,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+
+	// Stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		serveErr <- httpServer.ListenAndServe()
+	}()
+	server.SetReady(true)
+
+	// Cancel orders that were never paid for, releasing their stock
+	go expireReservations(ctx, models.NewPostgresOrderStore(db), cfg.ReservationGracePeriod, cfg.ReservationSweepInterval)
+
+	// Forget Idempotency-Keys once their responses may no longer be replayed
+	go deleteExpiredIdempotencyKeys(ctx, models.NewPostgresIdempotencyStore(db), cfg.IdempotencyKeySweepInterval)
+
+	// Delete anonymous carts whose cookie has expired
+	go deleteStaleCarts(ctx, models.NewPostgresCartStore(db), cfg.CartTTL, cfg.CartSweepInterval)
+
+	select {
+	case err := <-serveErr:
+		db.Close()
 		log.Fatalf("Failed to start server: %v", err)
+	case <-ctx.Done():
+	}
+
+	// Report not ready straight away so load balancers stop routing to us
+	// while in-flight requests drain
+	server.SetReady(false)
+	stop()
+	log.Printf("Shutting down, draining requests for up to %s", cfg.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to drain requests: %v", err)
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Server error: %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		log.Printf("Failed to close database: %v", err)
+	}
+
+	log.Println("Server stopped")
+}
+
+// expireReservations releases the stock reservations that expired more than
+// grace ago every interval until ctx is done
+func expireReservations(ctx context.Context, orders models.OrderStore, grace, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := orders.ExpireReservations(ctx, now.Add(-grace))
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Failed to expire stock reservations: %v", err)
+			}
+			if n > 0 {
+				log.Printf("Canceled %d orders with expired stock reservations", n)
+			}
+		}
+	}
+}
+
+// deleteExpiredIdempotencyKeys deletes expired Idempotency-Keys every
+// interval until ctx is done
+func deleteExpiredIdempotencyKeys(ctx context.Context, keys models.IdempotencyStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := keys.DeleteExpiredIdempotencyKeys(ctx, now); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to delete expired idempotency keys: %v", err)
+			}
+		}
+	}
+}
+
+// deleteStaleCarts deletes anonymous carts unchanged for ttl every interval
+// until ctx is done
+func deleteStaleCarts(ctx context.Context, carts models.CartStore, ttl, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := carts.DeleteStaleCarts(ctx, now.Add(-ttl)); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to delete stale carts: %v", err)
+			}
+		}
 	}
 }
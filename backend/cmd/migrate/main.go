@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/joho/godotenv"
+	"github.com/your-username/your-repo/internal/config"
+	"github.com/your-username/your-repo/internal/database"
+	"github.com/your-username/your-repo/internal/migrations"
+)
+
+const usage = `Usage: migrate <command> [argument]
+
+Commands:
+  up           Apply all pending migrations
+  down [N]     Roll back the last N applied migrations (default 1)
+  goto V       Migrate up or down to version V (0 rolls back everything)
+  status       List migrations and whether they have been applied`
+
+func main() {
+	// Load environment variables from .env file
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// Initialize configuration
+	cfg := config.New()
+
+	// Initialize database connection
+	db, err := database.New(context.Background(), cfg.DatabaseURL, cfg.DBStartupTimeout)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	if err := run(context.Background(), migrator, os.Args[1], os.Args[2:]); err != nil {
+		log.Fatalf("%s failed: %v", os.Args[1], err)
+	}
+}
+
+// run executes a single migrate command
+func run(ctx context.Context, m *migrations.Migrator, command string, args []string) error {
+	switch command {
+	case "up":
+		return m.Up(ctx)
+
+	case "down":
+		steps := 1
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid step count %q", args[0])
+			}
+			steps = n
+		}
+		return m.Down(ctx, steps)
+
+	case "goto":
+		if len(args) < 1 {
+			return fmt.Errorf("goto requires a version")
+		}
+		version, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[0])
+		}
+		return m.Goto(ctx, version)
+
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-40s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown command %q\n\n%s", command, usage)
+}
@@ -0,0 +1,122 @@
+// Command reconcilestripe reports drift between the products table and the
+// Stripe Products and Prices synced from it, and with -fix re-syncs the
+// products that have drifted. It exits with status 1 if drift remains.
+//
+// Set STRIPE_API_URL to run it against stripe-mock.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+	"github.com/your-username/your-repo/internal/catalog"
+	"github.com/your-username/your-repo/internal/config"
+	"github.com/your-username/your-repo/internal/database"
+	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/payments"
+)
+
+func main() {
+	fix := flag.Bool("fix", false, "re-sync products that have drifted")
+	flag.Parse()
+
+	// Load environment variables from .env file
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+
+	cfg := config.New()
+	ctx := context.Background()
+
+	if cfg.StripeSecretKey == "" {
+		log.Fatal("STRIPE_SECRET_KEY is not set")
+	}
+
+	db, err := database.New(ctx, cfg.DatabaseURL, cfg.DBStartupTimeout)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	store := models.NewPostgresProductStore(db)
+	syncer := catalog.NewSyncer(payments.NewStripeClient(cfg.StripeSecretKey, cfg.StripeAPIURL), cfg.Currency)
+
+	products, err := allProducts(ctx, store)
+	if err != nil {
+		log.Fatalf("Failed to load products: %v", err)
+	}
+
+	drifts, err := syncer.Reconcile(ctx, products)
+	if err != nil {
+		log.Fatalf("Failed to reconcile: %v", err)
+	}
+	for _, d := range drifts {
+		fmt.Println(d)
+	}
+
+	if *fix {
+		drifts = resync(ctx, store, syncer, products, drifts)
+	}
+
+	fmt.Printf("%d products checked, %d differences\n", len(products), len(drifts))
+	if len(drifts) > 0 {
+		os.Exit(1)
+	}
+}
+
+// allProducts reads every product, a page at a time
+func allProducts(ctx context.Context, store models.ProductStore) ([]models.Product, error) {
+	var products []models.Product
+	page := models.Page{Limit: models.MaxPageLimit}
+	for {
+		batch, next, err := store.GetProducts(ctx, models.ProductFilter{}, page)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, batch...)
+		if next == "" {
+			return products, nil
+		}
+		page.Cursor = next
+	}
+}
+
+// resync syncs every product with drift and returns the drift it could not
+// fix. Stripe Products that none of our products use are left for a human.
+func resync(ctx context.Context, store models.ProductStore, syncer *catalog.Syncer, products []models.Product, drifts []catalog.Drift) []catalog.Drift {
+	drifted := make(map[int]bool)
+	for _, d := range drifts {
+		drifted[d.ProductID] = true
+	}
+
+	var remaining []catalog.Drift
+	for _, d := range drifts {
+		if d.ProductID == 0 {
+			remaining = append(remaining, d)
+		}
+	}
+
+	for i := range products {
+		p := &products[i]
+		if !drifted[p.ID] {
+			continue
+		}
+		if err := syncer.Sync(ctx, p); err != nil {
+			log.Printf("Failed to sync product %d: %v", p.ID, err)
+			remaining = append(remaining, catalog.Drift{ProductID: p.ID, StripeProductID: p.StripeProductID, Problem: "sync failed"})
+			continue
+		}
+		if err := store.SetProductStripeIDs(ctx, p.ID, p.StripeProductID, p.StripePriceID); err != nil {
+			log.Printf("Failed to save product %d: %v", p.ID, err)
+			remaining = append(remaining, catalog.Drift{ProductID: p.ID, StripeProductID: p.StripeProductID, Problem: "save failed"})
+			continue
+		}
+		fmt.Printf("product %d: synced\n", p.ID)
+	}
+
+	return remaining
+}
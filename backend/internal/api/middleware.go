@@ -0,0 +1,25 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// queryDeadline bounds each request's context by timeout, so database
+// queries made while handling it are canceled once it passes. A timeout of
+// zero disables the deadline.
+func queryDeadline(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
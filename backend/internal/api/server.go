@@ -1,14 +1,22 @@
 package api
 
 import (
-	"net/http"
+	"context"
+	"errors"
+	"sync/atomic"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/your-username/your-repo/internal/auth"
+	"github.com/your-username/your-repo/internal/catalog"
 	"github.com/your-username/your-repo/internal/config"
 	"github.com/your-username/your-repo/internal/database"
 	"github.com/your-username/your-repo/internal/handlers"
+	"github.com/your-username/your-repo/internal/health"
+	"github.com/your-username/your-repo/internal/migrations"
+	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/payments"
 )
 
 // Server holds the HTTP server and its dependencies
@@ -16,6 +24,22 @@ type Server struct {
 	Router *chi.Mux
 	Config *config.Config
 	DB     *database.DB
+	// Health holds the readiness checks served on /readyz
+	Health *health.Registry
+
+	// ready reports whether the server should receive traffic. It is set
+	// once the server is listening and cleared as soon as shutdown starts.
+	ready atomic.Bool
+}
+
+// SetReady marks the server as ready or not ready to receive traffic
+func (s *Server) SetReady(ready bool) {
+	s.ready.Store(ready)
+}
+
+// Ready reports whether the server is ready to receive traffic
+func (s *Server) Ready() bool {
+	return s.ready.Load()
 }
 
 // NewServer creates a new HTTP server
@@ -24,6 +48,7 @@ func NewServer(cfg *config.Config, db *database.DB) *Server {
 		Router: chi.NewRouter(),
 		Config: cfg,
 		DB:     db,
+		Health: health.NewRegistry(cfg.HealthCheckTimeout),
 	}
 
 	// Set up middleware
@@ -35,31 +60,110 @@ func NewServer(cfg *config.Config, db *database.DB) *Server {
 	// Set up CORS
 	server.Router.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{cfg.AllowedOrigins},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Idempotency-Key"},
+		ExposedHeaders:   []string{"Link", "Idempotent-Replayed"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
+	// Initialize stores
+	users := models.NewPostgresUserStore(db)
+	products := models.NewPostgresProductStore(db)
+	orders := models.NewPostgresOrderStore(db)
+	idempotencyKeys := models.NewPostgresIdempotencyStore(db)
+	carts := models.NewPostgresCartStore(db)
+	coupons := models.NewPostgresCouponStore(db)
+	stripeEvents := models.NewPostgresStripeEventStore(db)
+
 	// Initialize handlers
-	productHandler := handlers.NewProductHandler(db)
-	userHandler := handlers.NewUserHandler(db)
-	orderHandler := handlers.NewOrderHandler(db, cfg)
+	userHandler := handlers.NewUserHandler(users)
+	roleHandler := handlers.NewRoleHandler(users)
+	meHandler := handlers.NewMeHandler(users, orders)
+	orderHandler := handlers.NewOrderHandler(orders, cfg)
+	cartHandler := handlers.NewCartHandler(carts, cfg)
+	couponHandler := handlers.NewCouponHandler(coupons)
+	stripeClient := payments.NewStripeClient(cfg.StripeSecretKey, cfg.StripeAPIURL)
+	var syncer *catalog.Syncer
+	if cfg.StripeSecretKey != "" {
+		syncer = catalog.NewSyncer(stripeClient, cfg.Currency)
+	}
+	productHandler := handlers.NewProductHandler(products, syncer)
+	checkoutHandler := handlers.NewCheckoutHandler(orders, products, cfg, stripeClient)
+	refundHandler := handlers.NewRefundHandler(orders, orders, stripeClient)
+	webhookHandler := handlers.NewWebhookHandler(stripeEvents, cfg, stripeClient, users)
+
+	// Initialize Clerk session verification
+	verifier := auth.NewVerifier(auth.NewKeySet(cfg.ClerkJWKSURL), cfg.ClerkIssuer, cfg.ClerkAuthorizedParties)
+	authenticator := auth.NewAuthenticator(verifier, users, cfg.AdminClerkID)
+	authenticator.OnError = handlers.RespondError
+	if cfg.ClerkSecretKey != "" {
+		authenticator.API = auth.NewClerkAPI(cfg.ClerkSecretKey)
+	}
+
+	// Register readiness checks
+	server.Health.Register("server", func(ctx context.Context) error {
+		if !server.Ready() {
+			return errors.New("server is not accepting traffic")
+		}
+		return nil
+	})
+	server.Health.Register("database", health.DatabaseCheck(db))
+	if migrator, err := migrations.New(db); err != nil {
+		server.Health.Register("migrations", func(ctx context.Context) error { return err })
+	} else {
+		server.Health.Register("migrations", health.MigrationsCheck(migrator))
+	}
+	server.Health.Register("stripe", health.StripeConfigCheck(cfg))
 
 	// Set up routes
+	server.Router.NotFound(handlers.NotFound)
+	server.Router.MethodNotAllowed(handlers.MethodNotAllowed)
+	server.Router.Get("/healthz", health.LivenessHandler)
+	server.Router.Get("/readyz", server.Health.ReadinessHandler)
+
 	server.Router.Route("/api", func(r chi.Router) {
+		r.Use(queryDeadline(cfg.QueryTimeout))
+
 		// Public routes
 		r.Group(func(r chi.Router) {
 			r.Get("/products", productHandler.List)
 			r.Get("/products/{id}", productHandler.Get)
 		})
 
+		// Webhooks authenticate with their own signatures
+		r.Post("/webhooks/stripe", webhookHandler.Stripe)
+		r.Post("/webhooks/clerk", webhookHandler.Clerk)
+
+		// The authenticated user, provisioned on first use
+		r.Route("/me", func(r chi.Router) {
+			r.Use(authenticator.Middleware)
+			r.Use(authenticator.ProvisionUser)
+			r.Get("/", meHandler.Get)
+			r.Patch("/", meHandler.Update)
+			r.Get("/orders", meHandler.ListOrders)
+			r.Get("/orders/{id}", meHandler.GetOrder)
+		})
+
+		// The caller's cart, anonymous or the signed-in user's
+		r.Route("/cart", func(r chi.Router) {
+			r.Use(authenticator.Optional)
+			r.Use(authenticator.ProvisionUser)
+			r.Get("/", cartHandler.Get)
+			r.Delete("/", cartHandler.Clear)
+			r.Post("/items", cartHandler.AddItem)
+			r.Put("/items/{id}", cartHandler.UpdateItem)
+			r.Delete("/items/{id}", cartHandler.RemoveItem)
+			// Placing an order is made safe to retry with an Idempotency-Key, like
+			// the protected routes' writes
+			r.With(handlers.Idempotency(idempotencyKeys, cfg.IdempotencyKeyTTL)).Post("/order", cartHandler.CreateOrder)
+		})
+
 		// Protected routes
 		r.Group(func(r chi.Router) {
-			r.Use(middleware.BasicAuth("api", map[string]string{
-				"api": "secret",
-			}))
+			r.Use(authenticator.Middleware)
+			r.Use(authenticator.RequireUser)
+			r.Use(handlers.Idempotency(idempotencyKeys, cfg.IdempotencyKeyTTL))
 
 			// User routes
 			r.Route("/users", func(r chi.Router) {
@@ -79,11 +183,43 @@ func NewServer(cfg *config.Config, db *database.DB) *Server {
 				r.Delete("/{id}", orderHandler.Delete)
 			})
 
-			// Product management routes
-			r.Route("/admin/products", func(r chi.Router) {
-				r.Post("/", productHandler.Create)
-				r.Put("/{id}", productHandler.Update)
-				r.Delete("/{id}", productHandler.Delete)
+			// Checkout routes
+			r.Post("/checkout", checkoutHandler.Create)
+
+			r.Route("/admin", func(r chi.Router) {
+				// Product management routes
+				r.Route("/products", func(r chi.Router) {
+					r.Use(authenticator.RequireRole(models.RoleStaff))
+					r.Post("/", productHandler.Create)
+					r.Put("/{id}", productHandler.Update)
+					r.Post("/{id}/stock", productHandler.AdjustStock)
+					r.Delete("/{id}", productHandler.Delete)
+				})
+
+				// Coupon management routes
+				r.Route("/coupons", func(r chi.Router) {
+					r.Use(authenticator.RequireRole(models.RoleStaff))
+					r.Get("/", couponHandler.List)
+					r.Post("/", couponHandler.Create)
+					r.Get("/{id}", couponHandler.Get)
+					r.Put("/{id}", couponHandler.Update)
+					r.Delete("/{id}", couponHandler.Delete)
+				})
+
+				// Refund routes
+				r.Route("/orders/{id}/refunds", func(r chi.Router) {
+					r.Use(authenticator.RequireRole(models.RoleStaff))
+					r.Get("/", refundHandler.List)
+					r.Post("/", refundHandler.Create)
+				})
+
+				// Role management routes
+				r.Route("/users/{id}/roles", func(r chi.Router) {
+					r.Use(authenticator.RequireRole(models.RoleAdmin))
+					r.Get("/", roleHandler.List)
+					r.Post("/", roleHandler.Grant)
+					r.Delete("/{role}", roleHandler.Revoke)
+				})
 			})
 		})
 	})
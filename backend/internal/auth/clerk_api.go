@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// clerkAPIURL is the base URL of the Clerk Backend API
+const clerkAPIURL = "https://api.clerk.com/v1"
+
+// ClerkUser is a user object as returned by the Clerk Backend API and sent in
+// user webhooks
+type ClerkUser struct {
+	ID                    string `json:"id"`
+	FirstName             string `json:"first_name"`
+	LastName              string `json:"last_name"`
+	PrimaryEmailAddressID string `json:"primary_email_address_id"`
+	EmailAddresses        []struct {
+		ID           string `json:"id"`
+		EmailAddress string `json:"email_address"`
+	} `json:"email_addresses"`
+	// UpdatedAt is when the user last changed, in milliseconds
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// PrimaryEmail returns the user's primary email address
+func (u ClerkUser) PrimaryEmail() string {
+	for _, addr := range u.EmailAddresses {
+		if addr.ID == u.PrimaryEmailAddressID {
+			return addr.EmailAddress
+		}
+	}
+	return ""
+}
+
+// FullName returns the user's first and last name
+func (u ClerkUser) FullName() string {
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
+
+// ClerkAPI fetches users from the Clerk Backend API
+type ClerkAPI struct {
+	BaseURL   string
+	SecretKey string
+	Client    *http.Client
+}
+
+// NewClerkAPI creates a new ClerkAPI authenticated with a Clerk secret key
+func NewClerkAPI(secretKey string) *ClerkAPI {
+	return &ClerkAPI{
+		BaseURL:   clerkAPIURL,
+		SecretKey: secretKey,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// GetUser returns the Clerk user with the given ID
+func (c *ClerkAPI) GetUser(ctx context.Context, id string) (*ClerkUser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/users/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build Clerk user request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.SecretKey)
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch Clerk user: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch Clerk user: unexpected status %d", resp.StatusCode)
+	}
+
+	var user ClerkUser
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, fmt.Errorf("failed to decode Clerk user: %w", err)
+	}
+	return &user, nil
+}
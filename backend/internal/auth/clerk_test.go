@@ -0,0 +1,334 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/your-username/your-repo/internal/models"
+)
+
+const testIssuer = "https://clerk.example.com"
+
+// testJWKS serves a JWKS document of generated RSA keys, which can be
+// rotated while it runs
+type testJWKS struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	fetches int
+}
+
+// newTestJWKS starts a JWKS server serving a new key for each kid
+func newTestJWKS(t *testing.T, kids ...string) *testJWKS {
+	t.Helper()
+	s := &testJWKS{keys: make(map[string]*rsa.PrivateKey)}
+	for _, kid := range kids {
+		s.addKey(t, kid)
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.fetches++
+
+		doc := struct {
+			Keys []jwk `json:"keys"`
+		}{Keys: []jwk{}}
+		for kid, key := range s.keys {
+			doc.Keys = append(doc.Keys, jwk{
+				Kid: kid,
+				Kty: "RSA",
+				Alg: "RS256",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(doc)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// addKey generates a key and starts serving it under kid
+func (s *testJWKS) addKey(t *testing.T, kid string) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	s.mu.Lock()
+	s.keys[kid] = key
+	s.mu.Unlock()
+	return key
+}
+
+// removeKey stops serving the key under kid
+func (s *testJWKS) removeKey(kid string) {
+	s.mu.Lock()
+	delete(s.keys, kid)
+	s.mu.Unlock()
+}
+
+// key returns the key served under kid
+func (s *testJWKS) key(kid string) *rsa.PrivateKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys[kid]
+}
+
+// fetchCount returns how many times the JWKS has been fetched
+func (s *testJWKS) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+// verifier returns a Verifier for tokens from testIssuer signed by the keys
+// s serves
+func (s *testJWKS) verifier() *Verifier {
+	keys := NewKeySet(s.URL)
+	keys.MinRefreshInterval = 0
+	return NewVerifier(keys, testIssuer, []string{"https://shop.example.com"})
+}
+
+// validClaims returns the claims of a session token for clerkID that has
+// not expired
+func validClaims(clerkID string) *Claims {
+	now := time.Now()
+	return &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   clerkID,
+			Issuer:    testIssuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+		SessionID:       "sess_1",
+		AuthorizedParty: "https://shop.example.com",
+	}
+}
+
+// signToken signs claims with key as an RS256 token with the given kid
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims *Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+func TestVerifyValidToken(t *testing.T) {
+	jwks := newTestJWKS(t, "key-1")
+	token := signToken(t, jwks.key("key-1"), "key-1", validClaims("user_1"))
+
+	claims, err := jwks.verifier().Verify(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if claims.Subject != "user_1" || claims.SessionID != "sess_1" {
+		t.Errorf("claims = %+v", claims)
+	}
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	jwks := newTestJWKS(t, "key-1")
+	otherKey := newTestJWKS(t, "key-1").key("key-1")
+
+	expired := validClaims("user_1")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	noExpiry := validClaims("user_1")
+	noExpiry.ExpiresAt = nil
+	wrongIssuer := validClaims("user_1")
+	wrongIssuer.Issuer = "https://evil.example.com"
+	noSubject := validClaims("")
+	wrongParty := validClaims("user_1")
+	wrongParty.AuthorizedParty = "https://evil.example.com"
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"expired", signToken(t, jwks.key("key-1"), "key-1", expired)},
+		{"no expiry", signToken(t, jwks.key("key-1"), "key-1", noExpiry)},
+		{"wrong issuer", signToken(t, jwks.key("key-1"), "key-1", wrongIssuer)},
+		{"no subject", signToken(t, jwks.key("key-1"), "key-1", noSubject)},
+		{"unauthorized party", signToken(t, jwks.key("key-1"), "key-1", wrongParty)},
+		{"unknown kid", signToken(t, jwks.key("key-1"), "key-2", validClaims("user_1"))},
+		{"no kid", signToken(t, jwks.key("key-1"), "", validClaims("user_1"))},
+		{"wrong key for kid", signToken(t, otherKey, "key-1", validClaims("user_1"))},
+		{"malformed", "not-a-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := jwks.verifier().Verify(context.Background(), tt.token)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify error = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestVerifyAllowsClockSkew(t *testing.T) {
+	jwks := newTestJWKS(t, "key-1")
+	claims := validClaims("user_1")
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-2 * time.Second))
+
+	if _, err := jwks.verifier().Verify(context.Background(), signToken(t, jwks.key("key-1"), "key-1", claims)); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+func TestVerifyPicksUpRotatedKey(t *testing.T) {
+	ctx := context.Background()
+	jwks := newTestJWKS(t, "key-1")
+	v := jwks.verifier()
+
+	if _, err := v.Verify(ctx, signToken(t, jwks.key("key-1"), "key-1", validClaims("user_1"))); err != nil {
+		t.Fatalf("Verify before rotation: %v", err)
+	}
+
+	newKey := jwks.addKey(t, "key-2")
+	jwks.removeKey("key-1")
+
+	if _, err := v.Verify(ctx, signToken(t, newKey, "key-2", validClaims("user_1"))); err != nil {
+		t.Fatalf("Verify after rotation: %v", err)
+	}
+	if n := jwks.fetchCount(); n != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", n)
+	}
+}
+
+func TestKeySetLimitsRefreshesForUnknownKids(t *testing.T) {
+	ctx := context.Background()
+	jwks := newTestJWKS(t, "key-1")
+	keys := NewKeySet(jwks.URL)
+
+	if _, err := keys.Key(ctx, "key-1"); err != nil {
+		t.Fatalf("Key: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := keys.Key(ctx, "key-2"); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("Key error = %v, want ErrKeyNotFound", err)
+		}
+	}
+	if n := jwks.fetchCount(); n != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", n)
+	}
+}
+
+func TestKeySetKeepsKnownKeyWhenJWKSIsDown(t *testing.T) {
+	ctx := context.Background()
+	jwks := newTestJWKS(t, "key-1")
+	keys := NewKeySet(jwks.URL)
+
+	if _, err := keys.Key(ctx, "key-1"); err != nil {
+		t.Fatalf("Key: %v", err)
+	}
+	jwks.Close()
+	keys.TTL = 0
+
+	if _, err := keys.Key(ctx, "key-1"); err != nil {
+		t.Errorf("Key after JWKS went down: %v", err)
+	}
+}
+
+func TestRequireUser(t *testing.T) {
+	jwks := newTestJWKS(t, "key-1")
+	users := models.NewMemoryStore()
+	if err := users.CreateUser(context.Background(), &models.User{ClerkID: "user_1", Email: "a@example.com", Role: models.RoleCustomer}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	a := NewAuthenticator(jwks.verifier(), users, "")
+	handler := a.Middleware(a.RequireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(UserFromContext(r.Context()).ClerkID))
+	})))
+
+	expired := validClaims("user_1")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		name       string
+		header     string
+		cookie     string
+		wantStatus int
+	}{
+		{"no token", "", "", http.StatusUnauthorized},
+		{"not bearer", "Basic dXNlcjpwYXNz", "", http.StatusUnauthorized},
+		{"invalid token", "Bearer not-a-token", "", http.StatusUnauthorized},
+		{"expired token", "Bearer " + signToken(t, jwks.key("key-1"), "key-1", expired), "", http.StatusUnauthorized},
+		{"not provisioned", "Bearer " + signToken(t, jwks.key("key-1"), "key-1", validClaims("user_2")), "", http.StatusForbidden},
+		{"provisioned", "Bearer " + signToken(t, jwks.key("key-1"), "key-1", validClaims("user_1")), "", http.StatusOK},
+		{"session cookie", "", signToken(t, jwks.key("key-1"), "key-1", validClaims("user_1")), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: sessionCookie, Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && rec.Body.String() != "user_1" {
+				t.Errorf("body = %q, want the user's Clerk ID", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	jwks := newTestJWKS(t, "key-1")
+	users := models.NewMemoryStore()
+	for _, u := range []models.User{
+		{ClerkID: "user_customer", Email: "c@example.com", Role: models.RoleCustomer},
+		{ClerkID: "user_admin", Email: "a@example.com", Role: models.RoleAdmin},
+	} {
+		if err := users.CreateUser(context.Background(), &u); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+
+	a := NewAuthenticator(jwks.verifier(), users, "")
+	handler := a.Middleware(a.RequireRole(models.RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	for clerkID, want := range map[string]int{
+		"user_customer": http.StatusForbidden,
+		"user_admin":    http.StatusOK,
+		"user_unknown":  http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+signToken(t, jwks.key("key-1"), "key-1", validClaims(clerkID)))
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", clerkID, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrKeyNotFound is returned when no key in the JWKS matches a token's kid
+var ErrKeyNotFound = errors.New("auth: signing key not found")
+
+// jwk is a single JSON Web Key as served by Clerk
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// KeySet fetches and caches the RSA public keys of a JWKS document.
+// Keys are refreshed once the cache expires, or early when a token
+// references an unknown kid so that key rotation is picked up.
+type KeySet struct {
+	URL    string
+	Client *http.Client
+	// TTL is how long a fetched key set is trusted before it is refetched
+	TTL time.Duration
+	// MinRefreshInterval limits how often an unknown kid can force a refetch
+	MinRefreshInterval time.Duration
+
+	mu        sync.RWMutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// NewKeySet creates a new KeySet for the given JWKS URL
+func NewKeySet(url string) *KeySet {
+	return &KeySet{
+		URL:                url,
+		Client:             &http.Client{Timeout: 10 * time.Second},
+		TTL:                time.Hour,
+		MinRefreshInterval: time.Minute,
+	}
+}
+
+// Key returns the public key with the given kid
+func (ks *KeySet) Key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	fresh := time.Since(ks.fetchedAt) < ks.TTL
+	ks.mu.RUnlock()
+
+	if ok && fresh {
+		return key, nil
+	}
+
+	if err := ks.refresh(ctx, !ok); err != nil {
+		// Keep serving a known key if the JWKS endpoint is temporarily down
+		if ok {
+			return key, nil
+		}
+		return nil, err
+	}
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if key, ok := ks.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// refresh refetches the key set. When the refresh is triggered by an unknown
+// kid it is skipped if the keys were fetched within MinRefreshInterval.
+func (ks *KeySet) refresh(ctx context.Context, unknownKid bool) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	age := time.Since(ks.fetchedAt)
+	if ks.keys != nil {
+		if unknownKid && age < ks.MinRefreshInterval {
+			return nil
+		}
+		if !unknownKid && age < ks.TTL {
+			// Another request refreshed the keys while we waited for the lock
+			return nil
+		}
+	}
+
+	keys, err := ks.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	ks.keys = keys
+	ks.fetchedAt = time.Now()
+	return nil
+}
+
+// fetch downloads and parses the JWKS document
+func (ks *KeySet) fetch(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build JWKS request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	client := ks.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		key, err := k.rsaPublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+// rsaPublicKey decodes the modulus and exponent of an RSA JWK
+func (k jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("exponent too large")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/policy"
+)
+
+// sessionCookie is the cookie Clerk stores same-origin session tokens in
+const sessionCookie = "__session"
+
+var (
+	// ErrUnauthenticated is reported when a request has no valid session token
+	ErrUnauthenticated = errors.New("auth: unauthenticated")
+	// ErrNotProvisioned is reported when a verified Clerk user has no user record
+	ErrNotProvisioned = errors.New("auth: user not provisioned")
+	// ErrInsufficientRole is reported when a user's role is below the one a route requires
+	ErrInsufficientRole = errors.New("auth: insufficient role")
+)
+
+// ErrorHandler renders an authentication failure
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+type contextKey int
+
+const (
+	clerkIDKey contextKey = iota
+	claimsKey
+	userKey
+	subjectKey
+)
+
+// Authenticator verifies Clerk session tokens on incoming requests
+type Authenticator struct {
+	verifier *Verifier
+	users    models.UserStore
+	// seedAdmin is the Clerk user promoted to admin while there are none
+	seedAdmin string
+	// API, when set, is used to look up the profile of users being
+	// provisioned; otherwise only the token's email and name claims are used
+	API *ClerkAPI
+	// OnError renders failures; it defaults to a plain-text response
+	OnError ErrorHandler
+}
+
+// NewAuthenticator creates a new Authenticator. If seedAdminClerkID is set,
+// that Clerk user is made an admin when they sign in and there are no admins
+// yet, so that the first admin can be bootstrapped from config.
+func NewAuthenticator(verifier *Verifier, users models.UserStore, seedAdminClerkID string) *Authenticator {
+	return &Authenticator{verifier: verifier, users: users, seedAdmin: seedAdminClerkID, OnError: defaultErrorHandler}
+}
+
+// Middleware verifies the request's session token and stores the Clerk user
+// ID in the request context, along with the matching user if one exists and
+// the policy subject for the caller.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return a.authenticate(next, false)
+}
+
+// Optional is Middleware for routes that anonymous callers may use too:
+// requests without a session token are passed on unauthenticated, while
+// invalid tokens are still rejected.
+func (a *Authenticator) Optional(next http.Handler) http.Handler {
+	return a.authenticate(next, true)
+}
+
+// authenticate verifies session tokens, letting requests without one
+// through if optional is set
+func (a *Authenticator) authenticate(next http.Handler, optional bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := tokenFromRequest(r)
+		if token == "" && optional {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if token == "" {
+			a.OnError(w, r, ErrUnauthenticated)
+			return
+		}
+
+		claims, err := a.verifier.Verify(r.Context(), token)
+		if err != nil {
+			a.OnError(w, r, fmt.Errorf("%w: %v", ErrUnauthenticated, err))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), clerkIDKey, claims.Subject)
+		ctx = context.WithValue(ctx, claimsKey, claims)
+		ctx = context.WithValue(ctx, subjectKey, policy.Subject{})
+
+		user, err := a.users.GetUserByClerkID(r.Context(), claims.Subject)
+		if err != nil && !errors.Is(err, models.ErrNotFound) {
+			a.OnError(w, r, fmt.Errorf("auth: failed to load user %s: %w", claims.Subject, err))
+			return
+		}
+		if user != nil {
+			if ctx, err = a.withUser(ctx, user); err != nil {
+				a.OnError(w, r, err)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ProvisionUser creates a user record for a Clerk user who does not have one
+// yet, from their Clerk profile, so that later handlers always have a user.
+// Requests that Optional let through unauthenticated are passed on as they are.
+func (a *Authenticator) ProvisionUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if UserFromContext(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, _ := r.Context().Value(claimsKey).(*Claims)
+		if claims == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user := &models.User{ClerkID: claims.Subject, Email: claims.Email, Name: claims.Name}
+		if a.API != nil {
+			profile, err := a.API.GetUser(r.Context(), claims.Subject)
+			if err != nil {
+				a.OnError(w, r, fmt.Errorf("auth: failed to provision user %s: %w", claims.Subject, err))
+				return
+			}
+			user.Email, user.Name = profile.PrimaryEmail(), profile.FullName()
+		}
+
+		if err := a.users.ProvisionUser(r.Context(), user); err != nil {
+			a.OnError(w, r, fmt.Errorf("auth: failed to provision user %s: %w", claims.Subject, err))
+			return
+		}
+
+		ctx, err := a.withUser(r.Context(), user)
+		if err != nil {
+			a.OnError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// withUser stores user and its policy subject in ctx, seeding the first
+// admin if user is the configured one
+func (a *Authenticator) withUser(ctx context.Context, user *models.User) (context.Context, error) {
+	// Tokens may outlive the user for a short while after deletion
+	if user.DeletedAt != nil {
+		return nil, fmt.Errorf("%w: user deleted", ErrUnauthenticated)
+	}
+
+	if a.seedAdmin != "" && user.ClerkID == a.seedAdmin && user.Role != models.RoleAdmin {
+		seeded, err := a.users.SeedAdmin(ctx, user.ClerkID)
+		if err != nil {
+			return nil, fmt.Errorf("auth: failed to seed admin %s: %w", user.ClerkID, err)
+		}
+		if seeded {
+			log.Printf("auth: seeded user %d as the first admin", user.ID)
+			user.Role = models.RoleAdmin
+		}
+	}
+
+	ctx = context.WithValue(ctx, userKey, user)
+	return context.WithValue(ctx, subjectKey, policy.Subject{UserID: user.ID, Role: user.Role}), nil
+}
+
+// RequireUser rejects requests whose Clerk user has no matching user record
+func (a *Authenticator) RequireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if UserFromContext(r.Context()) == nil {
+			a.OnError(w, r, ErrNotProvisioned)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole rejects requests from users without role or a more privileged one
+func (a *Authenticator) RequireRole(role models.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := UserFromContext(r.Context())
+			if user == nil {
+				a.OnError(w, r, ErrNotProvisioned)
+				return
+			}
+			if !user.Role.AtLeast(role) {
+				a.OnError(w, r, fmt.Errorf("%w: %s required", ErrInsufficientRole, role))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClerkIDFromContext returns the verified Clerk user ID, if any
+func ClerkIDFromContext(ctx context.Context) string {
+	clerkID, _ := ctx.Value(clerkIDKey).(string)
+	return clerkID
+}
+
+// UserFromContext returns the authenticated user, if any
+func UserFromContext(ctx context.Context) *models.User {
+	user, _ := ctx.Value(userKey).(*models.User)
+	return user
+}
+
+// ContextWithUser returns a copy of ctx authenticated as user, as the
+// middleware leaves it, for testing handlers that run behind it
+func ContextWithUser(ctx context.Context, user *models.User) context.Context {
+	ctx = context.WithValue(ctx, clerkIDKey, user.ClerkID)
+	ctx = context.WithValue(ctx, userKey, user)
+	return context.WithValue(ctx, subjectKey, policy.Subject{UserID: user.ID, Role: user.Role})
+}
+
+// SubjectFromContext returns the policy subject for the caller. Requests that
+// were not authenticated get the zero Subject, which is allowed only what
+// anyone is.
+func SubjectFromContext(ctx context.Context) policy.Subject {
+	subject, _ := ctx.Value(subjectKey).(policy.Subject)
+	return subject
+}
+
+// defaultErrorHandler renders authentication failures as plain text
+func defaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	case errors.Is(err, ErrNotProvisioned), errors.Is(err, ErrInsufficientRole):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	default:
+		log.Printf("%v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// tokenFromRequest extracts a session token from the Authorization header,
+// falling back to Clerk's session cookie
+func tokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		return cookie.Value
+	}
+
+	return ""
+}
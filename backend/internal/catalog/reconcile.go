@@ -0,0 +1,109 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/payments"
+)
+
+// Drift is a difference between our products and Stripe
+type Drift struct {
+	// ProductID is the product that has drifted, or 0 for Stripe Products
+	// that none of our products use
+	ProductID       int
+	StripeProductID string
+	Problem         string
+}
+
+func (d Drift) String() string {
+	if d.ProductID == 0 {
+		return fmt.Sprintf("Stripe product %s: %s", d.StripeProductID, d.Problem)
+	}
+	return fmt.Sprintf("product %d: %s", d.ProductID, d.Problem)
+}
+
+// Reconcile compares products, which should be every product we have, with
+// Stripe and returns the differences. It reports products that are not
+// synced or whose Stripe Product or Price does not match, and active Stripe
+// Products created for our products that none of them use.
+func (s *Syncer) Reconcile(ctx context.Context, products []models.Product) ([]Drift, error) {
+	stripeProducts, err := s.stripe.ListProducts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list Stripe products: %w", err)
+	}
+	byID := make(map[string]payments.CatalogProduct, len(stripeProducts))
+	for _, sp := range stripeProducts {
+		byID[sp.ID] = sp
+	}
+
+	var drifts []Drift
+	used := make(map[string]bool, len(products))
+	for i := range products {
+		p := &products[i]
+		used[p.StripeProductID] = true
+
+		problems, err := s.productProblems(ctx, p, byID)
+		if err != nil {
+			return nil, err
+		}
+		for _, problem := range problems {
+			drifts = append(drifts, Drift{ProductID: p.ID, StripeProductID: p.StripeProductID, Problem: problem})
+		}
+	}
+
+	for _, sp := range stripeProducts {
+		if _, ours := sp.Metadata[metadataKey]; ours && sp.Active && !used[sp.ID] {
+			drifts = append(drifts, Drift{
+				StripeProductID: sp.ID,
+				Problem:         fmt.Sprintf("is active but not used by any product (created for product %s)", sp.Metadata[metadataKey]),
+			})
+		}
+	}
+
+	return drifts, nil
+}
+
+// productProblems describes how p differs from its Stripe Product and Price
+func (s *Syncer) productProblems(ctx context.Context, p *models.Product, stripeProducts map[string]payments.CatalogProduct) ([]string, error) {
+	if p.StripeProductID == "" {
+		return []string{"has no Stripe product"}, nil
+	}
+
+	var problems []string
+	sp, ok := stripeProducts[p.StripeProductID]
+	switch {
+	case !ok:
+		return []string{fmt.Sprintf("Stripe product %s does not exist", p.StripeProductID)}, nil
+	case !sp.Active:
+		problems = append(problems, fmt.Sprintf("Stripe product %s is archived", sp.ID))
+	}
+	if sp.Name != p.Name {
+		problems = append(problems, fmt.Sprintf("Stripe product name is %q, expected %q", sp.Name, p.Name))
+	}
+	if sp.Description != p.Description {
+		problems = append(problems, fmt.Sprintf("Stripe product description is %q, expected %q", sp.Description, p.Description))
+	}
+	if id := sp.Metadata[metadataKey]; id != strconv.Itoa(p.ID) {
+		problems = append(problems, fmt.Sprintf("Stripe product is linked to product %q", id))
+	}
+
+	if p.StripePriceID == "" {
+		return append(problems, "has no Stripe price"), nil
+	}
+	price, err := s.stripe.GetPrice(ctx, p.StripePriceID)
+	if errors.Is(err, payments.ErrNotFound) {
+		return append(problems, fmt.Sprintf("Stripe price %s does not exist", p.StripePriceID)), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get Stripe price %s: %w", p.StripePriceID, err)
+	}
+	if problem := s.priceProblem(p, price); problem != "" {
+		problems = append(problems, problem)
+	}
+
+	return problems, nil
+}
@@ -0,0 +1,85 @@
+package catalog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/payments"
+)
+
+func TestReconcileInSync(t *testing.T) {
+	stripe := payments.NewMemoryCatalog()
+	s := NewSyncer(stripe, "usd")
+	products := []models.Product{*syncedProduct(t, s, 1, 1200), *syncedProduct(t, s, 2, 800)}
+
+	drifts, err := s.Reconcile(context.Background(), products)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if len(drifts) != 0 {
+		t.Errorf("drifts = %v, want none", drifts)
+	}
+}
+
+func TestReconcileReportsDrift(t *testing.T) {
+	ctx := context.Background()
+	stripe := payments.NewMemoryCatalog()
+	s := NewSyncer(stripe, "usd")
+
+	renamed := syncedProduct(t, s, 1, 1200)
+	if _, err := stripe.UpdateProduct(ctx, &payments.CatalogProduct{ID: renamed.StripeProductID, Name: "Cup", Description: "A mug", Active: true}); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+
+	repriced := syncedProduct(t, s, 2, 800)
+	repriced.Price = 900
+
+	archived := syncedProduct(t, s, 3, 500)
+	if err := stripe.ArchivePrice(ctx, archived.StripePriceID); err != nil {
+		t.Fatalf("ArchivePrice: %v", err)
+	}
+
+	unsynced := models.Product{ID: 4, Name: "Mug", Price: 1000}
+
+	// A product that was deleted without its Stripe Product being archived
+	orphan := syncedProduct(t, s, 5, 700)
+
+	products := []models.Product{*renamed, *repriced, *archived, unsynced}
+	drifts, err := s.Reconcile(ctx, products)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+
+	want := []Drift{
+		{ProductID: 1, StripeProductID: renamed.StripeProductID, Problem: `Stripe product name is "Cup", expected "Mug"`},
+		{ProductID: 2, StripeProductID: repriced.StripeProductID, Problem: "Stripe price " + repriced.StripePriceID + " is 800, expected 900"},
+		{ProductID: 3, StripeProductID: archived.StripeProductID, Problem: "Stripe price " + archived.StripePriceID + " is archived"},
+		{ProductID: 4, Problem: "has no Stripe product"},
+		{StripeProductID: orphan.StripeProductID, Problem: "is active but not used by any product (created for product 5)"},
+	}
+	if !reflect.DeepEqual(drifts, want) {
+		t.Errorf("drifts =\n%v\nwant\n%v", drifts, want)
+	}
+}
+
+func TestReconcileAfterSyncFixesDrift(t *testing.T) {
+	ctx := context.Background()
+	stripe := payments.NewMemoryCatalog()
+	s := NewSyncer(stripe, "usd")
+	p := syncedProduct(t, s, 1, 1200)
+	p.Price = 1500
+
+	if err := s.Sync(ctx, p); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	drifts, err := s.Reconcile(ctx, []models.Product{*p})
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if len(drifts) != 0 {
+		t.Errorf("drifts = %v, want none", drifts)
+	}
+}
@@ -0,0 +1,141 @@
+// Package catalog keeps the Stripe Products and Prices of our products in
+// step with the products table.
+//
+// Sync only goes one way: the products table is the source of truth and is
+// pushed to Stripe. Changes made to synced Products and Prices in the Stripe
+// dashboard are never copied back. Reconcile reports them as drift, and
+// re-syncing the product overwrites them.
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/payments"
+)
+
+// metadataKey is the Stripe Product metadata key holding our product ID
+const metadataKey = "product_id"
+
+// Syncer creates and updates Stripe Products and Prices for our products
+type Syncer struct {
+	stripe   payments.Catalog
+	currency string
+}
+
+// NewSyncer creates a new Syncer that prices products in currency
+func NewSyncer(stripe payments.Catalog, currency string) *Syncer {
+	return &Syncer{stripe: stripe, currency: currency}
+}
+
+// Sync creates or updates p's Stripe Product and, unless p's Stripe Price
+// already charges p's price, creates a new Price and archives the old one.
+// It sets p's Stripe IDs, which the caller must save.
+//
+// Syncing is idempotent, so a sync that fails part way can be retried.
+func (s *Syncer) Sync(ctx context.Context, p *models.Product) error {
+	product := &payments.CatalogProduct{
+		ID:          p.StripeProductID,
+		Name:        p.Name,
+		Description: p.Description,
+		Active:      true,
+		Metadata:    map[string]string{metadataKey: strconv.Itoa(p.ID)},
+	}
+	if p.StripeProductID != "" {
+		_, err := s.stripe.UpdateProduct(ctx, product)
+		switch {
+		case errors.Is(err, payments.ErrNotFound):
+			// Replace a Stripe Product deleted from the dashboard, along with
+			// its prices
+			p.StripeProductID, p.StripePriceID = "", ""
+		case err != nil:
+			return fmt.Errorf("update Stripe product %s: %w", p.StripeProductID, err)
+		}
+	}
+	if p.StripeProductID == "" {
+		product.ID = ""
+		created, err := s.stripe.CreateProduct(ctx, product, fmt.Sprintf("product-%d", p.ID))
+		if err != nil {
+			return fmt.Errorf("create Stripe product: %w", err)
+		}
+		p.StripeProductID = created.ID
+	}
+
+	// Compare with Stripe rather than the product's previous price, so that
+	// prices which have drifted are replaced too
+	old := p.StripePriceID
+	if old != "" {
+		current, err := s.stripe.GetPrice(ctx, old)
+		if err != nil && !errors.Is(err, payments.ErrNotFound) {
+			return fmt.Errorf("get Stripe price %s: %w", old, err)
+		}
+		if current != nil && s.priceProblem(p, current) == "" {
+			return nil
+		}
+	}
+
+	// The old price is part of the key so that going back to an earlier
+	// amount creates a new price rather than replaying the archived one
+	price, err := s.stripe.CreatePrice(ctx, &payments.CatalogPrice{
+		ProductID:  p.StripeProductID,
+		UnitAmount: int64(p.Price),
+		Currency:   s.currency,
+	}, fmt.Sprintf("product-%d-price-%d-%s-from-%s", p.ID, p.Price, s.currency, old))
+	if err != nil {
+		return fmt.Errorf("create Stripe price: %w", err)
+	}
+	p.StripePriceID = price.ID
+
+	// A stale price left active is reported by Reconcile, so it does not
+	// fail the sync
+	if old != "" {
+		if err := s.stripe.ArchivePrice(ctx, old); err != nil && !errors.Is(err, payments.ErrNotFound) {
+			log.Printf("catalog: failed to archive Stripe price %s of product %d: %v", old, p.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// Archive archives p's Stripe Product and Price, for when p is deleted
+func (s *Syncer) Archive(ctx context.Context, p *models.Product) error {
+	if p.StripePriceID != "" {
+		if err := s.stripe.ArchivePrice(ctx, p.StripePriceID); err != nil && !errors.Is(err, payments.ErrNotFound) {
+			return fmt.Errorf("archive Stripe price %s: %w", p.StripePriceID, err)
+		}
+	}
+
+	if p.StripeProductID != "" {
+		_, err := s.stripe.UpdateProduct(ctx, &payments.CatalogProduct{
+			ID:          p.StripeProductID,
+			Name:        p.Name,
+			Description: p.Description,
+			Active:      false,
+		})
+		if err != nil && !errors.Is(err, payments.ErrNotFound) {
+			return fmt.Errorf("archive Stripe product %s: %w", p.StripeProductID, err)
+		}
+	}
+
+	return nil
+}
+
+// priceProblem describes how price fails to charge for p, or returns "" if
+// it is the right price
+func (s *Syncer) priceProblem(p *models.Product, price *payments.CatalogPrice) string {
+	switch {
+	case !price.Active:
+		return fmt.Sprintf("Stripe price %s is archived", price.ID)
+	case price.ProductID != p.StripeProductID:
+		return fmt.Sprintf("Stripe price %s belongs to Stripe product %s", price.ID, price.ProductID)
+	case price.Currency != s.currency:
+		return fmt.Sprintf("Stripe price %s is in %s, expected %s", price.ID, price.Currency, s.currency)
+	case price.UnitAmount != int64(p.Price):
+		return fmt.Sprintf("Stripe price %s is %d, expected %d", price.ID, price.UnitAmount, p.Price)
+	}
+	return ""
+}
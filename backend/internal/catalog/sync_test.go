@@ -0,0 +1,149 @@
+package catalog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/payments"
+)
+
+// syncedProduct syncs a new product to stripe and returns it
+func syncedProduct(t *testing.T, s *Syncer, id, price int) *models.Product {
+	t.Helper()
+	p := &models.Product{ID: id, Name: "Mug", Description: "A mug", Price: price}
+	if err := s.Sync(context.Background(), p); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	return p
+}
+
+// getPrice returns a price from stripe, failing the test if it does not exist
+func getPrice(t *testing.T, stripe *payments.MemoryCatalog, id string) *payments.CatalogPrice {
+	t.Helper()
+	price, err := stripe.GetPrice(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetPrice(%s): %v", id, err)
+	}
+	return price
+}
+
+func TestSyncCreatesProductAndPrice(t *testing.T) {
+	ctx := context.Background()
+	stripe := payments.NewMemoryCatalog()
+	p := syncedProduct(t, NewSyncer(stripe, "usd"), 7, 1200)
+
+	if p.StripeProductID == "" || p.StripePriceID == "" {
+		t.Fatalf("Stripe IDs not set: product %q, price %q", p.StripeProductID, p.StripePriceID)
+	}
+
+	sp, err := stripe.GetProduct(ctx, p.StripeProductID)
+	if err != nil {
+		t.Fatalf("GetProduct: %v", err)
+	}
+	if !sp.Active || sp.Name != "Mug" || sp.Description != "A mug" || sp.Metadata[metadataKey] != "7" {
+		t.Errorf("Stripe product = %+v", sp)
+	}
+
+	price := getPrice(t, stripe, p.StripePriceID)
+	want := payments.CatalogPrice{ID: p.StripePriceID, ProductID: p.StripeProductID, UnitAmount: 1200, Currency: "usd", Active: true}
+	if *price != want {
+		t.Errorf("Stripe price = %+v, want %+v", *price, want)
+	}
+}
+
+func TestSyncPriceChangeArchivesOldPrice(t *testing.T) {
+	stripe := payments.NewMemoryCatalog()
+	s := NewSyncer(stripe, "usd")
+	p := syncedProduct(t, s, 7, 1200)
+	productID, oldPriceID := p.StripeProductID, p.StripePriceID
+
+	p.Price = 1500
+	if err := s.Sync(context.Background(), p); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	if p.StripeProductID != productID {
+		t.Errorf("Stripe product changed from %s to %s", productID, p.StripeProductID)
+	}
+	if p.StripePriceID == oldPriceID {
+		t.Fatalf("Stripe price was not replaced")
+	}
+	if price := getPrice(t, stripe, p.StripePriceID); !price.Active || price.UnitAmount != 1500 {
+		t.Errorf("new Stripe price = %+v", price)
+	}
+	if price := getPrice(t, stripe, oldPriceID); price.Active {
+		t.Errorf("old Stripe price %s is still active", oldPriceID)
+	}
+}
+
+func TestSyncUnchangedPriceKeepsPrice(t *testing.T) {
+	ctx := context.Background()
+	stripe := payments.NewMemoryCatalog()
+	s := NewSyncer(stripe, "usd")
+	p := syncedProduct(t, s, 7, 1200)
+	priceID := p.StripePriceID
+
+	p.Name = "Large mug"
+	if err := s.Sync(ctx, p); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	if p.StripePriceID != priceID {
+		t.Errorf("Stripe price changed from %s to %s", priceID, p.StripePriceID)
+	}
+	if price := getPrice(t, stripe, priceID); !price.Active {
+		t.Errorf("Stripe price %s was archived", priceID)
+	}
+	sp, err := stripe.GetProduct(ctx, p.StripeProductID)
+	if err != nil {
+		t.Fatalf("GetProduct: %v", err)
+	}
+	if sp.Name != "Large mug" {
+		t.Errorf("Stripe product name = %q, want %q", sp.Name, "Large mug")
+	}
+}
+
+func TestSyncReplacesArchivedPrice(t *testing.T) {
+	ctx := context.Background()
+	stripe := payments.NewMemoryCatalog()
+	s := NewSyncer(stripe, "usd")
+	p := syncedProduct(t, s, 7, 1200)
+	oldPriceID := p.StripePriceID
+	if err := stripe.ArchivePrice(ctx, oldPriceID); err != nil {
+		t.Fatalf("ArchivePrice: %v", err)
+	}
+
+	if err := s.Sync(ctx, p); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	if p.StripePriceID == oldPriceID {
+		t.Fatalf("archived Stripe price was kept")
+	}
+	if price := getPrice(t, stripe, p.StripePriceID); !price.Active || price.UnitAmount != 1200 {
+		t.Errorf("new Stripe price = %+v", price)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	stripe := payments.NewMemoryCatalog()
+	s := NewSyncer(stripe, "usd")
+	p := syncedProduct(t, s, 7, 1200)
+
+	if err := s.Archive(ctx, p); err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+
+	if price := getPrice(t, stripe, p.StripePriceID); price.Active {
+		t.Errorf("Stripe price %s is still active", price.ID)
+	}
+	sp, err := stripe.GetProduct(ctx, p.StripeProductID)
+	if err != nil {
+		t.Fatalf("GetProduct: %v", err)
+	}
+	if sp.Active {
+		t.Errorf("Stripe product %s is still active", sp.ID)
+	}
+}
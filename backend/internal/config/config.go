@@ -1,6 +1,11 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // Config holds all configuration for the application
 type Config struct {
@@ -9,9 +14,62 @@ type Config struct {
 	SupabaseKey      string
 	StripeSecretKey  string
 	StripeWebhookKey string
+	StripeAPIURL     string
 	JWTSecret        string
 	Environment      string
 	AllowedOrigins   string
+	AppURL           string
+	Currency         string
+	MigrateOnStart   bool
+
+	// HTTP server timeouts
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+
+	// HealthCheckTimeout bounds each readiness check
+	HealthCheckTimeout time.Duration
+
+	// Database timeouts
+	DBStartupTimeout time.Duration
+	QueryTimeout     time.Duration
+
+	// Clerk session token verification
+	ClerkJWKSURL           string
+	ClerkIssuer            string
+	ClerkAuthorizedParties []string
+	ClerkWebhookSecret     string
+	ClerkSecretKey         string
+
+	// AdminClerkID is the Clerk user made an admin while there are none
+	AdminClerkID string
+
+	// ReservationTTL is how long unpaid orders hold their stock. Checkout
+	// Sessions expire with the reservation, so Stripe requires it to be
+	// more than 30 minutes and at most 24 hours.
+	ReservationTTL time.Duration
+	// ReservationSweepInterval is how often expired reservations are released
+	ReservationSweepInterval time.Duration
+	// ReservationGracePeriod is how long after a reservation expires its
+	// order is canceled, leaving time for a payment made just before its
+	// Checkout Session expired to be reported
+	ReservationGracePeriod time.Duration
+	// AsyncPaymentTTL is how long orders paid with a delayed payment method,
+	// such as a bank debit, hold their stock while the payment clears
+	AsyncPaymentTTL time.Duration
+
+	// IdempotencyKeyTTL is how long responses are kept for replay to
+	// requests that repeat an Idempotency-Key
+	IdempotencyKeyTTL time.Duration
+	// IdempotencyKeySweepInterval is how often expired keys are deleted
+	IdempotencyKeySweepInterval time.Duration
+
+	// CartTTL is how long anonymous carts are kept after they last change
+	CartTTL time.Duration
+	// CartSweepInterval is how often expired anonymous carts are deleted
+	CartSweepInterval time.Duration
 }
 
 // New creates a new Config
@@ -22,9 +80,43 @@ func New() *Config {
 		SupabaseKey:      getEnv("SUPABASE_KEY", ""),
 		StripeSecretKey:  getEnv("STRIPE_SECRET_KEY", ""),
 		StripeWebhookKey: getEnv("STRIPE_WEBHOOK_SECRET", ""),
+		StripeAPIURL:     getEnv("STRIPE_API_URL", ""),
 		JWTSecret:        getEnv("JWT_SECRET", "your-secret-key"),
 		Environment:      getEnv("ENVIRONMENT", "development"),
 		AllowedOrigins:   getEnv("ALLOWED_ORIGINS", "http://localhost:3000"),
+		AppURL:           getEnv("APP_URL", "http://localhost:3000"),
+		Currency:         getEnv("CURRENCY", "usd"),
+		MigrateOnStart:   getEnvBool("MIGRATE_ON_START", false),
+
+		ReadTimeout:       getEnvDuration("HTTP_READ_TIMEOUT", 15*time.Second),
+		ReadHeaderTimeout: getEnvDuration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		WriteTimeout:      getEnvDuration("HTTP_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       getEnvDuration("HTTP_IDLE_TIMEOUT", 60*time.Second),
+		ShutdownTimeout:   getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+
+		HealthCheckTimeout: getEnvDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+
+		DBStartupTimeout: getEnvDuration("DB_STARTUP_TIMEOUT", 30*time.Second),
+		QueryTimeout:     getEnvDuration("QUERY_TIMEOUT", 10*time.Second),
+
+		ClerkJWKSURL:           getEnv("CLERK_JWKS_URL", ""),
+		ClerkIssuer:            getEnv("CLERK_ISSUER", ""),
+		ClerkAuthorizedParties: getEnvList("CLERK_AUTHORIZED_PARTIES", nil),
+		ClerkWebhookSecret:     getEnv("CLERK_WEBHOOK_SECRET", ""),
+		ClerkSecretKey:         getEnv("CLERK_SECRET_KEY", ""),
+
+		AdminClerkID: getEnv("ADMIN_CLERK_ID", ""),
+
+		ReservationTTL:           getEnvDuration("RESERVATION_TTL", time.Hour),
+		ReservationSweepInterval: getEnvDuration("RESERVATION_SWEEP_INTERVAL", time.Minute),
+		ReservationGracePeriod:   getEnvDuration("RESERVATION_GRACE_PERIOD", 15*time.Minute),
+		AsyncPaymentTTL:          getEnvDuration("ASYNC_PAYMENT_TTL", 14*24*time.Hour),
+
+		IdempotencyKeyTTL:           getEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		IdempotencyKeySweepInterval: getEnvDuration("IDEMPOTENCY_KEY_SWEEP_INTERVAL", time.Hour),
+
+		CartTTL:           getEnvDuration("CART_TTL", 30*24*time.Hour),
+		CartSweepInterval: getEnvDuration("CART_SWEEP_INTERVAL", time.Hour),
 	}
 }
 
@@ -36,3 +128,37 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvBool gets a boolean environment variable or returns a default value
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvDuration gets a duration environment variable (e.g. "5s") or returns a default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvList gets a comma-separated environment variable or returns a default value
+func getEnvList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -1,26 +1,59 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log"
+	"time"
 
 	_ "github.com/lib/pq"
 )
 
+const (
+	// initialBackoff is the delay before the first connection retry
+	initialBackoff = 250 * time.Millisecond
+	// maxBackoff caps the delay between connection retries
+	maxBackoff = 5 * time.Second
+)
+
 // DB is a wrapper around sql.DB
 type DB struct {
 	*sql.DB
 }
 
-// New creates a new database connection
-func New(databaseURL string) (*DB, error) {
+// New creates a new database connection. The database is pinged with
+// exponential backoff until it answers or startupTimeout elapses, so the
+// server can start alongside a database that is still coming up.
+func New(ctx context.Context, databaseURL string, startupTimeout time.Duration) (*DB, error) {
 	db, err := sql.Open("postgres", databaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+	ctx, cancel := context.WithTimeout(ctx, startupTimeout)
+	defer cancel()
+
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		err = db.PingContext(ctx)
+		if err == nil {
+			break
+		}
+
+		log.Printf("Database ping attempt %d failed: %v", attempt, err)
+
+		select {
+		case <-ctx.Done():
+			db.Close()
+			return nil, fmt.Errorf("failed to ping database after %d attempts: %w", attempt, err)
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
 	}
 
 	return &DB{db}, nil
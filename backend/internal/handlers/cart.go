@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/your-username/your-repo/internal/auth"
+	"github.com/your-username/your-repo/internal/config"
+	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/policy"
+)
+
+// cartCookie holds the token of an anonymous visitor's cart
+const cartCookie = "cart_token"
+
+// CartHandler handles HTTP requests for the caller's shopping cart. Signed-in
+// users have a cart of their own; anonymous visitors get one identified by a
+// cookie, which is merged into the user's cart once they sign in.
+type CartHandler struct {
+	carts  models.CartStore
+	config *config.Config
+}
+
+// NewCartHandler creates a new CartHandler
+func NewCartHandler(carts models.CartStore, cfg *config.Config) *CartHandler {
+	return &CartHandler{carts: carts, config: cfg}
+}
+
+// addCartItemRequest is the body of a request to add a product to the cart
+type addCartItemRequest struct {
+	ProductID int `json:"product_id" validate:"gt=0"`
+	Quantity  int `json:"quantity" validate:"gt=0,max=1000"`
+}
+
+// updateCartItemRequest is the body of a request to change a cart item
+type updateCartItemRequest struct {
+	Quantity int `json:"quantity" validate:"gt=0,max=1000"`
+}
+
+// createCartOrderRequest is the optional body of a request to order the
+// cart
+type createCartOrderRequest struct {
+	CouponCode string `json:"coupon_code" validate:"max=64"`
+}
+
+// owner returns whose cart the caller's is, first merging the anonymous
+// cart named by the cookie into the user's if the caller has signed in
+// since. Anonymous callers without a cookie are given one if provision is
+// set, and otherwise have no cart, reported by ok being false.
+func (h *CartHandler) owner(w http.ResponseWriter, r *http.Request, provision bool) (owner models.CartOwner, ok bool, err error) {
+	if cookie, err := r.Cookie(cartCookie); err == nil {
+		owner.Token = cookie.Value
+	}
+
+	if user := auth.UserFromContext(r.Context()); user != nil {
+		if owner.Token != "" {
+			if err := h.carts.MergeCart(r.Context(), owner.Token, user.ID); err != nil {
+				return owner, false, err
+			}
+			h.setCookie(w, "", -1)
+		}
+		return models.CartOwner{UserID: user.ID}, true, nil
+	}
+
+	if !provision {
+		return owner, owner.Token != "", nil
+	}
+	if owner.Token == "" {
+		if owner.Token, err = newCartToken(); err != nil {
+			return owner, false, err
+		}
+	}
+	// Renew the cookie so that carts in use do not expire
+	h.setCookie(w, owner.Token, int(h.config.CartTTL/time.Second))
+	return owner, true, nil
+}
+
+// cart returns the caller's cart and its owner, or a nil cart if they have
+// none
+func (h *CartHandler) cart(w http.ResponseWriter, r *http.Request) (*models.Cart, models.CartOwner, error) {
+	owner, ok, err := h.owner(w, r, false)
+	if err != nil || !ok {
+		return nil, owner, err
+	}
+
+	cart, err := h.carts.GetCart(r.Context(), owner)
+	if errors.Is(err, models.ErrNotFound) {
+		return nil, owner, nil
+	}
+	return cart, owner, err
+}
+
+// setCookie sets the cart cookie, or deletes it if maxAge is negative
+func (h *CartHandler) setCookie(w http.ResponseWriter, token string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cartCookie,
+		Value:    token,
+		Path:     "/api/cart",
+		MaxAge:   maxAge,
+		Secure:   h.config.Environment != "development",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// newCartToken returns a random token for an anonymous cart
+func newCartToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// respondCart sends the cart of owner with status, priced at current prices
+func (h *CartHandler) respondCart(w http.ResponseWriter, r *http.Request, owner models.CartOwner, status int) {
+	cart, err := h.carts.GetCart(r.Context(), owner)
+	if err != nil {
+		respondError(w, r, orNotFound(err, "Cart not found"))
+		return
+	}
+
+	respondJSON(w, status, cart)
+}
+
+// Get returns the caller's cart, which is empty if they have none yet
+func (h *CartHandler) Get(w http.ResponseWriter, r *http.Request) {
+	cart, _, err := h.cart(w, r)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+	if cart == nil {
+		cart = &models.Cart{Items: []models.CartItem{}}
+	}
+
+	respondJSON(w, http.StatusOK, cart)
+}
+
+// AddItem adds a quantity of a product to the caller's cart and returns the
+// cart
+func (h *CartHandler) AddItem(w http.ResponseWriter, r *http.Request) {
+	var req addCartItemRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	owner, _, err := h.owner(w, r, true)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+	cart, err := h.carts.ProvisionCart(r.Context(), owner)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+	if err := h.carts.AddCartItem(r.Context(), cart.ID, req.ProductID, req.Quantity); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	h.respondCart(w, r, owner, http.StatusCreated)
+}
+
+// UpdateItem sets the quantity of an item in the caller's cart and returns
+// the cart
+func (h *CartHandler) UpdateItem(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, r, errBadRequest("Invalid cart item ID"))
+		return
+	}
+
+	var req updateCartItemRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	cart, owner, err := h.cart(w, r)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+	if cart == nil {
+		respondError(w, r, errNotFound("Cart item not found"))
+		return
+	}
+	if err := h.carts.SetCartItemQuantity(r.Context(), cart.ID, id, req.Quantity); err != nil {
+		respondError(w, r, orNotFound(err, "Cart item not found"))
+		return
+	}
+
+	h.respondCart(w, r, owner, http.StatusOK)
+}
+
+// RemoveItem removes an item from the caller's cart and returns the cart
+func (h *CartHandler) RemoveItem(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, r, errBadRequest("Invalid cart item ID"))
+		return
+	}
+
+	cart, owner, err := h.cart(w, r)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+	if cart == nil {
+		respondError(w, r, errNotFound("Cart item not found"))
+		return
+	}
+	if err := h.carts.RemoveCartItem(r.Context(), cart.ID, id); err != nil {
+		respondError(w, r, orNotFound(err, "Cart item not found"))
+		return
+	}
+
+	h.respondCart(w, r, owner, http.StatusOK)
+}
+
+// Clear removes every item from the caller's cart
+func (h *CartHandler) Clear(w http.ResponseWriter, r *http.Request) {
+	cart, _, err := h.cart(w, r)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+	if cart != nil {
+		if err := h.carts.ClearCart(r.Context(), cart.ID); err != nil {
+			respondError(w, r, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateOrder converts the signed-in user's cart into a pending order at
+// current prices, reserving its stock, and empties the cart. The body is
+// optional and may name a coupon to apply.
+func (h *CartHandler) CreateOrder(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+	if user == nil {
+		respondError(w, r, auth.ErrUnauthenticated)
+		return
+	}
+
+	var req createCartOrderRequest
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, &req); err != nil {
+			respondError(w, r, err)
+			return
+		}
+	}
+
+	reservedUntil := time.Now().Add(h.config.ReservationTTL)
+	order := models.Order{
+		UserID:        user.ID,
+		Status:        models.OrderStatusPending,
+		ReservedUntil: &reservedUntil,
+		CouponCode:    req.CouponCode,
+	}
+	if err := authorize(r, policy.ActionCreate, orderResource(&order)); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	cart, _, err := h.cart(w, r)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+	if cart == nil {
+		respondError(w, r, models.ErrCartEmpty)
+		return
+	}
+	if err := h.carts.ConvertCart(r.Context(), cart.ID, &order); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, order)
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/your-username/your-repo/internal/auth"
+	"github.com/your-username/your-repo/internal/config"
+	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/payments"
+	"github.com/your-username/your-repo/internal/policy"
+)
+
+// CheckoutHandler handles HTTP requests for checkout
+type CheckoutHandler struct {
+	orders   models.OrderStore
+	products models.ProductStore
+	config   *config.Config
+	payments payments.Client
+}
+
+// NewCheckoutHandler creates a new CheckoutHandler
+func NewCheckoutHandler(orders models.OrderStore, products models.ProductStore, cfg *config.Config, pc payments.Client) *CheckoutHandler {
+	return &CheckoutHandler{orders: orders, products: products, config: cfg, payments: pc}
+}
+
+// checkoutResponse is returned once a Checkout Session has been created
+type checkoutResponse struct {
+	OrderID   int    `json:"order_id"`
+	SessionID string `json:"session_id"`
+	URL       string `json:"url"`
+}
+
+// Create creates a pending order, reserving its stock, and a Stripe Checkout
+// Session for it that expires with the reservation
+func (h *CheckoutHandler) Create(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFromContext(r.Context())
+
+	var req createOrderRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	reservedUntil := time.Now().Add(h.config.ReservationTTL)
+	order := models.Order{
+		UserID:        user.ID,
+		Status:        models.OrderStatusPending,
+		ReservedUntil: &reservedUntil,
+		Items:         req.orderItems(),
+		CouponCode:    req.CouponCode,
+	}
+	if err := authorize(r, policy.ActionCreate, orderResource(&order)); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	if err := h.orders.CreateOrder(r.Context(), &order); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	productIDs := make([]int, len(order.Items))
+	for i, item := range order.Items {
+		productIDs[i] = item.ProductID
+	}
+	products, err := h.products.GetProductsByIDs(r.Context(), productIDs)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	// Charge the prices snapshotted on the order, not the current catalog.
+	// Products synced to Stripe are charged through their Stripe Price, so
+	// payments show up against it in Stripe, unless the price has changed
+	// since the order was placed.
+	lineItems := make([]payments.CheckoutLineItem, len(order.Items))
+	for i, item := range order.Items {
+		product := products[item.ProductID]
+		lineItems[i] = payments.CheckoutLineItem{
+			Name:        product.Name,
+			Description: product.Description,
+			UnitAmount:  int64(item.Price),
+			Quantity:    int64(item.Quantity),
+		}
+		if product.StripePriceID != "" && product.Price == item.Price {
+			lineItems[i].Price = product.StripePriceID
+		}
+	}
+
+	// The line items are at full price, with the order's discount taken off
+	// the session as a whole
+	var discount *payments.CheckoutDiscount
+	if order.Discount > 0 {
+		discount = &payments.CheckoutDiscount{Name: order.CouponCode, AmountOff: int64(order.Discount)}
+	}
+
+	session, err := h.payments.CreateCheckoutSession(r.Context(), &payments.CheckoutSessionParams{
+		OrderID:       order.ID,
+		Currency:      h.config.Currency,
+		CustomerEmail: user.Email,
+		LineItems:     lineItems,
+		Discount:      discount,
+		SuccessURL:    h.config.AppURL + "/checkout/success?session_id={CHECKOUT_SESSION_ID}",
+		CancelURL:     h.config.AppURL + "/checkout/canceled",
+		// The order's stock is released when its reservation expires, so
+		// stop taking payment for it then
+		ExpiresAt: reservedUntil,
+	})
+	if err != nil {
+		sessionErr := err
+		err := h.orders.TransitionOrder(r.Context(), order.ID, models.OrderStatusPending, models.OrderStatusCanceled, "system:checkout")
+		if err != nil {
+			log.Printf("checkout: failed to cancel order %d: %v", order.ID, err)
+		}
+		respondError(w, r, errBadGateway("Failed to create checkout session", sessionErr))
+		return
+	}
+
+	order.StripeSessionID = session.ID
+	if err := h.orders.SetOrderStripeSessionID(r.Context(), order.ID, session.ID); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, checkoutResponse{
+		OrderID:   order.ID,
+		SessionID: session.ID,
+		URL:       session.URL,
+	})
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-username/your-repo/internal/auth"
+	"github.com/your-username/your-repo/internal/config"
+	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/payments"
+)
+
+// fakePayments is a payments.Client that records the calls made to it
+type fakePayments struct {
+	sessions []payments.CheckoutSessionParams
+	refunds  []payments.RefundParams
+	// sessionForIntent maps PaymentIntent IDs to Checkout Session IDs
+	sessionForIntent map[string]string
+	// lookups counts CheckoutSessionIDForPaymentIntent calls
+	lookups int
+	// err, if set, is returned by every call
+	err error
+}
+
+func (f *fakePayments) CreateCheckoutSession(ctx context.Context, p *payments.CheckoutSessionParams) (*payments.CheckoutSession, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.sessions = append(f.sessions, *p)
+	id := fmt.Sprintf("cs_test_%d", len(f.sessions))
+	return &payments.CheckoutSession{ID: id, URL: "https://checkout.stripe.test/" + id}, nil
+}
+
+func (f *fakePayments) CheckoutSessionIDForPaymentIntent(ctx context.Context, paymentIntentID string) (string, error) {
+	f.lookups++
+	if f.err != nil {
+		return "", f.err
+	}
+	return f.sessionForIntent[paymentIntentID], nil
+}
+
+func (f *fakePayments) RefundPayment(ctx context.Context, p *payments.RefundParams) (*payments.Refund, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.refunds = append(f.refunds, *p)
+	return &payments.Refund{ID: "re_test", Status: "succeeded"}, nil
+}
+
+// errTestStripe is returned by fakePayments to simulate a Stripe outage
+var errTestStripe = errors.New("stripe is down")
+
+// repricedProducts is a ProductStore whose products have been repriced
+// since orders for them were placed
+type repricedProducts struct {
+	models.ProductStore
+	price int
+}
+
+func (s repricedProducts) GetProductsByIDs(ctx context.Context, ids []int) (map[int]models.Product, error) {
+	products, err := s.ProductStore.GetProductsByIDs(ctx, ids)
+	for id, p := range products {
+		p.Price = s.price
+		products[id] = p
+	}
+	return products, err
+}
+
+// testCheckoutConfig is the config checkout handlers are tested with
+var testCheckoutConfig = &config.Config{AppURL: "http://shop.test", Currency: "usd", ReservationTTL: time.Hour}
+
+// checkoutFixture is a store with a customer and a product synced to Stripe
+type checkoutFixture struct {
+	store   *models.MemoryStore
+	user    *models.User
+	product *models.Product
+}
+
+func newCheckoutFixture(t *testing.T) checkoutFixture {
+	t.Helper()
+	ctx := context.Background()
+	store := models.NewMemoryStore()
+
+	user := &models.User{ClerkID: "user_1", Email: "ada@example.com", Name: "Ada", Role: models.RoleCustomer}
+	if err := store.CreateUser(ctx, user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	stock := 5
+	product := &models.Product{Name: "Mug", Description: "A mug", Price: 1200, Stock: &stock, StripePriceID: "price_mug"}
+	if err := store.CreateProduct(ctx, product); err != nil {
+		t.Fatalf("CreateProduct: %v", err)
+	}
+
+	return checkoutFixture{store: store, user: user, product: product}
+}
+
+// checkout sends a checkout request for two of the fixture's product
+func (f checkoutFixture) checkout(h *CheckoutHandler) *httptest.ResponseRecorder {
+	body := `{"items":[{"product_id":` + strconv.Itoa(f.product.ID) + `,"quantity":2}]}`
+	r := httptest.NewRequest(http.MethodPost, "/api/checkout", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r = r.WithContext(auth.ContextWithUser(r.Context(), f.user))
+
+	w := httptest.NewRecorder()
+	h.Create(w, r)
+	return w
+}
+
+// onlyOrder returns the single order in store
+func onlyOrder(t *testing.T, store *models.MemoryStore) models.Order {
+	t.Helper()
+	orders, _, err := store.GetOrders(context.Background(), models.OrderFilter{}, models.Page{})
+	if err != nil {
+		t.Fatalf("GetOrders: %v", err)
+	}
+	if len(orders) != 1 {
+		t.Fatalf("got %d orders, want 1", len(orders))
+	}
+	return orders[0]
+}
+
+func TestCheckoutCreatesSession(t *testing.T) {
+	f := newCheckoutFixture(t)
+	pc := &fakePayments{}
+	w := f.checkout(NewCheckoutHandler(f.store, f.store, testCheckoutConfig, pc))
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var resp checkoutResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	order := onlyOrder(t, f.store)
+	if order.StripeSessionID != resp.SessionID || resp.SessionID == "" {
+		t.Errorf("order session = %q, response session = %q", order.StripeSessionID, resp.SessionID)
+	}
+	if resp.OrderID != order.ID || resp.URL == "" {
+		t.Errorf("response = %+v", resp)
+	}
+
+	if len(pc.sessions) != 1 {
+		t.Fatalf("created %d sessions, want 1", len(pc.sessions))
+	}
+	session := pc.sessions[0]
+	if order.ReservedUntil == nil || !session.ExpiresAt.Equal(*order.ReservedUntil) {
+		t.Errorf("session expires at %v, reservation ends at %v", session.ExpiresAt, order.ReservedUntil)
+	}
+	want := payments.CheckoutLineItem{Price: "price_mug", Name: "Mug", Description: "A mug", UnitAmount: 1200, Quantity: 2}
+	if len(session.LineItems) != 1 || session.LineItems[0] != want {
+		t.Errorf("line items = %+v, want [%+v]", session.LineItems, want)
+	}
+}
+
+func TestCheckoutChargesSnapshottedPrices(t *testing.T) {
+	f := newCheckoutFixture(t)
+	pc := &fakePayments{}
+	products := repricedProducts{ProductStore: f.store, price: 1500}
+	w := f.checkout(NewCheckoutHandler(f.store, products, testCheckoutConfig, pc))
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	if len(pc.sessions) != 1 {
+		t.Fatalf("created %d sessions, want 1", len(pc.sessions))
+	}
+
+	// The Stripe Price is for the new price, so the order's price is
+	// charged ad hoc instead
+	want := payments.CheckoutLineItem{Name: "Mug", Description: "A mug", UnitAmount: 1200, Quantity: 2}
+	if items := pc.sessions[0].LineItems; len(items) != 1 || items[0] != want {
+		t.Errorf("line items = %+v, want [%+v]", items, want)
+	}
+}
+
+func TestCheckoutCancelsOrderWhenSessionFails(t *testing.T) {
+	f := newCheckoutFixture(t)
+	pc := &fakePayments{err: errTestStripe}
+	w := f.checkout(NewCheckoutHandler(f.store, f.store, testCheckoutConfig, pc))
+
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusBadGateway, w.Body)
+	}
+	if order := onlyOrder(t, f.store); order.Status != models.OrderStatusCanceled {
+		t.Errorf("order status = %s, want %s", order.Status, models.OrderStatusCanceled)
+	}
+
+	product, err := f.store.GetProductByID(context.Background(), f.product.ID)
+	if err != nil {
+		t.Fatalf("GetProductByID: %v", err)
+	}
+	if *product.Stock != 5 {
+		t.Errorf("stock = %d, want the reservation released to 5", *product.Stock)
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/your-username/your-repo/internal/auth"
+	"github.com/your-username/your-repo/internal/models"
+)
+
+// maxClerkWebhookBodyBytes caps the size of Clerk webhook payloads, which
+// carry the whole user object
+const maxClerkWebhookBodyBytes = 1 << 20
+
+// clerkEvent is a Clerk webhook event
+type clerkEvent struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+	// Timestamp is when the event occurred, in milliseconds
+	Timestamp int64 `json:"timestamp"`
+}
+
+// clerkDeletedObject is the data of a user.deleted event
+type clerkDeletedObject struct {
+	ID string `json:"id"`
+}
+
+// Clerk verifies and processes a Clerk webhook event, keeping users in sync
+// with Clerk. Events are ordered by when the user changed rather than when
+// they were delivered, so redeliveries and out-of-order events that are older
+// than the user's current state are acknowledged and ignored.
+func (h *WebhookHandler) Clerk(w http.ResponseWriter, r *http.Request) {
+	if h.clerk == nil {
+		respondError(w, r, newAPIError(http.StatusServiceUnavailable, CodeServiceUnavailable, "Clerk webhooks are not configured"))
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxClerkWebhookBodyBytes))
+	if err != nil {
+		respondError(w, r, errBadRequest("Failed to read request body"))
+		return
+	}
+
+	signedAt, err := h.clerk.Verify(r.Header, payload)
+	if err != nil {
+		respondError(w, r, errBadRequest("Invalid signature"))
+		return
+	}
+
+	var event clerkEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		respondError(w, r, errBadRequest("Invalid event payload"))
+		return
+	}
+
+	msgID := r.Header.Get("svix-id")
+	applied, err := h.syncClerkUser(r, event, signedAt)
+	if err != nil {
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			err = fmt.Errorf("clerk webhook %s (%s): %w", msgID, event.Type, err)
+		}
+		respondError(w, r, err)
+		return
+	}
+
+	if !applied {
+		log.Printf("clerk webhook %s (%s): stale or unhandled, ignored", msgID, event.Type)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// syncClerkUser applies a user event and reports whether it changed anything
+func (h *WebhookHandler) syncClerkUser(r *http.Request, event clerkEvent, signedAt time.Time) (bool, error) {
+	switch event.Type {
+	case "user.created", "user.updated":
+		var data auth.ClerkUser
+		if err := json.Unmarshal(event.Data, &data); err != nil || data.ID == "" {
+			return false, errBadRequest("Invalid user data")
+		}
+
+		user := models.User{ClerkID: data.ID, Email: data.PrimaryEmail(), Name: data.FullName()}
+		return h.users.UpsertClerkUser(r.Context(), &user, eventTime(data.UpdatedAt, event.Timestamp, signedAt))
+
+	case "user.deleted":
+		var data clerkDeletedObject
+		if err := json.Unmarshal(event.Data, &data); err != nil || data.ID == "" {
+			return false, errBadRequest("Invalid user data")
+		}
+
+		return h.users.DeleteClerkUser(r.Context(), data.ID, eventTime(0, event.Timestamp, signedAt))
+	}
+
+	return false, nil
+}
+
+// eventTime returns the first of the given millisecond timestamps that is
+// set, falling back to when the message was signed. Svix re-signs retries,
+// so the signing time is only a last resort for ordering.
+func eventTime(updatedAt, timestamp int64, signedAt time.Time) time.Time {
+	switch {
+	case updatedAt > 0:
+		return time.UnixMilli(updatedAt)
+	case timestamp > 0:
+		return time.UnixMilli(timestamp)
+	}
+	return signedAt
+}
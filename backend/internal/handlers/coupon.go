@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/policy"
+)
+
+// CouponHandler handles HTTP requests for managing coupon codes
+type CouponHandler struct {
+	coupons models.CouponStore
+}
+
+// NewCouponHandler creates a new CouponHandler
+func NewCouponHandler(coupons models.CouponStore) *CouponHandler {
+	return &CouponHandler{coupons: coupons}
+}
+
+// couponRequest is the body of a coupon create or update request, mirroring
+// the coupons table's constraints. Codes are case-insensitive.
+type couponRequest struct {
+	Code                  string            `json:"code" validate:"required,max=64"`
+	Kind                  models.CouponKind `json:"kind" validate:"required,oneof=percent fixed"`
+	Value                 int               `json:"value" validate:"gt=0"`
+	ProductIDs            []int             `json:"product_ids" validate:"max=100"`
+	MinSubtotal           int               `json:"min_subtotal" validate:"min=0"`
+	StartsAt              *time.Time        `json:"starts_at"`
+	EndsAt                *time.Time        `json:"ends_at"`
+	MaxRedemptions        *int              `json:"max_redemptions" validate:"gt=0"`
+	MaxRedemptionsPerUser *int              `json:"max_redemptions_per_user" validate:"gt=0"`
+}
+
+// validate checks the rules that span fields or elements
+func (req couponRequest) validate() error {
+	var fields []FieldError
+	if strings.TrimSpace(req.Code) == "" {
+		fields = append(fields, FieldError{Field: "code", Message: "must not be blank"})
+	}
+	if req.Kind == models.CouponPercent && req.Value > 100 {
+		fields = append(fields, FieldError{Field: "value", Message: "must be at most 100 for percent coupons"})
+	}
+	for i, id := range req.ProductIDs {
+		if id <= 0 {
+			fields = append(fields, FieldError{Field: fmt.Sprintf("product_ids[%d]", i), Message: "must be greater than 0"})
+		}
+	}
+	if req.StartsAt != nil && req.EndsAt != nil && !req.EndsAt.After(*req.StartsAt) {
+		fields = append(fields, FieldError{Field: "ends_at", Message: "must be after starts_at"})
+	}
+	if len(fields) > 0 {
+		return errValidation(fields...)
+	}
+	return nil
+}
+
+// coupon converts the request to a coupon
+func (req couponRequest) coupon() models.Coupon {
+	productIDs := req.ProductIDs
+	if productIDs == nil {
+		productIDs = []int{}
+	}
+	return models.Coupon{
+		Code:                  req.Code,
+		Kind:                  req.Kind,
+		Value:                 req.Value,
+		ProductIDs:            productIDs,
+		MinSubtotal:           req.MinSubtotal,
+		StartsAt:              req.StartsAt,
+		EndsAt:                req.EndsAt,
+		MaxRedemptions:        req.MaxRedemptions,
+		MaxRedemptionsPerUser: req.MaxRedemptionsPerUser,
+	}
+}
+
+// couponResource is the policy resource for coupons, which have no owner
+var couponResource = policy.Resource{Kind: policy.KindCoupon}
+
+// List returns every coupon
+func (h *CouponHandler) List(w http.ResponseWriter, r *http.Request) {
+	if err := authorize(r, policy.ActionList, couponResource); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	coupons, err := h.coupons.GetCoupons(r.Context())
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, coupons)
+}
+
+// Get returns a coupon by ID
+func (h *CouponHandler) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, r, errBadRequest("Invalid coupon ID"))
+		return
+	}
+
+	if err := authorize(r, policy.ActionRead, couponResource); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	coupon, err := h.coupons.GetCouponByID(r.Context(), id)
+	if err != nil {
+		respondError(w, r, orNotFound(err, "Coupon not found"))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, coupon)
+}
+
+// Create creates a new coupon
+func (h *CouponHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if err := authorize(r, policy.ActionCreate, couponResource); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	var req couponRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, r, err)
+		return
+	}
+	if err := req.validate(); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	coupon := req.coupon()
+	if err := h.coupons.CreateCoupon(r.Context(), &coupon); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, coupon)
+}
+
+// Update updates a coupon. Orders already placed with it keep their
+// discounts.
+func (h *CouponHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, r, errBadRequest("Invalid coupon ID"))
+		return
+	}
+
+	if err := authorize(r, policy.ActionUpdate, couponResource); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	var req couponRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, r, err)
+		return
+	}
+	if err := req.validate(); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	coupon := req.coupon()
+	coupon.ID = id
+	if err := h.coupons.UpdateCoupon(r.Context(), &coupon); err != nil {
+		respondError(w, r, orNotFound(err, "Coupon not found"))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, coupon)
+}
+
+// Delete deletes a coupon that no order was placed with
+func (h *CouponHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, r, errBadRequest("Invalid coupon ID"))
+		return
+	}
+
+	if err := authorize(r, policy.ActionDelete, couponResource); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	if err := h.coupons.DeleteCoupon(r.Context(), id); err != nil {
+		respondError(w, r, orNotFound(err, "Coupon not found"))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/lib/pq"
+	"github.com/your-username/your-repo/internal/auth"
+	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/policy"
+	"github.com/your-username/your-repo/internal/validation"
+)
+
+// Error codes returned to clients
+const (
+	CodeBadRequest         = "bad_request"
+	CodeValidation         = "validation_failed"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeOutOfStock         = "out_of_stock"
+	CodeBadGateway         = "bad_gateway"
+	CodeTimeout            = "timeout"
+	CodeServiceUnavailable = "service_unavailable"
+	CodeInternal           = "internal_error"
+)
+
+// FieldError describes a problem with a single request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// APIError is an error that is safe to show to clients. Its cause, if any,
+// is only logged server-side.
+type APIError struct {
+	Status    int          `json:"-"`
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	Details   interface{}  `json:"details,omitempty"` // Error-specific data, such as out-of-stock items
+	RequestID string       `json:"request_id,omitempty"`
+
+	cause error
+}
+
+func (e *APIError) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.cause)
+	}
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.cause
+}
+
+// errorEnvelope is the JSON body of every error response
+type errorEnvelope struct {
+	Error *APIError `json:"error"`
+}
+
+// newAPIError creates an APIError
+func newAPIError(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// errBadRequest reports a malformed request
+func errBadRequest(message string) *APIError {
+	return newAPIError(http.StatusBadRequest, CodeBadRequest, message)
+}
+
+// errValidation reports request fields that failed validation
+func errValidation(fields ...FieldError) *APIError {
+	e := newAPIError(http.StatusUnprocessableEntity, CodeValidation, "Request validation failed")
+	e.Fields = fields
+	return e
+}
+
+// errNotFound reports a missing resource
+func errNotFound(message string) *APIError {
+	return newAPIError(http.StatusNotFound, CodeNotFound, message)
+}
+
+// errConflict reports a request that conflicts with the resource's state
+func errConflict(message string) *APIError {
+	return newAPIError(http.StatusConflict, CodeConflict, message)
+}
+
+// errOutOfStock reports order items there is not enough stock for
+func errOutOfStock(items []models.StockShortage) *APIError {
+	e := newAPIError(http.StatusConflict, CodeOutOfStock, "Some items are out of stock")
+	e.Details = struct {
+		Items []models.StockShortage `json:"items"`
+	}{items}
+	return e
+}
+
+// errBadGateway reports a failure of an upstream service
+func errBadGateway(message string, cause error) *APIError {
+	e := newAPIError(http.StatusBadGateway, CodeBadGateway, message)
+	e.cause = cause
+	return e
+}
+
+// orNotFound replaces models.ErrNotFound with a not-found error carrying message
+func orNotFound(err error, message string) error {
+	if errors.Is(err, models.ErrNotFound) {
+		return errNotFound(message)
+	}
+	return err
+}
+
+// toAPIError maps any error to a client-safe APIError. Errors that are not
+// recognised become a generic internal error.
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	e := mapError(err)
+	e.cause = err
+	return e
+}
+
+// mapError maps domain, auth, validation and database errors to public errors
+func mapError(err error) *APIError {
+	var validationErrs validation.Errors
+	var decodeErr *validation.DecodeError
+	var productNotFound *models.ProductNotFoundError
+	var outOfStock *models.OutOfStockError
+	var refundLine *models.RefundLineError
+	var coupon *models.CouponError
+	var invalidSort *models.InvalidSortError
+	var maxBytes *http.MaxBytesError
+	var pqErr *pq.Error
+
+	switch {
+	case errors.As(err, &validationErrs):
+		fields := make([]FieldError, len(validationErrs))
+		for i, fe := range validationErrs {
+			fields[i] = FieldError{Field: fe.Field, Message: fe.Message}
+		}
+		return errValidation(fields...)
+	case errors.As(err, &decodeErr):
+		e := errBadRequest(decodeErr.Message)
+		if decodeErr.Field != "" {
+			e.Fields = []FieldError{{Field: decodeErr.Field, Message: decodeErr.Message}}
+		}
+		return e
+	case errors.Is(err, models.ErrNotFound), errors.Is(err, sql.ErrNoRows):
+		return errNotFound("Resource not found")
+	case errors.As(err, &productNotFound):
+		return errNotFound(fmt.Sprintf("Product with id %d not found", productNotFound.ProductID))
+	case errors.As(err, &outOfStock):
+		return errOutOfStock(outOfStock.Items)
+	case errors.As(err, &invalidSort):
+		return errValidation(FieldError{Field: "sort", Message: "must be one of: " + strings.Join(invalidSort.Allowed, ", ")})
+	case errors.Is(err, models.ErrInvalidCursor):
+		return errValidation(FieldError{Field: "cursor", Message: "is invalid or belongs to a different sort order"})
+	case errors.Is(err, models.ErrStockNotTracked):
+		return errConflict("Product stock is not tracked, start tracking it with track")
+	case errors.Is(err, models.ErrStockTracked):
+		return errConflict("Product stock is already tracked, adjust it with delta")
+	case errors.Is(err, models.ErrStockNegative):
+		return errConflict("Product does not have that much stock")
+	case errors.Is(err, models.ErrInvalidTransition):
+		return errConflict("Order cannot move to that status")
+	case errors.Is(err, models.ErrStatusConflict):
+		return errConflict("Order status has changed, reload and try again")
+	case errors.Is(err, models.ErrOrderNotRefundable):
+		return errConflict("Order cannot be refunded in its status")
+	case errors.Is(err, models.ErrNothingToRefund):
+		return errConflict("Order has nothing left to refund")
+	case errors.Is(err, models.ErrRefundKeyReused):
+		return newAPIError(http.StatusUnprocessableEntity, CodeConflict, "Idempotency-Key was already used for a different refund")
+	case errors.As(err, &refundLine):
+		return errValidation(FieldError{Field: fmt.Sprintf("items[%d]", refundLine.Index), Message: refundLine.Message})
+	case errors.As(err, &coupon):
+		return errValidation(FieldError{Field: "coupon_code", Message: coupon.Message})
+	case errors.Is(err, models.ErrCouponRedeemed):
+		return errConflict("Coupon has been redeemed, end it instead of deleting it")
+	case errors.Is(err, models.ErrInvalidCouponValue):
+		return errValidation(FieldError{Field: "value", Message: "must be from 1 to 100 for percent coupons and positive for fixed ones"})
+	case errors.Is(err, models.ErrDuplicateCouponCode):
+		return errConflict("A coupon with this code already exists")
+	case errors.Is(err, models.ErrCartEmpty):
+		return errConflict("Cart is empty")
+	case errors.Is(err, models.ErrCartQuantityLimit):
+		return errValidation(FieldError{Field: "quantity", Message: fmt.Sprintf("would take the cart over %d of this product", models.MaxCartQuantity)})
+	case errors.Is(err, models.ErrIdempotencyKeyInUse):
+		return errConflict("A request with this Idempotency-Key is in progress, retry later")
+	case errors.Is(err, models.ErrIdempotencyKeyReused):
+		return newAPIError(http.StatusUnprocessableEntity, CodeConflict, "Idempotency-Key was already used for a different request")
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return newAPIError(http.StatusUnauthorized, CodeUnauthorized, "Authentication required")
+	case errors.Is(err, auth.ErrNotProvisioned):
+		return newAPIError(http.StatusForbidden, CodeForbidden, "No account exists for this user")
+	case errors.Is(err, models.ErrRoleConflict):
+		return errConflict("User's role has changed, reload and try again")
+	case errors.Is(err, auth.ErrInsufficientRole), errors.Is(err, policy.ErrForbidden):
+		return newAPIError(http.StatusForbidden, CodeForbidden, "You do not have permission to do that")
+	case errors.As(err, &maxBytes):
+		return newAPIError(http.StatusRequestEntityTooLarge, CodeBadRequest, "Request body is too large")
+	case errors.Is(err, context.DeadlineExceeded):
+		return newAPIError(http.StatusGatewayTimeout, CodeTimeout, "The request took too long")
+	case errors.Is(err, context.Canceled):
+		return newAPIError(http.StatusServiceUnavailable, CodeServiceUnavailable, "The request was canceled")
+	case errors.As(err, &pqErr):
+		return mapPostgresError(pqErr)
+	}
+
+	return newAPIError(http.StatusInternalServerError, CodeInternal, "Internal server error")
+}
+
+// mapPostgresError maps constraint violations to public errors without
+// exposing table, column or constraint names
+func mapPostgresError(err *pq.Error) *APIError {
+	switch err.Code.Name() {
+	case "unique_violation":
+		return errConflict("A resource with these values already exists")
+	case "foreign_key_violation":
+		return errConflict("The resource is referenced by or references another resource")
+	case "not_null_violation", "check_violation", "string_data_right_truncation", "numeric_value_out_of_range":
+		return errValidation()
+	case "invalid_text_representation":
+		return errBadRequest("Request contains an invalid value")
+	case "query_canceled":
+		return newAPIError(http.StatusGatewayTimeout, CodeTimeout, "The request took too long")
+	}
+
+	return newAPIError(http.StatusInternalServerError, CodeInternal, "Internal server error")
+}
+
+// respondError renders err as a JSON error envelope. The original error is
+// logged for server errors and whenever it carries an internal cause.
+func respondError(w http.ResponseWriter, r *http.Request, err error) {
+	public := *toAPIError(err)
+	public.RequestID = middleware.GetReqID(r.Context())
+
+	if public.Status >= http.StatusInternalServerError || public.cause != nil {
+		log.Printf("[%s] %s %s: %d %s: %v", public.RequestID, r.Method, r.URL.Path, public.Status, public.Code, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(public.Status)
+	json.NewEncoder(w).Encode(errorEnvelope{Error: &public})
+}
+
+// RespondError renders err as a JSON error envelope, for use by middleware
+// outside this package
+func RespondError(w http.ResponseWriter, r *http.Request, err error) {
+	respondError(w, r, err)
+}
+
+// NotFound renders the error envelope for unknown routes
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	respondError(w, r, errNotFound("Route not found"))
+}
+
+// MethodNotAllowed renders the error envelope for unsupported methods
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	respondError(w, r, newAPIError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed"))
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/your-username/your-repo/internal/auth"
+	"github.com/your-username/your-repo/internal/models"
+)
+
+// maxIdempotencyKeyLength is the longest Idempotency-Key header accepted
+const maxIdempotencyKeyLength = 255
+
+// idempotencyStoreTimeout bounds storing or releasing a key once the request
+// is over, when the request's own context may already be done
+const idempotencyStoreTimeout = 5 * time.Second
+
+// idempotentMethods are the methods whose Idempotency-Key header is honored
+var idempotentMethods = map[string]bool{
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodDelete: true,
+}
+
+// Idempotency makes POST, PUT and DELETE requests sent with an
+// Idempotency-Key header safe to retry. The first request with a key is
+// handled and its response stored for ttl; repeats of it by the same user
+// get the stored response, with an Idempotent-Replayed header, rather than
+// being handled again. A repeat while the first request is in flight is
+// rejected with 409, and a different request with the same key with 422.
+//
+// Server errors are not stored, so requests that failed with one can be
+// retried with the same key. It must run after authentication; requests
+// without a signed-in caller are handled as if they had no key, as there is
+// no one to scope it to.
+func Idempotency(store models.IdempotencyStore, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			scope := auth.ClerkIDFromContext(r.Context())
+			if key == "" || scope == "" || !idempotentMethods[r.Method] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				respondError(w, r, errBadRequest("Idempotency-Key header is too long"))
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+			if err != nil {
+				respondError(w, r, errBadRequest("Failed to read request body"))
+				return
+			}
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			if len(body) > maxBodyBytes {
+				// Rejected by the handler as too large, so nothing to store
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			stored, err := store.BeginIdempotentRequest(r.Context(), models.IdempotentRequest{
+				Scope:       scope,
+				Key:         key,
+				Fingerprint: fingerprint(r, body),
+				ExpiresAt:   time.Now().Add(ttl),
+			})
+			if err != nil {
+				respondError(w, r, err)
+				return
+			}
+			if stored != nil {
+				replay(w, stored)
+				return
+			}
+
+			// Release the key unless the response is stored, including when
+			// the handler panics
+			completed := false
+			defer func() {
+				if completed {
+					return
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), idempotencyStoreTimeout)
+				defer cancel()
+				if err := store.ReleaseIdempotentRequest(ctx, scope, key); err != nil {
+					log.Printf("idempotency: failed to release key %q: %v", key, err)
+				}
+			}()
+
+			rw := &recordingWriter{ResponseWriter: w}
+			next.ServeHTTP(rw, r)
+
+			status := rw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if status >= http.StatusInternalServerError {
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), idempotencyStoreTimeout)
+			defer cancel()
+			err = store.CompleteIdempotentRequest(ctx, scope, key, models.IdempotentResponse{
+				Status:      status,
+				ContentType: rw.contentType,
+				Body:        rw.body.Bytes(),
+			})
+			if err != nil {
+				log.Printf("idempotency: failed to store response for key %q: %v", key, err)
+				return
+			}
+			completed = true
+		})
+	}
+}
+
+// recordingWriter passes a response through while recording its status,
+// Content-Type and body. The Content-Type is the one sent with the status;
+// any set later never reached the client, so it is not replayed either.
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	contentType string
+	body        bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.contentType = w.Header().Get("Content-Type")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// readCloser reads from one reader and closes another
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// fingerprint identifies a request by its method, path, query and body
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replay writes a stored response
+func replay(w http.ResponseWriter, resp *models.IdempotentResponse) {
+	if resp.ContentType != "" {
+		w.Header().Set("Content-Type", resp.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/your-username/your-repo/internal/auth"
+	"github.com/your-username/your-repo/internal/models"
+)
+
+// idempotentRequest returns a POST request with an Idempotency-Key, sent by
+// user if it is not nil
+func idempotentRequest(user *models.User, key, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/cart/order", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Idempotency-Key", key)
+	if user != nil {
+		r = r.WithContext(auth.ContextWithUser(r.Context(), user))
+	}
+	return r
+}
+
+// countingHandler responds with the next of its statuses, counting the
+// requests it handles
+type countingHandler struct {
+	mu       sync.Mutex
+	calls    int
+	statuses []int
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	h.calls++
+	status := h.statuses[(h.calls-1)%len(h.statuses)]
+	calls := h.calls
+	h.mu.Unlock()
+
+	respondJSON(w, status, map[string]int{"call": calls})
+}
+
+// serve sends r through the Idempotency middleware in front of next
+func serve(store models.IdempotencyStore, next http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	Idempotency(store, time.Hour)(next).ServeHTTP(w, r)
+	return w
+}
+
+var testIdempotencyUser = &models.User{ID: 1, ClerkID: "user_1", Role: models.RoleCustomer}
+
+func TestIdempotencyReplaysResponse(t *testing.T) {
+	store := models.NewMemoryStore()
+	next := &countingHandler{statuses: []int{http.StatusCreated}}
+
+	first := serve(store, next, idempotentRequest(testIdempotencyUser, "key-1", `{"a":1}`))
+	second := serve(store, next, idempotentRequest(testIdempotencyUser, "key-1", `{"a":1}`))
+
+	if next.calls != 1 {
+		t.Errorf("handler called %d times, want 1", next.calls)
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("replay = %d %q, want %d %q", second.Code, second.Body, first.Code, first.Body)
+	}
+	if ct := second.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("replayed Content-Type = %q, want application/json", ct)
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replay has no Idempotent-Replayed header")
+	}
+	if first.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("first response has an Idempotent-Replayed header")
+	}
+}
+
+func TestIdempotencyScopesKeysByUser(t *testing.T) {
+	store := models.NewMemoryStore()
+	next := &countingHandler{statuses: []int{http.StatusCreated}}
+	other := &models.User{ID: 2, ClerkID: "user_2", Role: models.RoleCustomer}
+
+	serve(store, next, idempotentRequest(testIdempotencyUser, "key-1", `{}`))
+	w := serve(store, next, idempotentRequest(other, "key-1", `{}`))
+
+	if next.calls != 2 || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("handler called %d times, want another user's request handled", next.calls)
+	}
+}
+
+func TestIdempotencyIgnoresAnonymousRequests(t *testing.T) {
+	store := models.NewMemoryStore()
+	next := &countingHandler{statuses: []int{http.StatusUnauthorized}}
+
+	serve(store, next, idempotentRequest(nil, "key-1", `{}`))
+	w := serve(store, next, idempotentRequest(nil, "key-1", `{}`))
+
+	if next.calls != 2 || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("handler called %d times, want anonymous requests not replayed", next.calls)
+	}
+}
+
+func TestIdempotencyRejectsRequestInFlight(t *testing.T) {
+	store := models.NewMemoryStore()
+	started, release := make(chan struct{}), make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		respondJSON(w, http.StatusCreated, nil)
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- serve(store, next, idempotentRequest(testIdempotencyUser, "key-1", `{}`))
+	}()
+	<-started
+
+	w := serve(store, next, idempotentRequest(testIdempotencyUser, "key-1", `{}`))
+	close(release)
+	if w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+	}
+	if first := <-done; first.Code != http.StatusCreated {
+		t.Errorf("first request status = %d, want %d", first.Code, http.StatusCreated)
+	}
+}
+
+func TestIdempotencyRejectsKeyReusedForDifferentRequest(t *testing.T) {
+	store := models.NewMemoryStore()
+	next := &countingHandler{statuses: []int{http.StatusCreated}}
+
+	serve(store, next, idempotentRequest(testIdempotencyUser, "key-1", `{"coupon_code":"A"}`))
+	w := serve(store, next, idempotentRequest(testIdempotencyUser, "key-1", `{"coupon_code":"B"}`))
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusUnprocessableEntity, w.Body)
+	}
+	if next.calls != 1 {
+		t.Errorf("handler called %d times, want 1", next.calls)
+	}
+}
+
+func TestIdempotencyDoesNotStoreServerErrors(t *testing.T) {
+	store := models.NewMemoryStore()
+	next := &countingHandler{statuses: []int{http.StatusInternalServerError, http.StatusCreated}}
+
+	if w := serve(store, next, idempotentRequest(testIdempotencyUser, "key-1", `{}`)); w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	w := serve(store, next, idempotentRequest(testIdempotencyUser, "key-1", `{}`))
+
+	if w.Code != http.StatusCreated || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("retry = %d, replayed %q; want the request handled again", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+	if next.calls != 2 {
+		t.Errorf("handler called %d times, want 2", next.calls)
+	}
+}
+
+func TestIdempotencyReplaysContentTypeSentWithStatus(t *testing.T) {
+	store := models.NewMemoryStore()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		// Too late to be sent
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("created"))
+	})
+
+	first := serve(store, next, idempotentRequest(testIdempotencyUser, "key-1", `{}`))
+	second := serve(store, next, idempotentRequest(testIdempotencyUser, "key-1", `{}`))
+
+	// The recorder's Result has the headers as they were sent
+	if got, want := second.Result().Header.Get("Content-Type"), first.Result().Header.Get("Content-Type"); got != want {
+		t.Errorf("replayed Content-Type = %q, want %q as first sent", got, want)
+	}
+}
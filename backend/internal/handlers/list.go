@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/your-username/your-repo/internal/models"
+)
+
+// listQuery reads the paging and filter parameters of a list request,
+// collecting a field error for each one that is malformed
+type listQuery struct {
+	values url.Values
+	errs   []FieldError
+}
+
+// newListQuery creates a listQuery for r
+func newListQuery(r *http.Request) *listQuery {
+	return &listQuery{values: r.URL.Query()}
+}
+
+// page returns the limit, cursor and sort parameters
+func (q *listQuery) page() models.Page {
+	page := models.Page{
+		Cursor: q.values.Get("cursor"),
+		Sort:   q.values.Get("sort"),
+	}
+	if limit := q.int("limit"); limit != nil {
+		if *limit < 1 || *limit > models.MaxPageLimit {
+			q.fail("limit", fmt.Sprintf("must be between 1 and %d", models.MaxPageLimit))
+		} else {
+			page.Limit = *limit
+		}
+	}
+	return page
+}
+
+// int returns an integer parameter, or nil if it is not set
+func (q *listQuery) int(name string) *int {
+	s := q.values.Get(name)
+	if s == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		q.fail(name, "must be an integer")
+		return nil
+	}
+	return &n
+}
+
+// time returns a timestamp parameter, given as RFC 3339 or a date, or the
+// zero time if it is not set
+func (q *listQuery) time(name string) time.Time {
+	s := q.values.Get(name)
+	if s == "" {
+		return time.Time{}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t
+	}
+	q.fail(name, "must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+	return time.Time{}
+}
+
+// fail records a malformed parameter
+func (q *listQuery) fail(name, message string) {
+	q.errs = append(q.errs, FieldError{Field: name, Message: message})
+}
+
+// err returns a validation error for the malformed parameters, if any
+func (q *listQuery) err() error {
+	if len(q.errs) > 0 {
+		return errValidation(q.errs...)
+	}
+	return nil
+}
+
+// setPageLinks sets a Link header pointing at the first page and, if there
+// is one, the next page of the list
+func setPageLinks(w http.ResponseWriter, r *http.Request, next string) {
+	link := func(cursor, rel string) string {
+		u := *r.URL
+		values := u.Query()
+		if cursor == "" {
+			values.Del("cursor")
+		} else {
+			values.Set("cursor", cursor)
+		}
+		u.RawQuery = values.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+	}
+
+	w.Header().Add("Link", link("", "first"))
+	if next != "" {
+		w.Header().Add("Link", link(next, "next"))
+	}
+}
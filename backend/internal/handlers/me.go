@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/your-username/your-repo/internal/auth"
+	"github.com/your-username/your-repo/internal/models"
+)
+
+// MeHandler handles HTTP requests about the authenticated user
+type MeHandler struct {
+	users  models.UserStore
+	orders models.OrderStore
+}
+
+// NewMeHandler creates a new MeHandler
+func NewMeHandler(users models.UserStore, orders models.OrderStore) *MeHandler {
+	return &MeHandler{users: users, orders: orders}
+}
+
+// updateMeRequest is the body of a request to update the authenticated user.
+// Fields that are left out are not changed. The email address is owned by
+// Clerk and synced from it, so it cannot be changed here.
+type updateMeRequest struct {
+	Name *string `json:"name" validate:"max=255"`
+}
+
+// Get returns the authenticated user
+func (h *MeHandler) Get(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, auth.UserFromContext(r.Context()))
+}
+
+// Update updates the authenticated user's name
+func (h *MeHandler) Update(w http.ResponseWriter, r *http.Request) {
+	var req updateMeRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	user := *auth.UserFromContext(r.Context())
+	if req.Name != nil {
+		user.Name = *req.Name
+	}
+
+	if err := h.users.UpdateUser(r.Context(), &user); err != nil {
+		respondError(w, r, orNotFound(err, "User not found"))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, user)
+}
+
+// ListOrders returns a page of the authenticated user's orders, optionally
+// filtered by status and creation date range
+func (h *MeHandler) ListOrders(w http.ResponseWriter, r *http.Request) {
+	q := newListQuery(r)
+	page := q.page()
+	filter := q.orderFilter()
+	filter.UserID = auth.UserFromContext(r.Context()).ID
+	if err := q.err(); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	orders, next, err := h.orders.GetOrders(r.Context(), filter, page)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+	setPageLinks(w, r, next)
+	respondJSON(w, http.StatusOK, orders)
+}
+
+// GetOrder returns one of the authenticated user's orders
+func (h *MeHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		respondError(w, r, errBadRequest("Invalid order ID"))
+		return
+	}
+
+	order, err := h.orders.GetOrderByID(r.Context(), id)
+	if err != nil {
+		respondError(w, r, orNotFound(err, "Order not found"))
+		return
+	}
+	if order.UserID != auth.UserFromContext(r.Context()).ID {
+		respondError(w, r, errNotFound("Order not found"))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, order)
+}
@@ -1,36 +1,99 @@
 package handlers
 
 import (
-	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/your-username/your-repo/internal/auth"
 	"github.com/your-username/your-repo/internal/config"
-	"github.com/your-username/your-repo/internal/database"
 	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/policy"
 )
 
 // OrderHandler handles HTTP requests for orders
 type OrderHandler struct {
-	db     *database.DB
+	orders models.OrderStore
 	config *config.Config
 }
 
 // NewOrderHandler creates a new OrderHandler
-func NewOrderHandler(db *database.DB, cfg *config.Config) *OrderHandler {
-	return &OrderHandler{db: db, config: cfg}
+func NewOrderHandler(orders models.OrderStore, cfg *config.Config) *OrderHandler {
+	return &OrderHandler{orders: orders, config: cfg}
 }
 
-// List returns all orders
+// orderItemRequest is a product and quantity requested for an order
+type orderItemRequest struct {
+	ProductID int `json:"product_id" validate:"gt=0"`
+	Quantity  int `json:"quantity" validate:"gt=0,max=1000"`
+}
+
+// createOrderRequest is the body of an order creation request. Prices and
+// totals are never taken from the client.
+// The rules mirror createOrderSchema in the frontend.
+type createOrderRequest struct {
+	Items      []orderItemRequest `json:"items" validate:"required,max=100"`
+	CouponCode string             `json:"coupon_code" validate:"max=64"`
+}
+
+// orderItems converts the requested items to unpriced order items
+func (req createOrderRequest) orderItems() []models.OrderItem {
+	items := make([]models.OrderItem, len(req.Items))
+	for i, item := range req.Items {
+		items[i] = models.OrderItem{ProductID: item.ProductID, Quantity: item.Quantity}
+	}
+	return items
+}
+
+// orderResource returns the policy resource for an order
+func orderResource(o *models.Order) policy.Resource {
+	return policy.Resource{Kind: policy.KindOrder, OwnerID: o.UserID}
+}
+
+// orderFilter reads the status and creation date range order filters
+func (q *listQuery) orderFilter() models.OrderFilter {
+	filter := models.OrderFilter{
+		Status:      models.OrderStatus(q.values.Get("status")),
+		CreatedFrom: q.time("created_from"),
+		CreatedTo:   q.time("created_to"),
+	}
+	if filter.Status != "" && !filter.Status.Valid() {
+		q.fail("status", "is not a valid order status")
+	}
+	return filter
+}
+
+// List returns a page of orders, optionally filtered by status, user and
+// creation date range. Callers who may not list every user's orders only see
+// their own.
 func (h *OrderHandler) List(w http.ResponseWriter, r *http.Request) {
-	orders, err := models.GetOrders(h.db)
+	q := newListQuery(r)
+	page := q.page()
+	filter := q.orderFilter()
+	if userID := q.int("user_id"); userID != nil {
+		filter.UserID = *userID
+	} else {
+		filter.UserID = policy.Scope(auth.SubjectFromContext(r.Context()), policy.KindOrder)
+	}
+	if err := q.err(); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	if err := authorize(r, policy.ActionList, policy.Resource{Kind: policy.KindOrder, OwnerID: filter.UserID}); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	orders, next, err := h.orders.GetOrders(r.Context(), filter, page)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		respondError(w, r, err)
 		return
 	}
 
-	respondJSON(w, orders)
+	setPageLinks(w, r, next)
+	respondJSON(w, http.StatusOK, orders)
 }
 
 // Get returns an order by ID
@@ -38,63 +101,100 @@ func (h *OrderHandler) Get(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		respondError(w, r, errBadRequest("Invalid order ID"))
 		return
 	}
 
-	order, err := models.GetOrderByID(h.db, id)
+	order, err := h.orders.GetOrderByID(r.Context(), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		respondError(w, r, orNotFound(err, "Order not found"))
 		return
 	}
-
-	if order == nil {
-		http.Error(w, "Order not found", http.StatusNotFound)
+	if !canRead(r, orderResource(order)) {
+		respondError(w, r, errNotFound("Order not found"))
 		return
 	}
 
-	respondJSON(w, order)
+	respondJSON(w, http.StatusOK, order)
 }
 
-// Create creates a new order
+// Create creates a new order for the authenticated user
 func (h *OrderHandler) Create(w http.ResponseWriter, r *http.Request) {
-	var order models.Order
-	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var req createOrderRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, r, err)
 		return
 	}
 
-	if err := models.CreateOrder(h.db, &order); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	reservedUntil := time.Now().Add(h.config.ReservationTTL)
+	order := models.Order{
+		UserID:        auth.UserFromContext(r.Context()).ID,
+		Status:        models.OrderStatusPending,
+		ReservedUntil: &reservedUntil,
+		Items:         req.orderItems(),
+		CouponCode:    req.CouponCode,
+	}
+	if err := authorize(r, policy.ActionCreate, orderResource(&order)); err != nil {
+		respondError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	respondJSON(w, order)
+	if err := h.orders.CreateOrder(r.Context(), &order); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, order)
+}
+
+// updateOrderRequest is the body of an order update request. Refund statuses
+// are only reached by issuing refunds.
+type updateOrderRequest struct {
+	Status models.OrderStatus `json:"status" validate:"required,oneof=pending payment_failed paid fulfilled shipped delivered canceled"`
 }
 
-// Update updates an order
+// Update moves an order to a new status
 func (h *OrderHandler) Update(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		respondError(w, r, errBadRequest("Invalid order ID"))
+		return
+	}
+
+	var req updateOrderRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, r, err)
 		return
 	}
 
-	var order models.Order
-	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	order, err := h.orders.GetOrderByID(r.Context(), id)
+	if err != nil {
+		respondError(w, r, orNotFound(err, "Order not found"))
+		return
+	}
+	if !canRead(r, orderResource(order)) {
+		respondError(w, r, errNotFound("Order not found"))
+		return
+	}
+	if err := authorize(r, policy.ActionUpdate, orderResource(order)); err != nil {
+		respondError(w, r, err)
 		return
 	}
 
-	order.ID = id
-	if err := models.UpdateOrder(h.db, &order); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	changedBy := "user:" + strconv.Itoa(auth.UserFromContext(r.Context()).ID)
+	if err := h.orders.TransitionOrder(r.Context(), id, order.Status, req.Status, changedBy); err != nil {
+		respondError(w, r, orNotFound(err, "Order not found"))
 		return
 	}
 
-	respondJSON(w, order)
+	order, err = h.orders.GetOrderByID(r.Context(), id)
+	if err != nil {
+		respondError(w, r, orNotFound(err, "Order not found"))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, order)
 }
 
 // Delete deletes an order
@@ -102,12 +202,26 @@ func (h *OrderHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		respondError(w, r, errBadRequest("Invalid order ID"))
+		return
+	}
+
+	order, err := h.orders.GetOrderByID(r.Context(), id)
+	if err != nil {
+		respondError(w, r, orNotFound(err, "Order not found"))
+		return
+	}
+	if !canRead(r, orderResource(order)) {
+		respondError(w, r, errNotFound("Order not found"))
+		return
+	}
+	if err := authorize(r, policy.ActionDelete, orderResource(order)); err != nil {
+		respondError(w, r, err)
 		return
 	}
 
-	if err := models.DeleteOrder(h.db, id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.orders.DeleteOrder(r.Context(), id); err != nil {
+		respondError(w, r, orNotFound(err, "Order not found"))
 		return
 	}
 
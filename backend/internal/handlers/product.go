@@ -1,34 +1,74 @@
 package handlers
 
 import (
-	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/your-username/your-repo/internal/database"
+	"github.com/your-username/your-repo/internal/catalog"
 	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/policy"
 )
 
 // ProductHandler handles HTTP requests for products
 type ProductHandler struct {
-	db *database.DB
+	products models.ProductStore
+	// catalog syncs products to Stripe; it is nil if Stripe is not configured
+	catalog *catalog.Syncer
 }
 
 // NewProductHandler creates a new ProductHandler
-func NewProductHandler(db *database.DB) *ProductHandler {
-	return &ProductHandler{db: db}
+func NewProductHandler(products models.ProductStore, syncer *catalog.Syncer) *ProductHandler {
+	return &ProductHandler{products: products, catalog: syncer}
 }
 
-// List returns all products
+// productRequest is the body of a product create or update request,
+// mirroring createProductSchema in the frontend. Stripe IDs are managed by
+// the catalog sync rather than set by clients. Stock can only be set on
+// create; afterwards it changes through the stock endpoint, so that edits
+// cannot overwrite the reservations of concurrent orders.
+type productRequest struct {
+	Name        string `json:"name" validate:"required,max=255"`
+	Description string `json:"description"`
+	Price       int    `json:"price" validate:"gt=0"`
+	Stock       *int   `json:"stock" validate:"min=0"`
+}
+
+// product converts the request to a product
+func (req productRequest) product() models.Product {
+	return models.Product{
+		Name:        req.Name,
+		Description: req.Description,
+		Price:       req.Price,
+		Stock:       req.Stock,
+	}
+}
+
+// productResource is the policy resource for products, which have no owner
+var productResource = policy.Resource{Kind: policy.KindProduct}
+
+// List returns a page of products, optionally filtered by price range
 func (h *ProductHandler) List(w http.ResponseWriter, r *http.Request) {
-	products, err := models.GetProducts(h.db)
+	q := newListQuery(r)
+	page := q.page()
+	filter := models.ProductFilter{
+		MinPrice: q.int("min_price"),
+		MaxPrice: q.int("max_price"),
+	}
+	if err := q.err(); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	products, next, err := h.products.GetProducts(r.Context(), filter, page)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		respondError(w, r, err)
 		return
 	}
 
-	respondJSON(w, products)
+	setPageLinks(w, r, next)
+	respondJSON(w, http.StatusOK, products)
 }
 
 // Get returns a product by ID
@@ -36,83 +76,191 @@ func (h *ProductHandler) Get(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		respondError(w, r, errBadRequest("Invalid product ID"))
 		return
 	}
 
-	product, err := models.GetProductByID(h.db, id)
+	product, err := h.products.GetProductByID(r.Context(), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		respondError(w, r, orNotFound(err, "Product not found"))
 		return
 	}
 
-	if product == nil {
-		http.Error  err.Error(), http.StatusInternalServerError)
+	respondJSON(w, http.StatusOK, product)
+}
+
+// Create creates a new product and its Stripe Product and Price
+func (h *ProductHandler) Create(w http.ResponseWriter, r *http.Request) {
+	if err := authorize(r, policy.ActionCreate, productResource); err != nil {
+		respondError(w, r, err)
 		return
 	}
 
-	if product == nil {
-		http.Error(w, "Product not found", http.StatusNotFound)
+	var req productRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, r, err)
 		return
 	}
 
-	respondJSON(w, product)
-}
-
-// Create creates a new product
-func (h *ProductHandler) Create(w http.ResponseWriter, r *http.Request) {
-	var product models.Product
-	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	product := req.product()
+	if err := h.products.CreateProduct(r.Context(), &product); err != nil {
+		respondError(w, r, err)
 		return
 	}
 
-	if err := models.CreateProduct(h.db, &product); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if h.catalog != nil {
+		if err := h.catalog.Sync(r.Context(), &product); err != nil {
+			// Remove the product so that retrying does not create a duplicate
+			syncErr := err
+			if err := h.products.DeleteProduct(r.Context(), product.ID); err != nil {
+				log.Printf("products: failed to delete unsynced product %d: %v", product.ID, err)
+			}
+			respondError(w, r, errBadGateway("Failed to create Stripe product", syncErr))
+			return
+		}
+		if err := h.products.SetProductStripeIDs(r.Context(), product.ID, product.StripeProductID, product.StripePriceID); err != nil {
+			respondError(w, r, err)
+			return
+		}
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	respondJSON(w, product)
+	respondJSON(w, http.StatusCreated, product)
 }
 
-// Update updates a product
+// Update updates a product, syncing the change to Stripe before saving it
 func (h *ProductHandler) Update(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		respondError(w, r, errBadRequest("Invalid product ID"))
+		return
+	}
+
+	if err := authorize(r, policy.ActionUpdate, productResource); err != nil {
+		respondError(w, r, err)
 		return
 	}
 
-	var product models.Product
-	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var req productRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, r, err)
+		return
+	}
+	if req.Stock != nil {
+		respondError(w, r, errValidation(FieldError{Field: "stock", Message: "cannot be updated, adjust it through the stock endpoint instead"}))
 		return
 	}
 
+	existing, err := h.products.GetProductByID(r.Context(), id)
+	if err != nil {
+		respondError(w, r, orNotFound(err, "Product not found"))
+		return
+	}
+
+	product := req.product()
 	product.ID = id
-	if err := models.UpdateProduct(h.db, &product); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	product.Stock = existing.Stock
+	product.StripeProductID = existing.StripeProductID
+	product.StripePriceID = existing.StripePriceID
+	if h.catalog != nil {
+		if err := h.catalog.Sync(r.Context(), &product); err != nil {
+			respondError(w, r, errBadGateway("Failed to update Stripe product", err))
+			return
+		}
+	}
+
+	if err := h.products.UpdateProduct(r.Context(), &product); err != nil {
+		respondError(w, r, orNotFound(err, "Product not found"))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, product)
+}
+
+// adjustStockRequest is the body of a stock adjustment. Delta changes the
+// stock of a product whose stock is tracked; Track starts tracking the stock
+// of one whose stock is not.
+type adjustStockRequest struct {
+	Delta *int `json:"delta"`
+	Track *int `json:"track" validate:"min=0"`
+}
+
+// productStockResponse is the stock of a product after an adjustment
+type productStockResponse struct {
+	ProductID int `json:"product_id"`
+	Stock     int `json:"stock"`
+}
+
+// AdjustStock changes the stock of a product relative to its current stock,
+// or starts tracking it
+func (h *ProductHandler) AdjustStock(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, r, errBadRequest("Invalid product ID"))
+		return
+	}
+
+	if err := authorize(r, policy.ActionUpdate, productResource); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	var req adjustStockRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, r, err)
+		return
+	}
+	if (req.Delta == nil) == (req.Track == nil) {
+		respondError(w, r, errValidation(FieldError{Field: "delta", Message: "exactly one of delta and track is required"}))
+		return
+	}
+
+	var stock int
+	if req.Delta != nil {
+		stock, err = h.products.AdjustProductStock(r.Context(), id, *req.Delta)
+	} else {
+		stock, err = *req.Track, h.products.TrackProductStock(r.Context(), id, *req.Track)
+	}
+	if err != nil {
+		respondError(w, r, orNotFound(err, "Product not found"))
 		return
 	}
 
-	respondJSON(w, product)
+	respondJSON(w, http.StatusOK, productStockResponse{ProductID: id, Stock: stock})
 }
 
-// Delete deletes a product
+// Delete deletes a product and archives its Stripe Product and Price
 func (h *ProductHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		respondError(w, r, errBadRequest("Invalid product ID"))
 		return
 	}
 
-	if err := models.DeleteProduct(h.db, id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := authorize(r, policy.ActionDelete, productResource); err != nil {
+		respondError(w, r, err)
 		return
 	}
 
+	product, err := h.products.GetProductByID(r.Context(), id)
+	if err != nil {
+		respondError(w, r, orNotFound(err, "Product not found"))
+		return
+	}
+
+	if err := h.products.DeleteProduct(r.Context(), id); err != nil {
+		respondError(w, r, orNotFound(err, "Product not found"))
+		return
+	}
+
+	// The product is gone either way; Reconcile reports a Stripe Product
+	// left active
+	if h.catalog != nil {
+		if err := h.catalog.Archive(r.Context(), product); err != nil {
+			log.Printf("products: failed to archive Stripe product of product %d: %v", id, err)
+		}
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/your-username/your-repo/internal/auth"
+	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/payments"
+	"github.com/your-username/your-repo/internal/policy"
+)
+
+// RefundHandler handles HTTP requests for order refunds
+type RefundHandler struct {
+	orders   models.OrderStore
+	refunds  models.RefundStore
+	payments payments.Client
+}
+
+// NewRefundHandler creates a new RefundHandler
+func NewRefundHandler(orders models.OrderStore, refunds models.RefundStore, pc payments.Client) *RefundHandler {
+	return &RefundHandler{orders: orders, refunds: refunds, payments: pc}
+}
+
+// refundItemRequest is a quantity of an order item to refund
+type refundItemRequest struct {
+	OrderItemID int `json:"order_item_id" validate:"gt=0"`
+	Quantity    int `json:"quantity" validate:"gt=0"`
+}
+
+// createRefundRequest is the body of a refund request. Without items,
+// everything not yet refunded is refunded.
+type createRefundRequest struct {
+	Items  []refundItemRequest `json:"items" validate:"max=100"`
+	Reason string              `json:"reason" validate:"oneof=duplicate fraudulent requested_by_customer"`
+}
+
+// refundResource returns the policy resource for the refunds of an order
+func refundResource(o *models.Order) policy.Resource {
+	return policy.Resource{Kind: policy.KindRefund, OwnerID: o.UserID}
+}
+
+// order returns the order named in the URL, if the caller may read it
+func (h *RefundHandler) order(r *http.Request) (*models.Order, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return nil, errBadRequest("Invalid order ID")
+	}
+
+	order, err := h.orders.GetOrderByID(r.Context(), id)
+	if err != nil {
+		return nil, orNotFound(err, "Order not found")
+	}
+	if !canRead(r, orderResource(order)) {
+		return nil, errNotFound("Order not found")
+	}
+	return order, nil
+}
+
+// List returns the refunds of an order, oldest first
+func (h *RefundHandler) List(w http.ResponseWriter, r *http.Request) {
+	order, err := h.order(r)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+	if err := authorize(r, policy.ActionList, refundResource(order)); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	refunds, err := h.refunds.GetRefunds(r.Context(), order.ID)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, refunds)
+}
+
+// Create refunds some or all of an order's items through Stripe and restocks
+// them. The Idempotency-Key header is required: retrying with the same key
+// returns the refund it created, finishing it first if an earlier attempt
+// did not hear back from Stripe.
+func (h *RefundHandler) Create(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Idempotency-Key")
+	switch {
+	case key == "":
+		respondError(w, r, errBadRequest("Idempotency-Key header is required"))
+		return
+	case len(key) > maxIdempotencyKeyLength:
+		respondError(w, r, errBadRequest("Idempotency-Key header is too long"))
+		return
+	}
+
+	order, err := h.order(r)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+	if err := authorize(r, policy.ActionCreate, refundResource(order)); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	var req createRefundRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	if order.StripeSessionID == "" {
+		respondError(w, r, errConflict("Order was not paid through Stripe"))
+		return
+	}
+
+	lines := make([]models.RefundLine, len(req.Items))
+	for i, item := range req.Items {
+		lines[i] = models.RefundLine{OrderItemID: item.OrderItemID, Quantity: item.Quantity}
+	}
+	refund, created, err := h.refunds.BeginRefund(r.Context(), models.RefundRequest{
+		OrderID:        order.ID,
+		Lines:          lines,
+		Reason:         req.Reason,
+		IdempotencyKey: key,
+		CreatedBy:      "user:" + strconv.Itoa(auth.UserFromContext(r.Context()).ID),
+	})
+	if err != nil {
+		respondError(w, r, orNotFound(err, "Order not found"))
+		return
+	}
+
+	if refund.Status == models.RefundStatusPending {
+		refund, err = h.issue(r, order, refund)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+	}
+	if refund.Status == models.RefundStatusFailed {
+		respondError(w, r, errBadGateway("Stripe rejected the refund", nil))
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	respondJSON(w, status, refund)
+}
+
+// issue sends a pending refund to Stripe and records the outcome. A refund
+// whose outcome is unknown stays pending, so that it can be retried.
+func (h *RefundHandler) issue(r *http.Request, order *models.Order, refund *models.Refund) (*models.Refund, error) {
+	issued, err := h.payments.RefundPayment(r.Context(), &payments.RefundParams{
+		OrderID:           order.ID,
+		RefundID:          refund.ID,
+		CheckoutSessionID: order.StripeSessionID,
+		Amount:            int64(refund.Amount),
+		Reason:            refund.Reason,
+	})
+	if errors.Is(err, payments.ErrRefundRejected) {
+		if err := h.refunds.FailRefund(r.Context(), refund.ID); err != nil {
+			log.Printf("refunds: failed to mark refund %d failed: %v", refund.ID, err)
+		}
+		return nil, errBadGateway("Stripe rejected the refund", err)
+	}
+	if err != nil {
+		return nil, errBadGateway("Failed to issue refund, retry with the same Idempotency-Key", err)
+	}
+
+	return h.refunds.CompleteRefund(r.Context(), refund.ID, issued.ID)
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/your-username/your-repo/internal/auth"
+	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/policy"
+)
+
+// RoleHandler handles HTTP requests for user roles
+type RoleHandler struct {
+	users models.UserStore
+}
+
+// NewRoleHandler creates a new RoleHandler
+func NewRoleHandler(users models.UserStore) *RoleHandler {
+	return &RoleHandler{users: users}
+}
+
+// grantRoleRequest is the body of a role grant request
+type grantRoleRequest struct {
+	Role models.Role `json:"role" validate:"required,oneof=customer staff admin"`
+}
+
+// rolesResponse is a user's current role and the audit trail of changes to it
+type rolesResponse struct {
+	Role    models.Role         `json:"role"`
+	Changes []models.RoleChange `json:"changes"`
+}
+
+// roleResource returns the policy resource for the role of the given user
+func roleResource(userID int) policy.Resource {
+	return policy.Resource{Kind: policy.KindRole, OwnerID: userID}
+}
+
+// List returns a user's role and its change history
+func (h *RoleHandler) List(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		respondError(w, r, errBadRequest("Invalid user ID"))
+		return
+	}
+
+	if err := authorize(r, policy.ActionRead, roleResource(id)); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	user, err := h.users.GetUserByID(r.Context(), id)
+	if err != nil {
+		respondError(w, r, orNotFound(err, "User not found"))
+		return
+	}
+
+	changes, err := h.users.GetRoleChanges(r.Context(), id)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+	if changes == nil {
+		changes = []models.RoleChange{}
+	}
+
+	respondJSON(w, http.StatusOK, rolesResponse{Role: user.Role, Changes: changes})
+}
+
+// Grant gives a user a role, replacing their current one
+func (h *RoleHandler) Grant(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		respondError(w, r, errBadRequest("Invalid user ID"))
+		return
+	}
+
+	if err := authorize(r, policy.ActionCreate, roleResource(id)); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	var req grantRoleRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	h.setRole(w, r, id, func(current models.Role) (models.Role, error) {
+		return req.Role, nil
+	})
+}
+
+// Revoke takes a role away from a user, making them a customer
+func (h *RoleHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		respondError(w, r, errBadRequest("Invalid user ID"))
+		return
+	}
+
+	role := models.Role(chi.URLParam(r, "role"))
+	if !role.Valid() {
+		respondError(w, r, errBadRequest("Invalid role"))
+		return
+	}
+	if role == models.RoleCustomer {
+		respondError(w, r, errBadRequest("The customer role cannot be revoked"))
+		return
+	}
+
+	if err := authorize(r, policy.ActionDelete, roleResource(id)); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	h.setRole(w, r, id, func(current models.Role) (models.Role, error) {
+		if current != role {
+			return "", errConflict(fmt.Sprintf("User does not have the %s role", role))
+		}
+		return models.RoleCustomer, nil
+	})
+}
+
+// setRole moves a user to the role chosen by next from their current one,
+// recording the change, and responds with the user
+func (h *RoleHandler) setRole(w http.ResponseWriter, r *http.Request, id int, next func(current models.Role) (models.Role, error)) {
+	caller := auth.UserFromContext(r.Context())
+	if caller.ID == id {
+		respondError(w, r, errConflict("You cannot change your own role"))
+		return
+	}
+
+	user, err := h.users.GetUserByID(r.Context(), id)
+	if err != nil {
+		respondError(w, r, orNotFound(err, "User not found"))
+		return
+	}
+
+	role, err := next(user.Role)
+	if err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	if role != user.Role {
+		changedBy := "user:" + strconv.Itoa(caller.ID)
+		if err := h.users.SetUserRole(r.Context(), id, user.Role, role, changedBy); err != nil {
+			respondError(w, r, orNotFound(err, "User not found"))
+			return
+		}
+		user.Role = role
+	}
+
+	respondJSON(w, http.StatusOK, user)
+}
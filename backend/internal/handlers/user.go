@@ -1,34 +1,65 @@
 package handlers
 
 import (
-	"encoding/json"
 	"net/http"
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/your-username/your-repo/internal/database"
 	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/policy"
 )
 
 // UserHandler handles HTTP requests for users
 type UserHandler struct {
-	db *database.DB
+	users models.UserStore
 }
 
 // NewUserHandler creates a new UserHandler
-func NewUserHandler(db *database.DB) *UserHandler {
-	return &UserHandler{db: db}
+func NewUserHandler(users models.UserStore) *UserHandler {
+	return &UserHandler{users: users}
 }
 
-// List returns all users
+// createUserRequest is the body of a user creation request
+type createUserRequest struct {
+	ClerkID string `json:"clerk_id" validate:"required,max=255"`
+	Email   string `json:"email" validate:"required,email,max=255"`
+	Name    string `json:"name" validate:"max=255"`
+}
+
+// updateUserRequest is the body of a user update request
+type updateUserRequest struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+	Name  string `json:"name" validate:"max=255"`
+}
+
+// userResource returns the policy resource for the user with the given ID
+func userResource(id int) policy.Resource {
+	return policy.Resource{Kind: policy.KindUser, OwnerID: id}
+}
+
+// List returns a page of users, optionally filtered by email prefix
 func (h *UserHandler) List(w http.ResponseWriter, r *http.Request) {
-	users, err := models.GetUsers(h.db)
+	q := newListQuery(r)
+	page := q.page()
+	filter := models.UserFilter{EmailPrefix: q.values.Get("email")}
+	if err := q.err(); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	if err := authorize(r, policy.ActionList, policy.Resource{Kind: policy.KindUser}); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	users, next, err := h.users.GetUsers(r.Context(), filter, page)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		respondError(w, r, err)
 		return
 	}
 
-	respondJSON(w, users)
+	setPageLinks(w, r, next)
+	respondJSON(w, http.StatusOK, users)
 }
 
 // Get returns a user by ID
@@ -36,39 +67,44 @@ func (h *UserHandler) Get(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		respondError(w, r, errBadRequest("Invalid user ID"))
 		return
 	}
 
-	user, err := models.GetUserByID(h.db, id)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !canRead(r, userResource(id)) {
+		respondError(w, r, errNotFound("User not found"))
 		return
 	}
 
-	if user == nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+	user, err := h.users.GetUserByID(r.Context(), id)
+	if err != nil {
+		respondError(w, r, orNotFound(err, "User not found"))
 		return
 	}
 
-	respondJSON(w, user)
+	respondJSON(w, http.StatusOK, user)
 }
 
 // Create creates a new user
 func (h *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
-	var user models.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := authorize(r, policy.ActionCreate, policy.Resource{Kind: policy.KindUser}); err != nil {
+		respondError(w, r, err)
 		return
 	}
 
-	if err := models.CreateUser(h.db, &user); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	var req createUserRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	respondJSON(w, user)
+	user := models.User{ClerkID: req.ClerkID, Email: req.Email, Name: req.Name}
+	if err := h.users.CreateUser(r.Context(), &user); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, user)
 }
 
 // Update updates a user
@@ -76,23 +112,33 @@ func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		respondError(w, r, errBadRequest("Invalid user ID"))
 		return
 	}
 
-	var user models.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !canRead(r, userResource(id)) {
+		respondError(w, r, errNotFound("User not found"))
+		return
+	}
+	if err := authorize(r, policy.ActionUpdate, userResource(id)); err != nil {
+		respondError(w, r, err)
 		return
 	}
 
+	var req updateUserRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, r, err)
+		return
+	}
+
+	user := models.User{Email: req.Email, Name: req.Name}
 	user.ID = id
-	if err := models.UpdateUser(h.db, &user); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.users.UpdateUser(r.Context(), &user); err != nil {
+		respondError(w, r, orNotFound(err, "User not found"))
 		return
 	}
 
-	respondJSON(w, user)
+	respondJSON(w, http.StatusOK, user)
 }
 
 // Delete deletes a user
@@ -100,12 +146,21 @@ func (h *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		respondError(w, r, errBadRequest("Invalid user ID"))
+		return
+	}
+
+	if !canRead(r, userResource(id)) {
+		respondError(w, r, errNotFound("User not found"))
+		return
+	}
+	if err := authorize(r, policy.ActionDelete, userResource(id)); err != nil {
+		respondError(w, r, err)
 		return
 	}
 
-	if err := models.DeleteUser(h.db, id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.users.DeleteUser(r.Context(), id); err != nil {
+		respondError(w, r, orNotFound(err, "User not found"))
 		return
 	}
 
@@ -3,13 +3,41 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+
+	"github.com/your-username/your-repo/internal/auth"
+	"github.com/your-username/your-repo/internal/policy"
+	"github.com/your-username/your-repo/internal/validation"
 )
 
-// respondJSON sends a JSON response
-func respondJSON(w http.ResponseWriter, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(data); err != nil {
+// maxBodyBytes is the largest JSON request body handlers will read
+const maxBodyBytes = 1 << 20
+
+// respondJSON sends data as a JSON response with status
+func respondJSON(w http.ResponseWriter, status int, data interface{}) {
+	body, err := json.Marshal(data)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	// Headers must be set before WriteHeader, or they are not sent
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// decodeJSON decodes and validates a JSON request body into dst
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	return validation.DecodeJSON(w, r, dst, maxBodyBytes)
+}
+
+// authorize checks that the caller may perform action on res
+func authorize(r *http.Request, action policy.Action, res policy.Resource) error {
+	return policy.Authorize(auth.SubjectFromContext(r.Context()), action, res)
+}
+
+// canRead reports whether the caller may read res. Handlers report resources
+// the caller cannot read as not found, so that their existence is not revealed.
+func canRead(r *http.Request, res policy.Resource) bool {
+	return authorize(r, policy.ActionRead, res) == nil
 }
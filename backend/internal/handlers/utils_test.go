@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/your-username/your-repo/internal/validation"
+)
+
+// TestRequestValidationTags checks the validate tags of every request body,
+// which would otherwise only panic when a request first decodes into it
+func TestRequestValidationTags(t *testing.T) {
+	requests := []interface{}{
+		addCartItemRequest{},
+		updateCartItemRequest{},
+		createCartOrderRequest{},
+		couponRequest{},
+		updateMeRequest{},
+		createOrderRequest{},
+		updateOrderRequest{},
+		productRequest{},
+		adjustStockRequest{},
+		createRefundRequest{},
+		grantRoleRequest{},
+		createUserRequest{},
+		updateUserRequest{},
+	}
+	for _, req := range requests {
+		if err := validation.Check(req); err != nil {
+			t.Errorf("%T: %v", req, err)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/webhook"
+	"github.com/your-username/your-repo/internal/config"
+	"github.com/your-username/your-repo/internal/models"
+	"github.com/your-username/your-repo/internal/payments"
+	"github.com/your-username/your-repo/internal/svix"
+)
+
+// maxWebhookBodyBytes caps the size of webhook payloads we are willing to read
+const maxWebhookBodyBytes = 65536
+
+// WebhookHandler handles incoming webhooks from third-party services
+type WebhookHandler struct {
+	events   models.StripeEventStore
+	config   *config.Config
+	payments payments.Client
+	users    models.UserStore
+	// clerk verifies Clerk webhooks; it is nil if they are not configured
+	clerk *svix.Verifier
+}
+
+// NewWebhookHandler creates a new WebhookHandler
+func NewWebhookHandler(events models.StripeEventStore, cfg *config.Config, pc payments.Client, users models.UserStore) *WebhookHandler {
+	h := &WebhookHandler{events: events, config: cfg, payments: pc, users: users}
+
+	if cfg.ClerkWebhookSecret != "" {
+		verifier, err := svix.NewVerifier(cfg.ClerkWebhookSecret)
+		if err != nil {
+			log.Printf("Clerk webhooks disabled: %v", err)
+		} else {
+			h.clerk = verifier
+		}
+	}
+
+	return h
+}
+
+// Stripe verifies and processes a Stripe webhook event
+func (h *WebhookHandler) Stripe(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		respondError(w, r, errBadRequest("Failed to read request body"))
+		return
+	}
+
+	event, err := webhook.ConstructEventWithOptions(payload, r.Header.Get("Stripe-Signature"), h.config.StripeWebhookKey,
+		webhook.ConstructEventOptions{IgnoreAPIVersionMismatch: true})
+	if err != nil {
+		respondError(w, r, errBadRequest("Invalid signature"))
+		return
+	}
+
+	processed, err := h.events.ProcessStripeEvent(r.Context(), event.ID, string(event.Type), func(tx models.StripeEventTx) error {
+		// The update is worked out only once the event is claimed, so that
+		// redelivered events do not call Stripe again
+		update, err := h.orderUpdate(r, event)
+		if err != nil {
+			return err
+		}
+		if update.sessionID == "" {
+			return nil
+		}
+		return h.applyOrderUpdate(r, tx, event, update)
+	})
+	if err != nil {
+		respondError(w, r, fmt.Errorf("stripe webhook %s (%s): %w", event.ID, event.Type, err))
+		return
+	}
+
+	if !processed {
+		log.Printf("stripe webhook %s (%s): already processed", event.ID, event.Type)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// orderUpdate is the change a Stripe event makes to an order
+type orderUpdate struct {
+	// sessionID is the Checkout Session of the order, empty if the event
+	// requires no update
+	sessionID string
+	// status is the status the order moves to, if any
+	status models.OrderStatus
+	// reservedUntil, if set, is when the order's stock reservation now
+	// expires
+	reservedUntil *time.Time
+}
+
+// applyOrderUpdate makes the change of update within tx
+func (h *WebhookHandler) applyOrderUpdate(r *http.Request, tx models.StripeEventTx, event stripe.Event, update orderUpdate) error {
+	if update.status != "" {
+		err := tx.TransitionOrderBySessionID(r.Context(), update.sessionID, update.status, "stripe:"+event.ID)
+		var paidAfterCancel *models.PaidAfterCancelError
+		if errors.As(err, &paidAfterCancel) {
+			return h.refundPaidAfterCancel(r, update.sessionID, paidAfterCancel)
+		}
+		// Events for unknown sessions or that arrive out of order are
+		// acknowledged so Stripe stops redelivering them
+		if errors.Is(err, models.ErrNotFound) || errors.Is(err, models.ErrInvalidTransition) {
+			log.Printf("stripe webhook %s (%s): order for session %s not updated: %v", event.ID, event.Type, update.sessionID, err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if update.reservedUntil != nil {
+		err := tx.SetReservationBySessionID(r.Context(), update.sessionID, *update.reservedUntil)
+		if errors.Is(err, models.ErrNotFound) {
+			log.Printf("stripe webhook %s (%s): reservation for session %s not changed: %v", event.ID, event.Type, update.sessionID, err)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// refundPaidAfterCancel gives back the payment for a canceled order that
+// could not be reinstated. Failures that may be temporary are returned, so
+// that Stripe redelivers the event and the refund is retried under the same
+// idempotency key.
+func (h *WebhookHandler) refundPaidAfterCancel(r *http.Request, sessionID string, e *models.PaidAfterCancelError) error {
+	if e.Total == 0 {
+		log.Printf("ALERT: %v; nothing was charged, so there is nothing to refund", e)
+		return nil
+	}
+
+	refund, err := h.payments.RefundPayment(r.Context(), &payments.RefundParams{
+		OrderID:           e.OrderID,
+		CheckoutSessionID: sessionID,
+		Amount:            int64(e.Total),
+		Reason:            string(stripe.RefundReasonRequestedByCustomer),
+		IdempotencyKey:    "paid-after-cancel-order-" + strconv.Itoa(e.OrderID),
+	})
+	if errors.Is(err, payments.ErrRefundRejected) {
+		log.Printf("ALERT: %v; Stripe rejected the automatic refund, refund session %s by hand: %v", e, sessionID, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("refunding payment for canceled order %d: %w", e.OrderID, err)
+	}
+
+	log.Printf("ALERT: %v; payment refunded automatically as %s", e, refund.ID)
+	return nil
+}
+
+// orderUpdate works out which order an event applies to and how it changes
+func (h *WebhookHandler) orderUpdate(r *http.Request, event stripe.Event) (orderUpdate, error) {
+	switch event.Type {
+	case "checkout.session.completed":
+		var session stripe.CheckoutSession
+		if err := json.Unmarshal(event.Data.Raw, &session); err != nil {
+			return orderUpdate{}, err
+		}
+		// Delayed payment methods complete the session before the money
+		// arrives, which is reported by the async payment events. Hold the
+		// stock until then, as the session can no longer expire.
+		if session.PaymentStatus != stripe.CheckoutSessionPaymentStatusPaid {
+			reservedUntil := time.Now().Add(h.config.AsyncPaymentTTL)
+			return orderUpdate{sessionID: session.ID, reservedUntil: &reservedUntil}, nil
+		}
+		return orderUpdate{sessionID: session.ID, status: models.OrderStatusPaid}, nil
+
+	case "checkout.session.async_payment_succeeded":
+		var session stripe.CheckoutSession
+		if err := json.Unmarshal(event.Data.Raw, &session); err != nil {
+			return orderUpdate{}, err
+		}
+		return orderUpdate{sessionID: session.ID, status: models.OrderStatusPaid}, nil
+
+	case "checkout.session.async_payment_failed":
+		var session stripe.CheckoutSession
+		if err := json.Unmarshal(event.Data.Raw, &session); err != nil {
+			return orderUpdate{}, err
+		}
+		// The session is complete, so payment cannot be retried: let the
+		// reservation expire so that the order is canceled
+		now := time.Now()
+		return orderUpdate{sessionID: session.ID, status: models.OrderStatusPaymentFailed, reservedUntil: &now}, nil
+
+	case "checkout.session.expired":
+		var session stripe.CheckoutSession
+		if err := json.Unmarshal(event.Data.Raw, &session); err != nil {
+			return orderUpdate{}, err
+		}
+		return orderUpdate{sessionID: session.ID, status: models.OrderStatusCanceled}, nil
+
+	case "payment_intent.payment_failed":
+		var intent stripe.PaymentIntent
+		if err := json.Unmarshal(event.Data.Raw, &intent); err != nil {
+			return orderUpdate{}, err
+		}
+		sessionID, err := h.payments.CheckoutSessionIDForPaymentIntent(r.Context(), intent.ID)
+		if err != nil {
+			return orderUpdate{}, err
+		}
+		return orderUpdate{sessionID: sessionID, status: models.OrderStatusPaymentFailed}, nil
+
+	case "charge.refunded":
+		var charge stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
+			return orderUpdate{}, err
+		}
+		// Partial refunds leave the order as paid
+		if !charge.Refunded || charge.PaymentIntent == nil {
+			return orderUpdate{}, nil
+		}
+		sessionID, err := h.payments.CheckoutSessionIDForPaymentIntent(r.Context(), charge.PaymentIntent.ID)
+		if err != nil {
+			return orderUpdate{}, err
+		}
+		return orderUpdate{sessionID: sessionID, status: models.OrderStatusRefunded}, nil
+	}
+
+	return orderUpdate{}, nil
+}
@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v74/webhook"
+	"github.com/your-username/your-repo/internal/config"
+	"github.com/your-username/your-repo/internal/models"
+)
+
+// testWebhookSecret signs the Stripe events webhook handlers are tested with
+const testWebhookSecret = "whsec_test"
+
+// testWebhookConfig is the config webhook handlers are tested with
+var testWebhookConfig = &config.Config{StripeWebhookKey: testWebhookSecret, AsyncPaymentTTL: 72 * time.Hour}
+
+// stripeEvent returns the payload of a Stripe event about object
+func stripeEvent(t *testing.T, id, eventType string, object map[string]interface{}) []byte {
+	t.Helper()
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":     id,
+		"object": "event",
+		"type":   eventType,
+		"data":   map[string]interface{}{"object": object},
+	})
+	if err != nil {
+		t.Fatalf("marshal event: %v", err)
+	}
+	return payload
+}
+
+// deliver sends a Stripe webhook with payload, signed with secret
+func deliver(h *WebhookHandler, payload []byte, secret string) *httptest.ResponseRecorder {
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{Payload: payload, Secret: secret})
+	r := httptest.NewRequest(http.MethodPost, "/api/webhooks/stripe", strings.NewReader(string(payload)))
+	r.Header.Set("Stripe-Signature", signed.Header)
+
+	w := httptest.NewRecorder()
+	h.Stripe(w, r)
+	return w
+}
+
+// newWebhookFixture returns a checkout fixture with an order for two of its
+// product that is awaiting payment through Checkout Session cs_1
+func newWebhookFixture(t *testing.T) (checkoutFixture, *models.Order) {
+	t.Helper()
+	ctx := context.Background()
+	f := newCheckoutFixture(t)
+
+	order := &models.Order{
+		UserID: f.user.ID,
+		Status: models.OrderStatusPending,
+		Items:  []models.OrderItem{{ProductID: f.product.ID, Quantity: 2}},
+	}
+	if err := f.store.CreateOrder(ctx, order); err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if err := f.store.SetOrderStripeSessionID(ctx, order.ID, "cs_1"); err != nil {
+		t.Fatalf("SetOrderStripeSessionID: %v", err)
+	}
+	return f, order
+}
+
+// getOrder returns an order, failing the test if it does not exist
+func getOrder(t *testing.T, store *models.MemoryStore, id int) *models.Order {
+	t.Helper()
+	o, err := store.GetOrderByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetOrderByID(%d): %v", id, err)
+	}
+	return o
+}
+
+// stockOf returns the stock of a product, failing the test if it is not tracked
+func stockOf(t *testing.T, store *models.MemoryStore, id int) int {
+	t.Helper()
+	p, err := store.GetProductByID(context.Background(), id)
+	if err != nil {
+		t.Fatalf("GetProductByID(%d): %v", id, err)
+	}
+	if p.Stock == nil {
+		t.Fatalf("product %d stock is not tracked", id)
+	}
+	return *p.Stock
+}
+
+func TestStripeWebhookEvents(t *testing.T) {
+	session := func(paymentStatus string) map[string]interface{} {
+		return map[string]interface{}{"id": "cs_1", "object": "checkout.session", "payment_status": paymentStatus}
+	}
+	intent := map[string]interface{}{"id": "pi_1", "object": "payment_intent"}
+	charge := func(refunded bool) map[string]interface{} {
+		return map[string]interface{}{"id": "ch_1", "object": "charge", "refunded": refunded, "payment_intent": "pi_1"}
+	}
+
+	tests := []struct {
+		name      string
+		eventType string
+		object    map[string]interface{}
+		// from is the status the order is moved to before the event
+		from models.OrderStatus
+		// outOfStock sells the order's stock before the event
+		outOfStock bool
+
+		wantStatus   models.OrderStatus
+		wantReserved func(until *time.Time) bool
+		wantStock    int
+		wantRefunds  int
+	}{
+		{
+			name:         "session completed and paid",
+			eventType:    "checkout.session.completed",
+			object:       session("paid"),
+			wantStatus:   models.OrderStatusPaid,
+			wantReserved: isNil,
+			wantStock:    3,
+		},
+		{
+			name:       "session completed awaiting payment",
+			eventType:  "checkout.session.completed",
+			object:     session("unpaid"),
+			wantStatus: models.OrderStatusPending,
+			wantReserved: func(until *time.Time) bool {
+				return until != nil && until.After(time.Now().Add(71*time.Hour))
+			},
+			wantStock: 3,
+		},
+		{
+			name:         "async payment succeeded",
+			eventType:    "checkout.session.async_payment_succeeded",
+			object:       session("paid"),
+			wantStatus:   models.OrderStatusPaid,
+			wantReserved: isNil,
+			wantStock:    3,
+		},
+		{
+			name:       "async payment failed",
+			eventType:  "checkout.session.async_payment_failed",
+			object:     session("unpaid"),
+			wantStatus: models.OrderStatusPaymentFailed,
+			wantReserved: func(until *time.Time) bool {
+				return until != nil && !until.After(time.Now())
+			},
+			wantStock: 3,
+		},
+		{
+			name:         "session expired",
+			eventType:    "checkout.session.expired",
+			object:       session("unpaid"),
+			wantStatus:   models.OrderStatusCanceled,
+			wantReserved: isNil,
+			wantStock:    5,
+		},
+		{
+			name:         "payment failed",
+			eventType:    "payment_intent.payment_failed",
+			object:       intent,
+			wantStatus:   models.OrderStatusPaymentFailed,
+			wantReserved: notNil,
+			wantStock:    3,
+		},
+		{
+			name:         "charge fully refunded",
+			eventType:    "charge.refunded",
+			object:       charge(true),
+			from:         models.OrderStatusPaid,
+			wantStatus:   models.OrderStatusRefunded,
+			wantReserved: isNil,
+			wantStock:    3,
+		},
+		{
+			name:         "charge partially refunded",
+			eventType:    "charge.refunded",
+			object:       charge(false),
+			from:         models.OrderStatusPaid,
+			wantStatus:   models.OrderStatusPaid,
+			wantReserved: isNil,
+			wantStock:    3,
+		},
+		{
+			name:         "paid after cancel is reinstated",
+			eventType:    "checkout.session.completed",
+			object:       session("paid"),
+			from:         models.OrderStatusCanceled,
+			wantStatus:   models.OrderStatusPaid,
+			wantReserved: isNil,
+			wantStock:    3,
+		},
+		{
+			name:         "paid after cancel without stock is refunded",
+			eventType:    "checkout.session.completed",
+			object:       session("paid"),
+			from:         models.OrderStatusCanceled,
+			outOfStock:   true,
+			wantStatus:   models.OrderStatusCanceled,
+			wantReserved: isNil,
+			wantStock:    0,
+			wantRefunds:  1,
+		},
+		{
+			name:         "unhandled event",
+			eventType:    "customer.created",
+			object:       map[string]interface{}{"id": "cus_1", "object": "customer"},
+			wantStatus:   models.OrderStatusPending,
+			wantReserved: notNil,
+			wantStock:    3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			f, order := newWebhookFixture(t)
+			if tt.from != "" {
+				if err := f.store.TransitionOrder(ctx, order.ID, models.OrderStatusPending, tt.from, "test"); err != nil {
+					t.Fatalf("TransitionOrder: %v", err)
+				}
+			}
+			if tt.outOfStock {
+				if _, err := f.store.AdjustProductStock(ctx, f.product.ID, -stockOf(t, f.store, f.product.ID)); err != nil {
+					t.Fatalf("AdjustProductStock: %v", err)
+				}
+			}
+
+			pc := &fakePayments{sessionForIntent: map[string]string{"pi_1": "cs_1"}}
+			h := NewWebhookHandler(f.store, testWebhookConfig, pc, f.store)
+			w := deliver(h, stripeEvent(t, "evt_1", tt.eventType, tt.object), testWebhookSecret)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			got := getOrder(t, f.store, order.ID)
+			if got.Status != tt.wantStatus {
+				t.Errorf("order status = %s, want %s", got.Status, tt.wantStatus)
+			}
+			if !tt.wantReserved(got.ReservedUntil) {
+				t.Errorf("order reserved until %v", got.ReservedUntil)
+			}
+			if stock := stockOf(t, f.store, f.product.ID); stock != tt.wantStock {
+				t.Errorf("stock = %d, want %d", stock, tt.wantStock)
+			}
+			if len(pc.refunds) != tt.wantRefunds {
+				t.Errorf("made %d refunds, want %d", len(pc.refunds), tt.wantRefunds)
+			}
+		})
+	}
+}
+
+func TestStripeWebhookRejectsBadSignature(t *testing.T) {
+	f, order := newWebhookFixture(t)
+	pc := &fakePayments{sessionForIntent: map[string]string{"pi_1": "cs_1"}}
+	h := NewWebhookHandler(f.store, testWebhookConfig, pc, f.store)
+
+	payload := stripeEvent(t, "evt_1", "payment_intent.payment_failed", map[string]interface{}{"id": "pi_1"})
+	w := deliver(h, payload, "whsec_other")
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+	if got := getOrder(t, f.store, order.ID); got.Status != models.OrderStatusPending {
+		t.Errorf("order status = %s, want %s", got.Status, models.OrderStatusPending)
+	}
+	if pc.lookups != 0 {
+		t.Errorf("looked up %d sessions in Stripe, want 0", pc.lookups)
+	}
+
+	// The event was not recorded, so it is processed once correctly signed
+	if w := deliver(h, payload, testWebhookSecret); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if got := getOrder(t, f.store, order.ID); got.Status != models.OrderStatusPaymentFailed {
+		t.Errorf("order status = %s, want %s", got.Status, models.OrderStatusPaymentFailed)
+	}
+}
+
+func TestStripeWebhookIgnoresDuplicateEvents(t *testing.T) {
+	f, order := newWebhookFixture(t)
+	pc := &fakePayments{sessionForIntent: map[string]string{"pi_1": "cs_1"}}
+	h := NewWebhookHandler(f.store, testWebhookConfig, pc, f.store)
+
+	payload := stripeEvent(t, "evt_1", "payment_intent.payment_failed", map[string]interface{}{"id": "pi_1"})
+	for i := 0; i < 2; i++ {
+		if w := deliver(h, payload, testWebhookSecret); w.Code != http.StatusOK {
+			t.Fatalf("delivery %d: status = %d, want %d: %s", i+1, w.Code, http.StatusOK, w.Body)
+		}
+	}
+
+	if pc.lookups != 1 {
+		t.Errorf("looked up %d sessions in Stripe, want 1", pc.lookups)
+	}
+	history, err := f.store.GetOrderStatusHistory(context.Background(), order.ID)
+	if err != nil {
+		t.Fatalf("GetOrderStatusHistory: %v", err)
+	}
+	if len(history) != 1 || history[0].ChangedBy != "stripe:evt_1" {
+		t.Errorf("status history = %+v, want one change by stripe:evt_1", history)
+	}
+}
+
+func TestStripeWebhookRetriesFailedEvents(t *testing.T) {
+	f, order := newWebhookFixture(t)
+	pc := &fakePayments{sessionForIntent: map[string]string{"pi_1": "cs_1"}, err: errTestStripe}
+	h := NewWebhookHandler(f.store, testWebhookConfig, pc, f.store)
+
+	payload := stripeEvent(t, "evt_1", "payment_intent.payment_failed", map[string]interface{}{"id": "pi_1"})
+	if w := deliver(h, payload, testWebhookSecret); w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusInternalServerError, w.Body)
+	}
+
+	// The failed delivery was not recorded, so Stripe's retry is processed
+	pc.err = nil
+	if w := deliver(h, payload, testWebhookSecret); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if got := getOrder(t, f.store, order.ID); got.Status != models.OrderStatusPaymentFailed {
+		t.Errorf("order status = %s, want %s", got.Status, models.OrderStatusPaymentFailed)
+	}
+}
+
+func isNil(t *time.Time) bool  { return t == nil }
+func notNil(t *time.Time) bool { return t != nil }
@@ -0,0 +1,45 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/your-username/your-repo/internal/config"
+	"github.com/your-username/your-repo/internal/database"
+	"github.com/your-username/your-repo/internal/migrations"
+)
+
+// DatabaseCheck pings the database
+func DatabaseCheck(db *database.DB) Check {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+// MigrationsCheck fails unless every embedded migration has been applied
+func MigrationsCheck(m *migrations.Migrator) Check {
+	return func(ctx context.Context) error {
+		version, err := m.Version(ctx)
+		if err != nil {
+			return err
+		}
+		if latest := m.Latest(); version != latest {
+			return fmt.Errorf("schema at version %d, expected %d", version, latest)
+		}
+		return nil
+	}
+}
+
+// StripeConfigCheck fails if the Stripe keys are not configured
+func StripeConfigCheck(cfg *config.Config) Check {
+	return func(ctx context.Context) error {
+		if cfg.StripeSecretKey == "" {
+			return errors.New("STRIPE_SECRET_KEY is not set")
+		}
+		if cfg.StripeWebhookKey == "" {
+			return errors.New("STRIPE_WEBHOOK_SECRET is not set")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,140 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check reports an error if the subsystem it checks is not healthy
+type Check func(ctx context.Context) error
+
+// Status is the outcome of a check or of a whole report
+type Status string
+
+// Check statuses
+const (
+	StatusOK   Status = "ok"
+	StatusFail Status = "fail"
+)
+
+// Result is the outcome of a single check
+type Result struct {
+	Name       string  `json:"name"`
+	Status     Status  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// Report is the outcome of running every registered check
+type Report struct {
+	Status Status   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Registry holds the readiness checks of the server. Subsystems register
+// their own checks with Register.
+type Registry struct {
+	// Timeout bounds each individual check
+	Timeout time.Duration
+
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+// NewRegistry creates a new Registry
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{Timeout: timeout}
+}
+
+// Register adds a named check to the registry
+func (r *Registry) Register(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checks = append(r.checks, namedCheck{name: name, check: check})
+}
+
+// Run runs every registered check concurrently and reports the results in
+// registration order
+func (r *Registry) Run(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := append([]namedCheck(nil), r.checks...)
+	r.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c namedCheck) {
+			defer wg.Done()
+			results[i] = r.run(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: results}
+	for _, res := range results {
+		if res.Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+
+	return report
+}
+
+// run runs a single check within the registry's timeout
+func (r *Registry) run(ctx context.Context, c namedCheck) Result {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := c.check(ctx)
+	res := Result{
+		Name:       c.name,
+		Status:     StatusOK,
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		res.Status = StatusFail
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+// ReadinessHandler runs the registered checks, responding 200 if they all
+// pass and 503 otherwise
+func (r *Registry) ReadinessHandler(w http.ResponseWriter, req *http.Request) {
+	report := r.Run(req.Context())
+
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, report)
+}
+
+// LivenessHandler responds 200 as long as the process can serve requests
+func LivenessHandler(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, http.StatusOK, Report{Status: StatusOK, Checks: []Result{}})
+}
+
+// writeJSON writes v as an uncacheable JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,67 @@
+-- Tables previously created by the frontend's Drizzle schema. IF NOT EXISTS
+-- lets databases that were set up that way adopt the migrations.
+
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    clerk_id TEXT NOT NULL UNIQUE,
+    email TEXT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS products (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    price INTEGER NOT NULL,
+    stripe_product_id TEXT NOT NULL DEFAULT '',
+    stripe_price_id TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users (id),
+    status TEXT NOT NULL DEFAULT 'pending',
+    total INTEGER NOT NULL,
+    stripe_session_id TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Drizzle left these columns nullable and without defaults, which IF NOT
+-- EXISTS keeps on adopted databases. Fill in their NULLs and constrain them
+-- as in a new database; on a new database this changes nothing.
+UPDATE users SET name = '' WHERE name IS NULL;
+ALTER TABLE users ALTER COLUMN name SET DEFAULT '', ALTER COLUMN name SET NOT NULL;
+
+UPDATE products SET description = '' WHERE description IS NULL;
+UPDATE products SET stripe_product_id = '' WHERE stripe_product_id IS NULL;
+UPDATE products SET stripe_price_id = '' WHERE stripe_price_id IS NULL;
+ALTER TABLE products
+    ALTER COLUMN description SET DEFAULT '',
+    ALTER COLUMN description SET NOT NULL,
+    ALTER COLUMN stripe_product_id SET DEFAULT '',
+    ALTER COLUMN stripe_product_id SET NOT NULL,
+    ALTER COLUMN stripe_price_id SET DEFAULT '',
+    ALTER COLUMN stripe_price_id SET NOT NULL;
+
+UPDATE orders SET stripe_session_id = '' WHERE stripe_session_id IS NULL;
+ALTER TABLE orders ALTER COLUMN stripe_session_id SET DEFAULT '', ALTER COLUMN stripe_session_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS orders_user_id_idx ON orders (user_id);
+CREATE INDEX IF NOT EXISTS orders_stripe_session_id_idx ON orders (stripe_session_id);
+
+CREATE TABLE IF NOT EXISTS order_items (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders (id),
+    product_id INTEGER NOT NULL REFERENCES products (id),
+    quantity INTEGER NOT NULL,
+    price INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS order_items_order_id_idx ON order_items (order_id);
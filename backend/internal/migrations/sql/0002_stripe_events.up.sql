@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS stripe_events (
+    id TEXT PRIMARY KEY,
+    type TEXT NOT NULL,
+    processed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_role_changes;
+ALTER TABLE users DROP COLUMN IF EXISTS role;
@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'customer'
+    CHECK (role IN ('customer', 'staff', 'admin'));
+
+-- Role changes are kept after the user is deleted, so user_id is not a foreign key
+CREATE TABLE IF NOT EXISTS user_role_changes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    from_role TEXT NOT NULL,
+    to_role TEXT NOT NULL,
+    changed_by TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS user_role_changes_user_id_idx ON user_role_changes (user_id);
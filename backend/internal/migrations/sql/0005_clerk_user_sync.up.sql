@@ -0,0 +1,6 @@
+-- clerk_synced_at is the time of the newest Clerk webhook applied to the
+-- user, so that redelivered and out-of-order events can be ignored
+ALTER TABLE users ADD COLUMN IF NOT EXISTS clerk_synced_at TIMESTAMP;
+
+-- Users deleted in Clerk keep their row, scrubbed, because orders reference it
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
@@ -0,0 +1,12 @@
+-- Units available to order. Products whose stock is NULL are not tracked,
+-- which is what existing products start as.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS stock INTEGER CHECK (stock >= 0);
+
+-- Orders hold the stock of their items from creation. Until reserved_until
+-- the hold is a reservation: it is released if the order is canceled, and
+-- pending orders still holding one after it are canceled. It is cleared once
+-- the order is paid or the stock is released.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS reserved_until TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS orders_reserved_until_idx ON orders (reserved_until)
+    WHERE reserved_until IS NOT NULL;
@@ -0,0 +1,31 @@
+-- Refunds issued through the API. A refund is recorded as pending before it
+-- is sent to Stripe, so that its lines count as refunded while it is in
+-- flight, and becomes issued or failed once Stripe has answered.
+CREATE TABLE IF NOT EXISTS refunds (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders (id),
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'issued', 'failed')),
+    -- Whether the refund was for everything left to refund rather than lines
+    full_refund BOOLEAN NOT NULL DEFAULT FALSE,
+    reason TEXT NOT NULL DEFAULT '',
+    stripe_refund_id TEXT NOT NULL DEFAULT '',
+    -- The Idempotency-Key of the request that created the refund
+    idempotency_key TEXT NOT NULL UNIQUE,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS refunds_order_id_idx ON refunds (order_id);
+
+CREATE TABLE IF NOT EXISTS refund_items (
+    id SERIAL PRIMARY KEY,
+    refund_id INTEGER NOT NULL REFERENCES refunds (id),
+    order_item_id INTEGER NOT NULL REFERENCES order_items (id),
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    amount INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS refund_items_refund_id_idx ON refund_items (refund_id);
+CREATE INDEX IF NOT EXISTS refund_items_order_item_id_idx ON refund_items (order_item_id);
@@ -0,0 +1,18 @@
+-- Idempotency-Key headers of mutating requests and the responses to them,
+-- replayed when a request is retried. A key with no status is claimed by a
+-- request still in flight.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    -- The Clerk user ID of the caller; keys are unique per caller
+    scope TEXT NOT NULL,
+    key TEXT NOT NULL,
+    -- SHA-256 of the request method, path and body
+    fingerprint TEXT NOT NULL,
+    status INTEGER,
+    content_type TEXT NOT NULL DEFAULT '',
+    body BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,25 @@
+-- Shopping carts, belonging either to a user or to an anonymous visitor
+-- holding the cart's token in a cookie. Only a SHA-256 hash of the token is
+-- stored. Anonymous carts are merged into the user's cart on sign-in.
+CREATE TABLE IF NOT EXISTS carts (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER UNIQUE REFERENCES users (id) ON DELETE CASCADE,
+    token_hash TEXT UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK ((user_id IS NULL) <> (token_hash IS NULL))
+);
+
+-- Cart items are priced from the products table whenever the cart is read,
+-- and disappear from carts when their product is deleted
+CREATE TABLE IF NOT EXISTS cart_items (
+    id SERIAL PRIMARY KEY,
+    cart_id INTEGER NOT NULL REFERENCES carts (id) ON DELETE CASCADE,
+    product_id INTEGER NOT NULL REFERENCES products (id) ON DELETE CASCADE,
+    quantity INTEGER NOT NULL CHECK (quantity > 0 AND quantity <= 1000),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (cart_id, product_id)
+);
+
+CREATE INDEX IF NOT EXISTS carts_updated_at_idx ON carts (updated_at) WHERE token_hash IS NOT NULL;
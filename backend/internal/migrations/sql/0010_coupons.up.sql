@@ -0,0 +1,30 @@
+-- Coupon codes, redeemed when an order is created. Percent coupons take
+-- value percent off; fixed coupons take value cents off, spread over the
+-- lines they apply to. Coupons with product_ids only discount those
+-- products; the rest apply to the whole order.
+CREATE TABLE IF NOT EXISTS coupons (
+    id SERIAL PRIMARY KEY,
+    -- Stored upper-case, as codes are case-insensitive
+    code TEXT NOT NULL UNIQUE CHECK (code = UPPER(code)),
+    kind TEXT NOT NULL CHECK (kind IN ('percent', 'fixed')),
+    value INTEGER NOT NULL CHECK (value > 0 AND (kind <> 'percent' OR value <= 100)),
+    product_ids INTEGER[] NOT NULL DEFAULT '{}',
+    min_subtotal INTEGER NOT NULL DEFAULT 0 CHECK (min_subtotal >= 0),
+    starts_at TIMESTAMP,
+    ends_at TIMESTAMP,
+    -- Redemption limits, counting orders that were not canceled
+    max_redemptions INTEGER CHECK (max_redemptions > 0),
+    max_redemptions_per_user INTEGER CHECK (max_redemptions_per_user > 0),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK (ends_at IS NULL OR starts_at IS NULL OR ends_at > starts_at)
+);
+
+-- Orders keep the code they were placed with, and the total stays net of
+-- the discount. Each item records its share of the discount.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS coupon_id INTEGER REFERENCES coupons (id);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS coupon_code TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount INTEGER NOT NULL DEFAULT 0 CHECK (discount >= 0);
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS discount INTEGER NOT NULL DEFAULT 0 CHECK (discount >= 0);
+
+CREATE INDEX IF NOT EXISTS orders_coupon_id_idx ON orders (coupon_id, user_id) WHERE coupon_id IS NOT NULL;
@@ -0,0 +1,331 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/your-username/your-repo/internal/database"
+)
+
+// MaxCartQuantity is the largest quantity of a product a cart can hold, the
+// most that can be ordered at once
+const MaxCartQuantity = 1000
+
+var (
+	// ErrCartEmpty is returned when converting a cart with no items
+	ErrCartEmpty = errors.New("cart is empty")
+	// ErrCartQuantityLimit is returned when adding to a cart item would take
+	// it over MaxCartQuantity
+	ErrCartQuantityLimit = errors.New("cart item quantity limit exceeded")
+)
+
+// CartOwner identifies a cart: a user's, or an anonymous visitor's by the
+// token the visitor holds
+type CartOwner struct {
+	UserID int
+	Token  string
+}
+
+// columns returns the carts.user_id and carts.token_hash values of the owner
+func (o CartOwner) columns() (userID sql.NullInt64, tokenHash sql.NullString) {
+	if o.UserID != 0 {
+		return sql.NullInt64{Int64: int64(o.UserID), Valid: true}, tokenHash
+	}
+	return userID, sql.NullString{String: hashCartToken(o.Token), Valid: true}
+}
+
+// hashCartToken returns the hash stored for an anonymous cart's token
+func hashCartToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Cart is a shopping cart. Its items are priced from the products whenever
+// it is read, so they always show current prices.
+type Cart struct {
+	ID        int        `json:"id"`
+	UserID    *int       `json:"user_id,omitempty"` // Nil for anonymous carts
+	Items     []CartItem `json:"items"`
+	Subtotal  int        `json:"subtotal"` // Subtotal in cents
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// CartItem is a quantity of a product in a cart
+type CartItem struct {
+	ID        int       `json:"id"`
+	ProductID int       `json:"product_id"`
+	Name      string    `json:"name"`
+	Quantity  int       `json:"quantity"`
+	Price     int       `json:"price"` // Current price in cents
+	Total     int       `json:"total"` // Price times quantity, in cents
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// price sets the totals of c from its items' prices
+func (c *Cart) price() {
+	c.Subtotal = 0
+	for i := range c.Items {
+		item := &c.Items[i]
+		item.Total = item.Price * item.Quantity
+		c.Subtotal += item.Total
+	}
+}
+
+// PostgresCartStore implements CartStore on top of Postgres
+type PostgresCartStore struct {
+	db *database.DB
+}
+
+// NewPostgresCartStore creates a new PostgresCartStore
+func NewPostgresCartStore(db *database.DB) *PostgresCartStore {
+	return &PostgresCartStore{db: db}
+}
+
+// GetCart returns the cart of owner, with its items priced
+func (s *PostgresCartStore) GetCart(ctx context.Context, owner CartOwner) (*Cart, error) {
+	userID, tokenHash := owner.columns()
+	var c Cart
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, user_id, created_at, updated_at
+		FROM carts
+		WHERE user_id = $1 OR token_hash = $2
+	`, userID, tokenHash).Scan(&c.ID, &c.UserID, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT ci.id, ci.product_id, p.name, ci.quantity, p.price, ci.created_at, ci.updated_at
+		FROM cart_items ci
+		JOIN products p ON p.id = ci.product_id
+		WHERE ci.cart_id = $1
+		ORDER BY ci.id
+	`, c.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	c.Items = []CartItem{}
+	for rows.Next() {
+		var item CartItem
+		if err := rows.Scan(&item.ID, &item.ProductID, &item.Name, &item.Quantity, &item.Price, &item.CreatedAt, &item.UpdatedAt); err != nil {
+			return nil, err
+		}
+		c.Items = append(c.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	c.price()
+	return &c, nil
+}
+
+// ProvisionCart returns the cart of owner, creating an empty one if owner
+// has none
+func (s *PostgresCartStore) ProvisionCart(ctx context.Context, owner CartOwner) (*Cart, error) {
+	userID, tokenHash := owner.columns()
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO carts (user_id, token_hash, created_at, updated_at)
+		VALUES ($1, $2, $3, $3)
+		ON CONFLICT DO NOTHING
+	`, userID, tokenHash, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetCart(ctx, owner)
+}
+
+// AddCartItem adds quantity of a product to a cart, on top of any already
+// in it
+func (s *PostgresCartStore) AddCartItem(ctx context.Context, cartID, productID, quantity int) error {
+	now := time.Now()
+	var id int
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO cart_items (cart_id, product_id, quantity, created_at, updated_at)
+		SELECT $1, id, $3, $4, $4 FROM products WHERE id = $2
+		ON CONFLICT (cart_id, product_id) DO UPDATE
+		SET quantity = cart_items.quantity + EXCLUDED.quantity, updated_at = EXCLUDED.updated_at
+		WHERE cart_items.quantity + EXCLUDED.quantity <= $5
+		RETURNING id
+	`, cartID, productID, quantity, now, MaxCartQuantity).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Either the product does not exist or the update was skipped
+		var exists bool
+		err = s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)`, productID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return &ProductNotFoundError{ProductID: productID}
+		}
+		return ErrCartQuantityLimit
+	}
+	if err != nil {
+		return err
+	}
+
+	return touchCart(ctx, s.db, cartID, now)
+}
+
+// SetCartItemQuantity sets the quantity of an item in a cart
+func (s *PostgresCartStore) SetCartItemQuantity(ctx context.Context, cartID, itemID, quantity int) error {
+	now := time.Now()
+	err := requireRows(s.db.ExecContext(ctx, `
+		UPDATE cart_items SET quantity = $3, updated_at = $4 WHERE id = $2 AND cart_id = $1
+	`, cartID, itemID, quantity, now))
+	if err != nil {
+		return err
+	}
+
+	return touchCart(ctx, s.db, cartID, now)
+}
+
+// RemoveCartItem removes an item from a cart
+func (s *PostgresCartStore) RemoveCartItem(ctx context.Context, cartID, itemID int) error {
+	err := requireRows(s.db.ExecContext(ctx, `DELETE FROM cart_items WHERE id = $2 AND cart_id = $1`, cartID, itemID))
+	if err != nil {
+		return err
+	}
+
+	return touchCart(ctx, s.db, cartID, time.Now())
+}
+
+// ClearCart removes every item from a cart
+func (s *PostgresCartStore) ClearCart(ctx context.Context, cartID int) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM cart_items WHERE cart_id = $1`, cartID); err != nil {
+		return err
+	}
+
+	return touchCart(ctx, s.db, cartID, time.Now())
+}
+
+// MergeCart moves the items of the anonymous cart holding token into the
+// cart of a user, adding up the quantities of products in both, and deletes
+// the anonymous cart. It does nothing if there is no cart with token.
+func (s *PostgresCartStore) MergeCart(ctx context.Context, token string, userID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var fromID int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM carts WHERE token_hash = $1 FOR UPDATE`, hashCartToken(token)).Scan(&fromID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO carts (user_id, created_at, updated_at)
+		VALUES ($1, $2, $2)
+		ON CONFLICT DO NOTHING
+	`, userID, now)
+	if err != nil {
+		return err
+	}
+	var intoID int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM carts WHERE user_id = $1 FOR UPDATE`, userID).Scan(&intoID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO cart_items (cart_id, product_id, quantity, created_at, updated_at)
+		SELECT $2, product_id, quantity, created_at, $3 FROM cart_items WHERE cart_id = $1
+		ON CONFLICT (cart_id, product_id) DO UPDATE
+		SET quantity = LEAST(cart_items.quantity + EXCLUDED.quantity, $4), updated_at = EXCLUDED.updated_at
+	`, fromID, intoID, now, MaxCartQuantity)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM carts WHERE id = $1`, fromID); err != nil {
+		return err
+	}
+	if err := touchCart(ctx, tx, intoID, now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ConvertCart creates o from the items of a cart and empties the cart, in
+// the same transaction as CreateOrder would create o in, so that the cart
+// is only emptied if the order is created
+func (s *PostgresCartStore) ConvertCart(ctx context.Context, cartID int, o *Order) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the items so that a concurrent conversion waits and finds the
+	// cart empty
+	rows, err := tx.QueryContext(ctx, `
+		SELECT product_id, quantity FROM cart_items WHERE cart_id = $1 ORDER BY id FOR UPDATE
+	`, cartID)
+	if err != nil {
+		return err
+	}
+	o.Items = nil
+	for rows.Next() {
+		var item OrderItem
+		if err := rows.Scan(&item.ProductID, &item.Quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		o.Items = append(o.Items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(o.Items) == 0 {
+		return ErrCartEmpty
+	}
+
+	if err := insertOrder(ctx, tx, o); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM cart_items WHERE cart_id = $1`, cartID); err != nil {
+		return err
+	}
+	if err := touchCart(ctx, tx, cartID, o.CreatedAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteStaleCarts deletes the anonymous carts last changed before before
+// and returns how many it deleted
+func (s *PostgresCartStore) DeleteStaleCarts(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM carts WHERE token_hash IS NOT NULL AND updated_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// touchCart records that a cart changed at now
+func touchCart(ctx context.Context, q interface {
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+}, cartID int, now time.Time) error {
+	_, err := q.ExecContext(ctx, `UPDATE carts SET updated_at = $2 WHERE id = $1`, cartID, now)
+	return err
+}
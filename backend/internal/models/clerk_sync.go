@@ -0,0 +1,68 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// UpsertClerkUser creates or updates the user with u's Clerk ID from a Clerk
+// webhook describing the user as of syncedAt. Events older than the last one
+// applied, including redeliveries, and events for deleted users are ignored.
+// It reports whether the event was applied, in which case u is filled in from
+// the stored user.
+func (s *PostgresUserStore) UpsertClerkUser(ctx context.Context, u *User, syncedAt time.Time) (bool, error) {
+	now := time.Now()
+
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO users (clerk_id, email, name, role, clerk_synced_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)
+		ON CONFLICT (clerk_id) DO UPDATE
+		SET email = EXCLUDED.email,
+			name = EXCLUDED.name,
+			clerk_synced_at = EXCLUDED.clerk_synced_at,
+			updated_at = EXCLUDED.updated_at
+		WHERE users.deleted_at IS NULL
+			AND (users.clerk_synced_at IS NULL OR users.clerk_synced_at < EXCLUDED.clerk_synced_at)
+		RETURNING id, role, created_at, updated_at
+	`, u.ClerkID, u.Email, u.Name, RoleCustomer, syncedAt, now).Scan(&u.ID, &u.Role, &u.CreatedAt, &u.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// DeleteClerkUser marks the user with the given Clerk ID deleted as of
+// deletedAt and scrubs their email and name. The row is kept because orders
+// reference it; if the user is unknown, a deleted row is created so that
+// events for them arriving late are ignored. It reports whether the event
+// was applied.
+func (s *PostgresUserStore) DeleteClerkUser(ctx context.Context, clerkID string, deletedAt time.Time) (bool, error) {
+	now := time.Now()
+
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO users (clerk_id, email, name, role, clerk_synced_at, deleted_at, created_at, updated_at)
+		VALUES ($1, '', '', $2, $3, $3, $4, $4)
+		ON CONFLICT (clerk_id) DO UPDATE
+		SET email = '',
+			name = '',
+			clerk_synced_at = EXCLUDED.clerk_synced_at,
+			deleted_at = EXCLUDED.deleted_at,
+			updated_at = EXCLUDED.updated_at
+		WHERE users.clerk_synced_at IS NULL OR users.clerk_synced_at < EXCLUDED.clerk_synced_at
+	`, clerkID, RoleCustomer, deletedAt, now)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,313 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/your-username/your-repo/internal/database"
+)
+
+// CouponKind is how a coupon discounts
+type CouponKind string
+
+// Coupon kinds
+const (
+	// CouponPercent takes a percentage off the items it applies to
+	CouponPercent CouponKind = "percent"
+	// CouponFixed takes an amount off, spread over the items it applies to
+	CouponFixed CouponKind = "fixed"
+)
+
+var (
+	// ErrCouponRedeemed is returned when deleting a coupon that orders were
+	// placed with
+	ErrCouponRedeemed = errors.New("coupon has been redeemed")
+	// ErrInvalidCouponValue is returned when saving a percent coupon whose
+	// value is not from 1 to 100, or a fixed one whose value is not positive
+	ErrInvalidCouponValue = errors.New("invalid coupon value")
+)
+
+// CouponError is returned when an order's coupon cannot be used
+type CouponError struct {
+	Code    string
+	Message string
+}
+
+func (e *CouponError) Error() string {
+	return fmt.Sprintf("coupon %q %s", e.Code, e.Message)
+}
+
+// Coupon is a discount code customers can apply to an order
+type Coupon struct {
+	ID   int        `json:"id"`
+	Code string     `json:"code"`
+	Kind CouponKind `json:"kind"`
+	// Value is the percentage off for percent coupons, and the amount off in
+	// cents for fixed ones
+	Value int `json:"value"`
+	// ProductIDs are the products the coupon discounts, or empty for every
+	// item in the order
+	ProductIDs  []int      `json:"product_ids"`
+	MinSubtotal int        `json:"min_subtotal"` // Minimum order subtotal in cents
+	StartsAt    *time.Time `json:"starts_at"`
+	EndsAt      *time.Time `json:"ends_at"`
+	// Redemption limits, nil if unlimited. Orders that were canceled do not
+	// count.
+	MaxRedemptions        *int      `json:"max_redemptions"`
+	MaxRedemptionsPerUser *int      `json:"max_redemptions_per_user"`
+	CreatedAt             time.Time `json:"created_at"`
+	UpdatedAt             time.Time `json:"updated_at"`
+}
+
+// normalizeCouponCode returns the form coupon codes are stored and looked
+// up in, as they are case-insensitive
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// validValue reports whether the coupon's value makes sense for its kind
+func (c *Coupon) validValue() bool {
+	switch c.Kind {
+	case CouponPercent:
+		return c.Value >= 1 && c.Value <= 100
+	case CouponFixed:
+		return c.Value > 0
+	}
+	return false
+}
+
+// appliesTo reports whether the coupon discounts a product
+func (c *Coupon) appliesTo(productID int) bool {
+	if len(c.ProductIDs) == 0 {
+		return true
+	}
+	for _, id := range c.ProductIDs {
+		if id == productID {
+			return true
+		}
+	}
+	return false
+}
+
+// applyCoupon checks that c can be used on o, whose items are priced, and
+// sets the discount of each item it applies to and o's discount and total.
+// redeemed is how many orders c has been redeemed on and redeemedByUser how
+// many of those were placed by o's user.
+func applyCoupon(c *Coupon, o *Order, now time.Time, redeemed, redeemedByUser int) error {
+	fail := func(message string) error { return &CouponError{Code: c.Code, Message: message} }
+	switch {
+	case !c.validValue():
+		return fail("has an invalid value")
+	case c.StartsAt != nil && now.Before(*c.StartsAt):
+		return fail("is not active yet")
+	case c.EndsAt != nil && !now.Before(*c.EndsAt):
+		return fail("has expired")
+	case c.MaxRedemptions != nil && redeemed >= *c.MaxRedemptions:
+		return fail("has been fully redeemed")
+	case c.MaxRedemptionsPerUser != nil && redeemedByUser >= *c.MaxRedemptionsPerUser:
+		return fail("has already been redeemed the maximum number of times")
+	}
+
+	subtotal, eligible := 0, 0
+	for _, item := range o.Items {
+		subtotal += item.Price * item.Quantity
+		if c.appliesTo(item.ProductID) {
+			eligible += item.Price * item.Quantity
+		}
+	}
+	switch {
+	case subtotal < c.MinSubtotal:
+		return fail(fmt.Sprintf("requires a subtotal of at least %d", c.MinSubtotal))
+	case eligible == 0:
+		return fail("does not apply to any item in the order")
+	}
+
+	// Work out the whole discount, then spread it over the lines in
+	// proportion to their totals, handing out the cents lost to rounding
+	// down from the first line
+	amount := c.Value
+	if c.Kind == CouponPercent {
+		amount = eligible * c.Value / 100
+	}
+	if amount > eligible {
+		amount = eligible
+	}
+	left := amount
+	for i := range o.Items {
+		item := &o.Items[i]
+		if c.appliesTo(item.ProductID) {
+			item.Discount = amount * item.Price * item.Quantity / eligible
+			left -= item.Discount
+		}
+	}
+	for i := range o.Items {
+		item := &o.Items[i]
+		if left == 0 {
+			break
+		}
+		if c.appliesTo(item.ProductID) && item.Discount < item.Price*item.Quantity {
+			item.Discount++
+			left--
+		}
+	}
+
+	o.Discount = 0
+	for _, item := range o.Items {
+		o.Discount += item.Discount
+	}
+	o.Total = subtotal - o.Discount
+	o.CouponID = &c.ID
+	o.CouponCode = c.Code
+	return nil
+}
+
+// redeemCoupon applies the coupon named by o.CouponCode to o as part of tx.
+// The coupon stays locked until tx ends, so that concurrent orders cannot
+// take it over its limits.
+func redeemCoupon(ctx context.Context, tx *sql.Tx, o *Order, now time.Time) error {
+	var c Coupon
+	row := tx.QueryRowContext(ctx, `SELECT `+couponColumns+` FROM coupons WHERE code = $1 FOR UPDATE`, normalizeCouponCode(o.CouponCode))
+	if err := scanCoupon(row, &c); errors.Is(err, sql.ErrNoRows) {
+		return &CouponError{Code: o.CouponCode, Message: "does not exist"}
+	} else if err != nil {
+		return err
+	}
+
+	var redeemed, redeemedByUser int
+	err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE user_id = $2)
+		FROM orders
+		WHERE coupon_id = $1 AND status <> $3
+	`, c.ID, o.UserID, OrderStatusCanceled).Scan(&redeemed, &redeemedByUser)
+	if err != nil {
+		return err
+	}
+
+	return applyCoupon(&c, o, now, redeemed, redeemedByUser)
+}
+
+// PostgresCouponStore implements CouponStore on top of Postgres
+type PostgresCouponStore struct {
+	db *database.DB
+}
+
+// NewPostgresCouponStore creates a new PostgresCouponStore
+func NewPostgresCouponStore(db *database.DB) *PostgresCouponStore {
+	return &PostgresCouponStore{db: db}
+}
+
+// couponColumns are the columns scanCoupon reads, in order
+const couponColumns = `id, code, kind, value, product_ids, min_subtotal, starts_at, ends_at, max_redemptions, max_redemptions_per_user, created_at, updated_at`
+
+// scanCoupon reads a row of couponColumns into c
+func scanCoupon(row interface{ Scan(...interface{}) error }, c *Coupon) error {
+	var productIDs pq.Int64Array
+	err := row.Scan(&c.ID, &c.Code, &c.Kind, &c.Value, &productIDs, &c.MinSubtotal, &c.StartsAt, &c.EndsAt, &c.MaxRedemptions, &c.MaxRedemptionsPerUser, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	c.ProductIDs = make([]int, len(productIDs))
+	for i, id := range productIDs {
+		c.ProductIDs[i] = int(id)
+	}
+	return nil
+}
+
+// couponProductIDs converts a coupon's product IDs for storage
+func couponProductIDs(c *Coupon) pq.Int64Array {
+	ids := make(pq.Int64Array, len(c.ProductIDs))
+	for i, id := range c.ProductIDs {
+		ids[i] = int64(id)
+	}
+	return ids
+}
+
+// GetCoupons returns every coupon, oldest first
+func (s *PostgresCouponStore) GetCoupons(ctx context.Context) ([]Coupon, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+couponColumns+` FROM coupons ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	coupons := []Coupon{}
+	for rows.Next() {
+		var c Coupon
+		if err := scanCoupon(rows, &c); err != nil {
+			return nil, err
+		}
+		coupons = append(coupons, c)
+	}
+
+	return coupons, rows.Err()
+}
+
+// GetCouponByID returns a coupon by ID
+func (s *PostgresCouponStore) GetCouponByID(ctx context.Context, id int) (*Coupon, error) {
+	var c Coupon
+	if err := scanCoupon(s.db.QueryRowContext(ctx, `SELECT `+couponColumns+` FROM coupons WHERE id = $1`, id), &c); err != nil {
+		return nil, notFound(err)
+	}
+	return &c, nil
+}
+
+// CreateCoupon creates a new coupon, normalizing its code
+func (s *PostgresCouponStore) CreateCoupon(ctx context.Context, c *Coupon) error {
+	if !c.validValue() {
+		return ErrInvalidCouponValue
+	}
+	now := time.Now()
+	c.Code = normalizeCouponCode(c.Code)
+	c.CreatedAt = now
+	c.UpdatedAt = now
+
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO coupons (code, kind, value, product_ids, min_subtotal, starts_at, ends_at, max_redemptions, max_redemptions_per_user, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10)
+		RETURNING id
+	`, c.Code, c.Kind, c.Value, couponProductIDs(c), c.MinSubtotal, c.StartsAt, c.EndsAt, c.MaxRedemptions, c.MaxRedemptionsPerUser, now).Scan(&c.ID)
+}
+
+// UpdateCoupon updates a coupon. Orders already placed with it keep their
+// discounts.
+func (s *PostgresCouponStore) UpdateCoupon(ctx context.Context, c *Coupon) error {
+	if !c.validValue() {
+		return ErrInvalidCouponValue
+	}
+	c.Code = normalizeCouponCode(c.Code)
+	c.UpdatedAt = time.Now()
+
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE coupons
+		SET code = $2, kind = $3, value = $4, product_ids = $5, min_subtotal = $6, starts_at = $7, ends_at = $8,
+			max_redemptions = $9, max_redemptions_per_user = $10, updated_at = $11
+		WHERE id = $1
+		RETURNING created_at
+	`, c.ID, c.Code, c.Kind, c.Value, couponProductIDs(c), c.MinSubtotal, c.StartsAt, c.EndsAt, c.MaxRedemptions, c.MaxRedemptionsPerUser, c.UpdatedAt).Scan(&c.CreatedAt)
+	return notFound(err)
+}
+
+// DeleteCoupon deletes a coupon that no order was placed with. Redeemed
+// coupons cannot be deleted, but can be ended by setting EndsAt.
+func (s *PostgresCouponStore) DeleteCoupon(ctx context.Context, id int) error {
+	err := requireRows(s.db.ExecContext(ctx, `
+		DELETE FROM coupons WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM orders WHERE coupon_id = $1)
+	`, id))
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM coupons WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrCouponRedeemed
+	}
+	return ErrNotFound
+}
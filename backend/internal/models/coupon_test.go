@@ -0,0 +1,190 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyCoupon(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	hourAgo, inHour := now.Add(-time.Hour), now.Add(time.Hour)
+
+	// order returns an order with an item per price, of product IDs 1, 2, ...
+	order := func(prices ...int) *Order {
+		o := &Order{UserID: 1}
+		for i, price := range prices {
+			o.Items = append(o.Items, OrderItem{ProductID: i + 1, Quantity: 1, Price: price})
+		}
+		return o
+	}
+
+	tests := []struct {
+		name           string
+		coupon         Coupon
+		order          *Order
+		redeemed       int
+		redeemedByUser int
+
+		wantErr       string // CouponError message, if applying fails
+		wantDiscounts []int  // Discount of each item
+	}{
+		{
+			name:          "percent",
+			coupon:        Coupon{Kind: CouponPercent, Value: 10},
+			order:         order(1000, 500),
+			wantDiscounts: []int{100, 50},
+		},
+		{
+			name:          "percent remainder",
+			coupon:        Coupon{Kind: CouponPercent, Value: 10},
+			order:         order(5, 5, 5),
+			wantDiscounts: []int{1, 0, 0},
+		},
+		{
+			name:          "percent of everything",
+			coupon:        Coupon{Kind: CouponPercent, Value: 100},
+			order:         order(999, 1),
+			wantDiscounts: []int{999, 1},
+		},
+		{
+			name:          "fixed spread in proportion",
+			coupon:        Coupon{Kind: CouponFixed, Value: 300},
+			order:         order(2000, 1000),
+			wantDiscounts: []int{200, 100},
+		},
+		{
+			name:          "fixed rounding",
+			coupon:        Coupon{Kind: CouponFixed, Value: 100},
+			order:         order(300, 300, 300),
+			wantDiscounts: []int{34, 33, 33},
+		},
+		{
+			name:          "fixed above eligible total",
+			coupon:        Coupon{Kind: CouponFixed, Value: 5000},
+			order:         order(1000, 500),
+			wantDiscounts: []int{1000, 500},
+		},
+		{
+			name:          "scoped to products",
+			coupon:        Coupon{Kind: CouponFixed, Value: 300, ProductIDs: []int{2, 3}},
+			order:         order(1000, 1000, 2000),
+			wantDiscounts: []int{0, 100, 200},
+		},
+		{
+			name:    "scoped to products not ordered",
+			coupon:  Coupon{Kind: CouponPercent, Value: 10, ProductIDs: []int{9}},
+			order:   order(1000),
+			wantErr: "does not apply to any item in the order",
+		},
+		{
+			name:          "min subtotal met",
+			coupon:        Coupon{Kind: CouponFixed, Value: 100, MinSubtotal: 1500},
+			order:         order(1000, 500),
+			wantDiscounts: []int{67, 33},
+		},
+		{
+			name:    "min subtotal counts every item",
+			coupon:  Coupon{Kind: CouponFixed, Value: 100, MinSubtotal: 1501, ProductIDs: []int{1}},
+			order:   order(1000, 500),
+			wantErr: "requires a subtotal of at least 1501",
+		},
+		{
+			name:    "not active yet",
+			coupon:  Coupon{Kind: CouponPercent, Value: 10, StartsAt: &inHour},
+			order:   order(1000),
+			wantErr: "is not active yet",
+		},
+		{
+			name:    "expired",
+			coupon:  Coupon{Kind: CouponPercent, Value: 10, StartsAt: &hourAgo, EndsAt: &now},
+			order:   order(1000),
+			wantErr: "has expired",
+		},
+		{
+			name:           "under redemption limits",
+			coupon:         Coupon{Kind: CouponPercent, Value: 10, MaxRedemptions: intPtr(5), MaxRedemptionsPerUser: intPtr(2)},
+			order:          order(1000),
+			redeemed:       4,
+			redeemedByUser: 1,
+			wantDiscounts:  []int{100},
+		},
+		{
+			name:     "total redemption limit",
+			coupon:   Coupon{Kind: CouponPercent, Value: 10, MaxRedemptions: intPtr(5)},
+			order:    order(1000),
+			redeemed: 5,
+			wantErr:  "has been fully redeemed",
+		},
+		{
+			name:           "per-user redemption limit",
+			coupon:         Coupon{Kind: CouponPercent, Value: 10, MaxRedemptionsPerUser: intPtr(2)},
+			order:          order(1000),
+			redeemed:       2,
+			redeemedByUser: 2,
+			wantErr:        "has already been redeemed the maximum number of times",
+		},
+		{
+			name:    "percent above 100",
+			coupon:  Coupon{Kind: CouponPercent, Value: 101},
+			order:   order(1000),
+			wantErr: "has an invalid value",
+		},
+		{
+			name:    "percent of zero",
+			coupon:  Coupon{Kind: CouponPercent, Value: 0},
+			order:   order(1000),
+			wantErr: "has an invalid value",
+		},
+		{
+			name:    "fixed of zero",
+			coupon:  Coupon{Kind: CouponFixed, Value: 0},
+			order:   order(1000),
+			wantErr: "has an invalid value",
+		},
+		{
+			name:    "negative fixed",
+			coupon:  Coupon{Kind: CouponFixed, Value: -100},
+			order:   order(1000),
+			wantErr: "has an invalid value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.coupon
+			c.ID, c.Code = 7, "SAVE"
+			o := tt.order
+
+			err := applyCoupon(&c, o, now, tt.redeemed, tt.redeemedByUser)
+			if tt.wantErr != "" {
+				var couponErr *CouponError
+				if !errors.As(err, &couponErr) || couponErr.Message != tt.wantErr {
+					t.Fatalf("applyCoupon = %v, want CouponError %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyCoupon: %v", err)
+			}
+
+			var discounts []int
+			subtotal, discount := 0, 0
+			for _, item := range o.Items {
+				discounts = append(discounts, item.Discount)
+				subtotal += item.Price * item.Quantity
+				discount += item.Discount
+			}
+			if !reflect.DeepEqual(discounts, tt.wantDiscounts) {
+				t.Errorf("item discounts = %v, want %v", discounts, tt.wantDiscounts)
+			}
+			if o.Discount != discount || o.Total != subtotal-discount {
+				t.Errorf("order discount = %d, total = %d; want %d, %d", o.Discount, o.Total, discount, subtotal-discount)
+			}
+			if o.CouponID == nil || *o.CouponID != c.ID || o.CouponCode != c.Code {
+				t.Errorf("order coupon = %v %q, want %d %q", o.CouponID, o.CouponCode, c.ID, c.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrNotFound is returned when a record being read, updated or deleted does
+// not exist
+var ErrNotFound = errors.New("not found")
+
+// notFound translates sql.ErrNoRows into ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// requireRows returns ErrNotFound if a statement affected no rows
+func requireRows(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
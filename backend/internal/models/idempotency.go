@@ -0,0 +1,135 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/your-username/your-repo/internal/database"
+)
+
+var (
+	// ErrIdempotencyKeyInUse is returned when a request repeats the
+	// Idempotency-Key of a request that is still being handled
+	ErrIdempotencyKeyInUse = errors.New("idempotency key is in use by a request in flight")
+	// ErrIdempotencyKeyReused is returned when an Idempotency-Key is sent
+	// again with a different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key was used for a different request")
+)
+
+// IdempotentRequest is a request made with an Idempotency-Key
+type IdempotentRequest struct {
+	// Scope is who made the request. Keys are unique per scope, so that
+	// callers cannot replay each other's responses.
+	Scope       string
+	Key         string
+	Fingerprint string
+	ExpiresAt   time.Time
+}
+
+// IdempotentResponse is the response stored for an Idempotency-Key
+type IdempotentResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// PostgresIdempotencyStore implements IdempotencyStore on top of Postgres
+type PostgresIdempotencyStore struct {
+	db *database.DB
+}
+
+// NewPostgresIdempotencyStore creates a new PostgresIdempotencyStore
+func NewPostgresIdempotencyStore(db *database.DB) *PostgresIdempotencyStore {
+	return &PostgresIdempotencyStore{db: db}
+}
+
+// BeginIdempotentRequest claims req's key for req until it is completed or
+// released. If the key was already used, the response stored for it is
+// returned instead, or ErrIdempotencyKeyInUse if that request is still in
+// flight, or ErrIdempotencyKeyReused if it was a different request. Expired
+// keys are claimed as if they had never been used.
+func (s *PostgresIdempotencyStore) BeginIdempotentRequest(ctx context.Context, req IdempotentRequest) (*IdempotentResponse, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND expires_at <= $3
+	`, req.Scope, req.Key, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Concurrent requests with the same key block on the primary key here
+	// until the first one commits, then see the conflict
+	var key string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO idempotency_keys (scope, key, fingerprint, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (scope, key) DO NOTHING
+		RETURNING key
+	`, req.Scope, req.Key, req.Fingerprint, now, req.ExpiresAt).Scan(&key)
+	if err == nil {
+		return nil, tx.Commit()
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	var fingerprint string
+	var status sql.NullInt64
+	var resp IdempotentResponse
+	err = tx.QueryRowContext(ctx, `
+		SELECT fingerprint, status, content_type, body
+		FROM idempotency_keys
+		WHERE scope = $1 AND key = $2
+	`, req.Scope, req.Key).Scan(&fingerprint, &status, &resp.ContentType, &resp.Body)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// Released by the request that held it since the insert
+		return nil, ErrIdempotencyKeyInUse
+	case err != nil:
+		return nil, err
+	case fingerprint != req.Fingerprint:
+		return nil, ErrIdempotencyKeyReused
+	case !status.Valid:
+		return nil, ErrIdempotencyKeyInUse
+	}
+
+	resp.Status = int(status.Int64)
+	return &resp, nil
+}
+
+// CompleteIdempotentRequest stores the response to the request holding a key
+func (s *PostgresIdempotencyStore) CompleteIdempotentRequest(ctx context.Context, scope, key string, resp IdempotentResponse) error {
+	return requireRows(s.db.ExecContext(ctx, `
+		UPDATE idempotency_keys
+		SET status = $3, content_type = $4, body = $5
+		WHERE scope = $1 AND key = $2 AND status IS NULL
+	`, scope, key, resp.Status, resp.ContentType, resp.Body))
+}
+
+// ReleaseIdempotentRequest gives up a key claimed by a request that did not
+// complete, so that it can be retried
+func (s *PostgresIdempotencyStore) ReleaseIdempotentRequest(ctx context.Context, scope, key string) error {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND status IS NULL
+	`, scope, key)
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys deletes the keys that expired before now and
+// returns how many it deleted
+func (s *PostgresIdempotencyStore) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,365 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultReservationTTL is how long a new order holds its stock when it is
+// created without a ReservedUntil
+const DefaultReservationTTL = time.Hour
+
+// expireBatchSize caps how many expired orders ExpireReservations cancels
+// per transaction
+const expireBatchSize = 100
+
+var (
+	// ErrStockNotTracked is returned when adjusting the stock of a product
+	// whose stock is not tracked
+	ErrStockNotTracked = errors.New("product stock is not tracked")
+	// ErrStockTracked is returned when starting to track the stock of a
+	// product whose stock is already tracked
+	ErrStockTracked = errors.New("product stock is already tracked")
+	// ErrStockNegative is returned when an adjustment would take a product's
+	// stock below zero
+	ErrStockNegative = errors.New("stock adjustment would make stock negative")
+)
+
+// PaidAfterCancelError is returned when payment arrives for a canceled order
+// whose stock can no longer be reserved, so the order cannot be reinstated
+// and the payment must be refunded
+type PaidAfterCancelError struct {
+	OrderID int
+	Total   int // Amount paid in cents
+	Err     error
+}
+
+func (e *PaidAfterCancelError) Error() string {
+	return fmt.Sprintf("order %d was paid after it was canceled: %v", e.OrderID, e.Err)
+}
+
+func (e *PaidAfterCancelError) Unwrap() error {
+	return e.Err
+}
+
+// StockShortage is an item of an order that there is not enough stock for
+type StockShortage struct {
+	ProductID int `json:"product_id"`
+	Requested int `json:"requested"`
+	Available int `json:"available"`
+}
+
+// OutOfStockError is returned by CreateOrder when there is not enough stock
+// for one or more of the order's products
+type OutOfStockError struct {
+	Items []StockShortage
+}
+
+func (e *OutOfStockError) Error() string {
+	ids := make([]string, len(e.Items))
+	for i, item := range e.Items {
+		ids[i] = fmt.Sprint(item.ProductID)
+	}
+	return "out of stock: products " + strings.Join(ids, ", ")
+}
+
+// quantitiesByProduct totals the quantity of each product in items
+func quantitiesByProduct(items []OrderItem) map[int]int {
+	quantities := make(map[int]int, len(items))
+	for _, item := range items {
+		quantities[item.ProductID] += item.Quantity
+	}
+	return quantities
+}
+
+// stockShortages returns the products in items that stock cannot cover, in
+// product ID order. Products with nil stock are not tracked and never short.
+func stockShortages(items []OrderItem, stock map[int]*int) []StockShortage {
+	var shortages []StockShortage
+	for id, quantity := range quantitiesByProduct(items) {
+		if available := stock[id]; available != nil && *available < quantity {
+			shortages = append(shortages, StockShortage{ProductID: id, Requested: quantity, Available: *available})
+		}
+	}
+	sort.Slice(shortages, func(i, j int) bool { return shortages[i].ProductID < shortages[j].ProductID })
+	return shortages
+}
+
+// reserveStock takes the stock of o's items, whose products tx must already
+// have locked, and reports any shortage as an OutOfStockError
+func reserveStock(ctx context.Context, tx *sql.Tx, o *Order, products map[int]Product) error {
+	stock := make(map[int]*int, len(products))
+	for id, p := range products {
+		stock[id] = p.Stock
+	}
+	if shortages := stockShortages(o.Items, stock); len(shortages) > 0 {
+		return &OutOfStockError{Items: shortages}
+	}
+
+	for id, quantity := range quantitiesByProduct(o.Items) {
+		if stock[id] == nil {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE products SET stock = stock - $1 WHERE id = $2`, quantity, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AdjustProductStock adds delta, which may be negative, to the stock of a
+// product and returns the new stock. It is relative so that it cannot undo
+// reservations made or released since the caller read the product.
+func (s *PostgresProductStore) AdjustProductStock(ctx context.Context, id, delta int) (int, error) {
+	var stock int
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE products
+		SET stock = stock + $2, updated_at = $3
+		WHERE id = $1 AND stock IS NOT NULL AND stock + $2 >= 0
+		RETURNING stock
+	`, id, delta, time.Now()).Scan(&stock)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return stock, err
+	}
+
+	// Nothing was updated: find out why
+	current, err := s.productStock(ctx, id)
+	switch {
+	case err != nil:
+		return 0, err
+	case current == nil:
+		return 0, ErrStockNotTracked
+	}
+	return 0, ErrStockNegative
+}
+
+// TrackProductStock starts tracking the stock of a product whose stock is
+// not tracked yet
+func (s *PostgresProductStore) TrackProductStock(ctx context.Context, id, stock int) error {
+	err := requireRows(s.db.ExecContext(ctx, `
+		UPDATE products SET stock = $2, updated_at = $3 WHERE id = $1 AND stock IS NULL
+	`, id, stock, time.Now()))
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	if _, err := s.productStock(ctx, id); err != nil {
+		return err
+	}
+	return ErrStockTracked
+}
+
+// productStock returns the stock of a product, nil if it is not tracked
+func (s *PostgresProductStore) productStock(ctx context.Context, id int) (*int, error) {
+	var stock *int
+	if err := s.db.QueryRowContext(ctx, `SELECT stock FROM products WHERE id = $1`, id).Scan(&stock); err != nil {
+		return nil, notFound(err)
+	}
+	return stock, nil
+}
+
+// reinstateOrder takes the stock of a canceled order's items again, as part
+// of tx, so that it can be paid. It fails with an OutOfStockError or
+// ProductNotFoundError if the stock has gone since the order was canceled.
+func reinstateOrder(ctx context.Context, tx *sql.Tx, orderID int) error {
+	rows, err := tx.QueryContext(ctx, `SELECT product_id, quantity FROM order_items WHERE order_id = $1`, orderID)
+	if err != nil {
+		return err
+	}
+	o := Order{ID: orderID}
+	for rows.Next() {
+		var item OrderItem
+		if err := rows.Scan(&item.ProductID, &item.Quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		o.Items = append(o.Items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	products, err := lockProducts(ctx, tx, o.Items)
+	if err != nil {
+		return err
+	}
+	return reserveStock(ctx, tx, &o, products)
+}
+
+// setReservationBySessionID changes when the stock reservation of the unpaid
+// order with the given Stripe Checkout Session ID expires, within tx. Orders
+// that no longer hold a reservation are left alone. It returns ErrNotFound
+// if there is no such order.
+func setReservationBySessionID(ctx context.Context, tx *sql.Tx, sessionID string, until time.Time) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE orders
+		SET reserved_until = $2, updated_at = $3
+		WHERE stripe_session_id = $1 AND reserved_until IS NOT NULL AND status = ANY($4)
+	`, sessionID, until, time.Now(), pq.Array([]string{string(OrderStatusPending), string(OrderStatusPaymentFailed)}))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE stripe_session_id = $1)`, sessionID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// settleReservation applies the effect of an order moving to status on its
+// stock reservation: canceling releases the stock and paying keeps it
+func settleReservation(ctx context.Context, tx *sql.Tx, orderID int, to OrderStatus) error {
+	switch to {
+	case OrderStatusCanceled:
+		return releaseReservation(ctx, tx, orderID)
+	case OrderStatusPaid:
+		_, err := tx.ExecContext(ctx, `UPDATE orders SET reserved_until = NULL WHERE id = $1`, orderID)
+		return err
+	}
+	return nil
+}
+
+// releaseReservation returns the stock reserved by an order, if it still
+// holds a reservation, and clears the reservation
+func releaseReservation(ctx context.Context, tx *sql.Tx, orderID int) error {
+	res, err := tx.ExecContext(ctx, `
+		UPDATE orders
+		SET reserved_until = NULL
+		WHERE id = $1 AND reserved_until IS NOT NULL
+	`, orderID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return err
+	}
+
+	// Restock in product ID order, the order CreateOrder locks products in,
+	// so the two cannot deadlock
+	rows, err := tx.QueryContext(ctx, `
+		SELECT product_id, SUM(quantity)
+		FROM order_items
+		WHERE order_id = $1
+		GROUP BY product_id
+		ORDER BY product_id
+	`, orderID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	quantities := make(map[int]int)
+	var ids []int
+	for rows.Next() {
+		var id, quantity int
+		if err := rows.Scan(&id, &quantity); err != nil {
+			return err
+		}
+		quantities[id] = quantity
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE products
+			SET stock = stock + $1
+			WHERE id = $2 AND stock IS NOT NULL
+		`, quantities[id], id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpireReservations cancels the unpaid orders whose stock reservation
+// expired before now, releasing their stock, and returns how many it
+// canceled. Orders locked by another transaction are left for a later run.
+func (s *PostgresOrderStore) ExpireReservations(ctx context.Context, now time.Time) (int, error) {
+	total := 0
+	for {
+		n, err := s.expireReservationBatch(ctx, now)
+		total += n
+		if err != nil || n < expireBatchSize {
+			return total, err
+		}
+	}
+}
+
+// expireReservationBatch cancels up to expireBatchSize expired orders in one
+// transaction
+func (s *PostgresOrderStore) expireReservationBatch(ctx context.Context, now time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, status
+		FROM orders
+		WHERE reserved_until < $1 AND status = ANY($2)
+		ORDER BY reserved_until
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	`, now, pq.Array([]string{string(OrderStatusPending), string(OrderStatusPaymentFailed)}), expireBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	expired := make(map[int]OrderStatus)
+	var ids []int
+	for rows.Next() {
+		var id int
+		var status OrderStatus
+		if err := rows.Scan(&id, &status); err != nil {
+			return 0, err
+		}
+		expired[id] = status
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE orders
+			SET status = $1, updated_at = $2
+			WHERE id = $3
+		`, OrderStatusCanceled, time.Now(), id)
+		if err != nil {
+			return 0, err
+		}
+		if err := settleReservation(ctx, tx, id, OrderStatusCanceled); err != nil {
+			return 0, err
+		}
+		if err := recordStatusChange(ctx, tx, id, expired[id], OrderStatusCanceled, "system:reservation-expired"); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(ids), tx.Commit()
+}
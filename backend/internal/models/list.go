@@ -0,0 +1,262 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page sizes used when a list request does not set one, and the most a
+// single page may hold
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+// ErrInvalidCursor is returned when a page cursor is malformed or was issued
+// for a different sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// InvalidSortError is returned when a list is sorted by a field that is not
+// allow-listed
+type InvalidSortError struct {
+	Sort    string
+	Allowed []string
+}
+
+func (e *InvalidSortError) Error() string {
+	return fmt.Sprintf("cannot sort by %q", e.Sort)
+}
+
+// Page selects one page of a list. Sort names an allow-listed field, prefixed
+// with "-" for descending order, and Cursor is the next-page cursor returned
+// with the previous page.
+type Page struct {
+	Limit  int
+	Sort   string
+	Cursor string
+}
+
+// limit returns the page size, clamped to MaxPageLimit
+func (p Page) limit() int {
+	switch {
+	case p.Limit <= 0:
+		return DefaultPageLimit
+	case p.Limit > MaxPageLimit:
+		return MaxPageLimit
+	}
+	return p.Limit
+}
+
+// sortField is a field a list can be sorted by. IDs break ties, so every
+// sort order is total and cursors are stable.
+type sortField[T any] struct {
+	column  string              // SQL column
+	sqlType string              // SQL type cursor values are cast to: text, integer or timestamp
+	key     func(T) interface{} // the row's value: a string, int or time.Time
+}
+
+// sortOrder is a resolved Page.Sort
+type sortOrder[T any] struct {
+	name  string
+	field sortField[T]
+	desc  bool
+}
+
+// resolveSort looks up name, or def if it is empty, in the allow-listed fields
+func resolveSort[T any](name, def string, fields map[string]sortField[T]) (sortOrder[T], error) {
+	if name == "" {
+		name = def
+	}
+
+	field, ok := fields[strings.TrimPrefix(name, "-")]
+	if !ok {
+		allowed := make([]string, 0, len(fields))
+		for name := range fields {
+			allowed = append(allowed, name)
+		}
+		sort.Strings(allowed)
+		return sortOrder[T]{}, &InvalidSortError{Sort: name, Allowed: allowed}
+	}
+
+	return sortOrder[T]{name: name, field: field, desc: strings.HasPrefix(name, "-")}, nil
+}
+
+// cursor is the decoded form of a page cursor: the sort order it was issued
+// for, and the sort value and ID of the last row of the page
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"i"`
+}
+
+// encodeCursor returns the opaque form of c
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses an opaque cursor, checking it belongs to order
+func decodeCursor[T any](s string, order sortOrder[T]) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil || c.Sort != order.name {
+		return c, ErrInvalidCursor
+	}
+	if _, err := parseKey(order.field.sqlType, c.Value); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// listQuery accumulates the WHERE conditions and arguments of a list query
+type listQuery struct {
+	conds []string
+	args  []interface{}
+}
+
+// arg adds an argument and returns its placeholder
+func (q *listQuery) arg(v interface{}) string {
+	q.args = append(q.args, v)
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+// where adds a condition
+func (q *listQuery) where(cond string) {
+	q.conds = append(q.conds, cond)
+}
+
+// whereClause returns the WHERE clause, or nothing if there are no conditions
+func (q *listQuery) whereClause() string {
+	if len(q.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.conds, " AND ")
+}
+
+// paginate adds the cursor condition of page to q and returns the ORDER BY
+// and LIMIT clauses. One row more than the page size is fetched so that
+// trimPage can tell whether there is a next page.
+func paginate[T any](q *listQuery, page Page, order sortOrder[T]) (string, error) {
+	dir, op := "ASC", ">"
+	if order.desc {
+		dir, op = "DESC", "<"
+	}
+
+	if page.Cursor != "" {
+		c, err := decodeCursor(page.Cursor, order)
+		if err != nil {
+			return "", err
+		}
+		q.where(fmt.Sprintf("(%s, id) %s (%s::%s, %s)",
+			order.field.column, op, q.arg(c.Value), order.field.sqlType, q.arg(c.ID)))
+	}
+
+	return fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %d", order.field.column, dir, dir, page.limit()+1), nil
+}
+
+// trimPage drops the extra row fetched by paginate and returns the cursor of
+// the next page, or "" if this is the last one
+func trimPage[T any](rows []T, page Page, order sortOrder[T], id func(T) int) ([]T, string) {
+	limit := page.limit()
+	if len(rows) <= limit {
+		return rows, ""
+	}
+
+	rows = rows[:limit]
+	last := rows[limit-1]
+	return rows, encodeCursor(cursor{Sort: order.name, Value: formatKey(order.field.key(last)), ID: id(last)})
+}
+
+// pageOf pages through rows in memory the way paginate and trimPage do in SQL
+func pageOf[T any](rows []T, page Page, order sortOrder[T], id func(T) int) ([]T, string, error) {
+	// compare orders a row against a sort value and ID in the page's direction
+	compare := func(row T, key interface{}, rowID int) int {
+		c := compareKeys(order.field.key(row), key)
+		if c == 0 {
+			c = compareKeys(id(row), rowID)
+		}
+		if order.desc {
+			c = -c
+		}
+		return c
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return compare(rows[i], order.field.key(rows[j]), id(rows[j])) < 0
+	})
+
+	if page.Cursor != "" {
+		c, err := decodeCursor(page.Cursor, order)
+		if err != nil {
+			return nil, "", err
+		}
+		key, _ := parseKey(order.field.sqlType, c.Value)
+
+		i := sort.Search(len(rows), func(i int) bool { return compare(rows[i], key, c.ID) > 0 })
+		rows = rows[i:]
+	}
+
+	if len(rows) > page.limit()+1 {
+		rows = rows[:page.limit()+1]
+	}
+	rows, next := trimPage(rows, page, order, id)
+	return rows, next, nil
+}
+
+// cursorTimeLayout formats timestamps at the microsecond precision Postgres stores
+const cursorTimeLayout = "2006-01-02T15:04:05.999999"
+
+// formatKey formats a sort value for a cursor
+func formatKey(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case time.Time:
+		return v.UTC().Format(cursorTimeLayout)
+	}
+	panic(fmt.Sprintf("models: unsupported sort key %T", v))
+}
+
+// parseKey parses a cursor's sort value as sqlType
+func parseKey(sqlType, s string) (interface{}, error) {
+	switch sqlType {
+	case "text":
+		return s, nil
+	case "integer":
+		return strconv.Atoi(s)
+	case "timestamp":
+		return time.Parse(cursorTimeLayout, s)
+	}
+	panic(fmt.Sprintf("models: unsupported sort type %q", sqlType))
+}
+
+// compareKeys compares two sort values of the same type
+func compareKeys(a, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case int:
+		b := b.(int)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case time.Time:
+		return a.Truncate(time.Microsecond).Compare(b.(time.Time).Truncate(time.Microsecond))
+	}
+	panic(fmt.Sprintf("models: unsupported sort key %T", a))
+}
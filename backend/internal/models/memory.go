@@ -0,0 +1,1499 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrDuplicateClerkID is returned by MemoryStore when a user's Clerk ID is
+// already taken, mirroring the unique constraint on users.clerk_id
+var ErrDuplicateClerkID = errors.New("duplicate clerk_id")
+
+// ErrDuplicateCouponCode is returned by MemoryStore when a coupon's code is
+// already taken, mirroring the unique constraint on coupons.code
+var ErrDuplicateCouponCode = errors.New("duplicate coupon code")
+
+// MemoryStore is an in-memory UserStore, ProductStore, OrderStore,
+// RefundStore, IdempotencyStore, CartStore, CouponStore and StripeEventStore
+// with the same
+// semantics as the Postgres stores, for use in tests. The store contract
+// tests run against both to keep them in step.
+type MemoryStore struct {
+	mu          sync.Mutex
+	users       map[int]User
+	products    map[int]Product
+	orders      map[int]Order
+	history     []OrderStatusChange
+	refunds     []Refund
+	roles       []RoleChange
+	synced      map[string]time.Time
+	idempotency map[idempotencyKey]idempotencyRecord
+	carts       map[int]memoryCart
+	coupons     map[int]Coupon
+	events      map[string]string // Stripe event types by ID
+	nextID      map[string]int
+}
+
+var (
+	_ UserStore        = (*MemoryStore)(nil)
+	_ ProductStore     = (*MemoryStore)(nil)
+	_ OrderStore       = (*MemoryStore)(nil)
+	_ RefundStore      = (*MemoryStore)(nil)
+	_ IdempotencyStore = (*MemoryStore)(nil)
+	_ CartStore        = (*MemoryStore)(nil)
+	_ CouponStore      = (*MemoryStore)(nil)
+	_ StripeEventStore = (*MemoryStore)(nil)
+)
+
+// idempotencyKey identifies an Idempotency-Key in MemoryStore
+type idempotencyKey struct {
+	scope, key string
+}
+
+// idempotencyRecord is a claimed Idempotency-Key, with no response while the
+// request holding it is in flight
+type idempotencyRecord struct {
+	fingerprint string
+	response    *IdempotentResponse
+	expiresAt   time.Time
+}
+
+// NewMemoryStore creates a new, empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:       make(map[int]User),
+		products:    make(map[int]Product),
+		orders:      make(map[int]Order),
+		synced:      make(map[string]time.Time),
+		idempotency: make(map[idempotencyKey]idempotencyRecord),
+		carts:       make(map[int]memoryCart),
+		coupons:     make(map[int]Coupon),
+		events:      make(map[string]string),
+		nextID:      make(map[string]int),
+	}
+}
+
+// id returns the next serial ID for a table
+func (m *MemoryStore) id(table string) int {
+	m.nextID[table]++
+	return m.nextID[table]
+}
+
+// GetUsers returns a page of the users matching filter, ordered by email by
+// default, and the cursor of the next page
+func (m *MemoryStore) GetUsers(ctx context.Context, filter UserFilter, page Page) ([]User, string, error) {
+	order, err := resolveSort(page.Sort, "email", userSorts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var users []User
+	for _, u := range m.users {
+		if filter.matches(u) {
+			users = append(users, u)
+		}
+	}
+
+	return pageOf(users, page, order, userID)
+}
+
+// GetUserByID returns a user by ID
+func (m *MemoryStore) GetUserByID(ctx context.Context, id int) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &u, nil
+}
+
+// GetUserByClerkID returns a user by Clerk user ID
+func (m *MemoryStore) GetUserByClerkID(ctx context.Context, clerkID string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.ClerkID == clerkID {
+			return &u, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// CreateUser creates a new user, as a customer unless it has a role
+func (m *MemoryStore) CreateUser(ctx context.Context, u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u.Role == "" {
+		u.Role = RoleCustomer
+	}
+
+	for _, existing := range m.users {
+		if existing.ClerkID == u.ClerkID {
+			return ErrDuplicateClerkID
+		}
+	}
+
+	now := time.Now()
+	u.ID = m.id("users")
+	u.CreatedAt = now
+	u.UpdatedAt = now
+	m.users[u.ID] = *u
+
+	return nil
+}
+
+// ProvisionUser creates u, as a customer, unless a user with its Clerk ID
+// already exists, and fills u in from the stored user either way
+func (m *MemoryStore) ProvisionUser(ctx context.Context, u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.userByClerkID(u.ClerkID); ok {
+		*u = existing
+		return nil
+	}
+
+	now := time.Now()
+	u.ID = m.id("users")
+	u.Role = RoleCustomer
+	u.CreatedAt = now
+	u.UpdatedAt = now
+	m.users[u.ID] = *u
+
+	return nil
+}
+
+// UpdateUser updates a user's email and name, filling in the rest of u from
+// the stored user
+func (m *MemoryStore) UpdateUser(ctx context.Context, u *User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u.UpdatedAt = time.Now()
+
+	existing, ok := m.users[u.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	existing.Email = u.Email
+	existing.Name = u.Name
+	existing.UpdatedAt = u.UpdatedAt
+	m.users[u.ID] = existing
+	u.ClerkID = existing.ClerkID
+	u.Role = existing.Role
+	u.CreatedAt = existing.CreatedAt
+
+	return nil
+}
+
+// DeleteUser deletes a user
+func (m *MemoryStore) DeleteUser(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.users, id)
+	if c, ok := m.cartByOwner(CartOwner{UserID: id}); ok {
+		delete(m.carts, c.ID)
+	}
+	return nil
+}
+
+// SetUserRole changes a user's role and records the change
+func (m *MemoryStore) SetUserRole(ctx context.Context, id int, from, to Role, changedBy string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if u.Role != from {
+		return ErrRoleConflict
+	}
+
+	m.setRole(u, to, changedBy)
+	return nil
+}
+
+// SeedAdmin makes the user with the given Clerk ID an admin if there are no
+// admins yet, and reports whether it did
+func (m *MemoryStore) SeedAdmin(ctx context.Context, clerkID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var target *User
+	for _, u := range m.users {
+		if u.Role == RoleAdmin {
+			return false, nil
+		}
+		if u.ClerkID == clerkID {
+			u := u
+			target = &u
+		}
+	}
+	if target == nil {
+		return false, nil
+	}
+
+	m.setRole(*target, RoleAdmin, "system:seed")
+	return true, nil
+}
+
+// setRole changes u's role and records the change; m.mu must be held
+func (m *MemoryStore) setRole(u User, to Role, changedBy string) {
+	now := time.Now()
+	m.roles = append(m.roles, RoleChange{
+		ID:        m.id("user_role_changes"),
+		UserID:    u.ID,
+		FromRole:  u.Role,
+		ToRole:    to,
+		ChangedBy: changedBy,
+		CreatedAt: now,
+	})
+
+	u.Role = to
+	u.UpdatedAt = now
+	m.users[u.ID] = u
+}
+
+// GetRoleChanges returns the role changes of a user, oldest first
+func (m *MemoryStore) GetRoleChanges(ctx context.Context, userID int) ([]RoleChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var changes []RoleChange
+	for _, c := range m.roles {
+		if c.UserID == userID {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+// UpsertClerkUser creates or updates the user with u's Clerk ID from a Clerk
+// webhook, ignoring stale events and events for deleted users
+func (m *MemoryStore) UpsertClerkUser(ctx context.Context, u *User, syncedAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if last, ok := m.synced[u.ClerkID]; ok && !last.Before(syncedAt) {
+		return false, nil
+	}
+
+	now := time.Now()
+	existing, found := m.userByClerkID(u.ClerkID)
+	if found && existing.DeletedAt != nil {
+		return false, nil
+	}
+	if found {
+		existing.Email = u.Email
+		existing.Name = u.Name
+		existing.UpdatedAt = now
+		*u = existing
+	} else {
+		u.ID = m.id("users")
+		u.Role = RoleCustomer
+		u.CreatedAt = now
+		u.UpdatedAt = now
+	}
+	m.users[u.ID] = *u
+	m.synced[u.ClerkID] = syncedAt
+
+	return true, nil
+}
+
+// DeleteClerkUser marks the user with the given Clerk ID deleted and scrubs
+// their email and name, creating a deleted user if there is none
+func (m *MemoryStore) DeleteClerkUser(ctx context.Context, clerkID string, deletedAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if last, ok := m.synced[clerkID]; ok && !last.Before(deletedAt) {
+		return false, nil
+	}
+
+	now := time.Now()
+	u, found := m.userByClerkID(clerkID)
+	if !found {
+		u = User{ID: m.id("users"), ClerkID: clerkID, Role: RoleCustomer, CreatedAt: now}
+	}
+	u.Email = ""
+	u.Name = ""
+	u.UpdatedAt = now
+	u.DeletedAt = &deletedAt
+	m.users[u.ID] = u
+	m.synced[clerkID] = deletedAt
+
+	return true, nil
+}
+
+// userByClerkID finds a user by Clerk ID; m.mu must be held
+func (m *MemoryStore) userByClerkID(clerkID string) (User, bool) {
+	for _, u := range m.users {
+		if u.ClerkID == clerkID {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+// GetProducts returns a page of the products matching filter, ordered by
+// name by default, and the cursor of the next page
+func (m *MemoryStore) GetProducts(ctx context.Context, filter ProductFilter, page Page) ([]Product, string, error) {
+	order, err := resolveSort(page.Sort, "name", productSorts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var products []Product
+	for _, p := range m.products {
+		if filter.matches(p) {
+			products = append(products, p)
+		}
+	}
+
+	return pageOf(products, page, order, productID)
+}
+
+// GetProductByID returns a product by ID
+func (m *MemoryStore) GetProductByID(ctx context.Context, id int) (*Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.products[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &p, nil
+}
+
+// GetProductsByIDs returns the products with the given IDs, keyed by ID
+func (m *MemoryStore) GetProductsByIDs(ctx context.Context, ids []int) (map[int]Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	products := make(map[int]Product, len(ids))
+	for _, id := range ids {
+		if p, ok := m.products[id]; ok {
+			products[id] = p
+		}
+	}
+	return products, nil
+}
+
+// CreateProduct creates a new product
+func (m *MemoryStore) CreateProduct(ctx context.Context, p *Product) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	p.ID = m.id("products")
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	m.products[p.ID] = *p
+
+	return nil
+}
+
+// UpdateProduct updates a product
+func (m *MemoryStore) UpdateProduct(ctx context.Context, p *Product) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p.UpdatedAt = time.Now()
+
+	existing, ok := m.products[p.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	p.CreatedAt = existing.CreatedAt
+	p.Stock = existing.Stock
+	m.products[p.ID] = *p
+
+	return nil
+}
+
+// AdjustProductStock adds delta to the stock of a product and returns the
+// new stock
+func (m *MemoryStore) AdjustProductStock(ctx context.Context, id, delta int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.products[id]
+	switch {
+	case !ok:
+		return 0, ErrNotFound
+	case p.Stock == nil:
+		return 0, ErrStockNotTracked
+	case *p.Stock+delta < 0:
+		return 0, ErrStockNegative
+	}
+	stock := *p.Stock + delta
+	p.Stock = &stock
+	p.UpdatedAt = time.Now()
+	m.products[id] = p
+
+	return stock, nil
+}
+
+// TrackProductStock starts tracking the stock of a product
+func (m *MemoryStore) TrackProductStock(ctx context.Context, id, stock int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.products[id]
+	switch {
+	case !ok:
+		return ErrNotFound
+	case p.Stock != nil:
+		return ErrStockTracked
+	}
+	p.Stock = &stock
+	p.UpdatedAt = time.Now()
+	m.products[id] = p
+
+	return nil
+}
+
+// SetProductStripeIDs records the Stripe Product and Price of a product
+func (m *MemoryStore) SetProductStripeIDs(ctx context.Context, id int, productID, priceID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.products[id]
+	if !ok {
+		return ErrNotFound
+	}
+	p.StripeProductID = productID
+	p.StripePriceID = priceID
+	p.UpdatedAt = time.Now()
+	m.products[id] = p
+
+	return nil
+}
+
+// DeleteProduct deletes a product
+func (m *MemoryStore) DeleteProduct(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.products[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.products, id)
+
+	// Deleted products disappear from carts
+	for cartID, c := range m.carts {
+		items := c.Items[:0:0]
+		for _, item := range c.Items {
+			if item.ProductID != id {
+				items = append(items, item)
+			}
+		}
+		c.Items = items
+		m.carts[cartID] = c
+	}
+	return nil
+}
+
+// GetOrders returns a page of the orders matching filter, newest first by
+// default, and the cursor of the next page
+func (m *MemoryStore) GetOrders(ctx context.Context, filter OrderFilter, page Page) ([]Order, string, error) {
+	order, err := resolveSort(page.Sort, "-created_at", orderSorts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var orders []Order
+	for _, o := range m.orders {
+		if filter.matches(o) {
+			orders = append(orders, copyOrder(o))
+		}
+	}
+
+	return pageOf(orders, page, order, orderID)
+}
+
+// GetOrderByID returns an order by ID
+func (m *MemoryStore) GetOrderByID(ctx context.Context, id int) (*Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.orders[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	o = copyOrder(o)
+	return &o, nil
+}
+
+// GetOrderByStripeSessionID returns an order by Stripe Checkout Session ID
+func (m *MemoryStore) GetOrderByStripeSessionID(ctx context.Context, sessionID string) (*Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.orderBySessionID(sessionID)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	o = copyOrder(o)
+	return &o, nil
+}
+
+// CreateOrder creates a new order, pricing its items from the stored
+// products and reserving their stock
+func (m *MemoryStore) CreateOrder(ctx context.Context, o *Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.createOrder(o)
+}
+
+// createOrder creates an order with m.mu held
+func (m *MemoryStore) createOrder(o *Order) error {
+	stock := make(map[int]*int, len(o.Items))
+	for _, item := range o.Items {
+		p, ok := m.products[item.ProductID]
+		if !ok {
+			return &ProductNotFoundError{ProductID: item.ProductID}
+		}
+		stock[p.ID] = p.Stock
+	}
+	if shortages := stockShortages(o.Items, stock); len(shortages) > 0 {
+		return &OutOfStockError{Items: shortages}
+	}
+
+	now := time.Now()
+	o.CreatedAt = now
+	o.UpdatedAt = now
+	if o.ReservedUntil == nil {
+		reservedUntil := now.Add(DefaultReservationTTL)
+		o.ReservedUntil = &reservedUntil
+	}
+
+	o.Total, o.Discount, o.CouponID = 0, 0, nil
+	for i := range o.Items {
+		item := &o.Items[i]
+		item.Price = m.products[item.ProductID].Price
+		item.Discount = 0
+		o.Total += item.Price * item.Quantity
+	}
+	if o.CouponCode != "" {
+		if err := m.redeemCoupon(o, now); err != nil {
+			return err
+		}
+	}
+	m.adjustStock(o.Items, -1)
+
+	o.ID = m.id("orders")
+	for i := range o.Items {
+		item := &o.Items[i]
+		item.ID = m.id("order_items")
+		item.OrderID = o.ID
+		item.CreatedAt = now
+		item.UpdatedAt = now
+	}
+
+	m.orders[o.ID] = copyOrder(*o)
+
+	return nil
+}
+
+// SetOrderStripeSessionID records the Stripe Checkout Session of an order
+func (m *MemoryStore) SetOrderStripeSessionID(ctx context.Context, id int, sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.orders[id]
+	if !ok {
+		return ErrNotFound
+	}
+	o.StripeSessionID = sessionID
+	o.UpdatedAt = time.Now()
+	m.orders[id] = o
+
+	return nil
+}
+
+// TransitionOrder moves an order from one status to another and records the change
+func (m *MemoryStore) TransitionOrder(ctx context.Context, id int, from, to OrderStatus, changedBy string) error {
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.orders[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if o.Status != from {
+		return ErrStatusConflict
+	}
+
+	m.transition(o, to, changedBy)
+	return nil
+}
+
+// transition moves o to status, settling its stock reservation, and records
+// the change
+func (m *MemoryStore) transition(o Order, to OrderStatus, changedBy string) {
+	now := time.Now()
+	from := o.Status
+	o.Status = to
+	o.UpdatedAt = now
+
+	switch to {
+	case OrderStatusCanceled:
+		m.releaseReservation(&o)
+	case OrderStatusPaid:
+		o.ReservedUntil = nil
+	}
+	m.orders[o.ID] = o
+
+	m.history = append(m.history, OrderStatusChange{
+		ID:         m.id("order_status_history"),
+		OrderID:    o.ID,
+		FromStatus: from,
+		ToStatus:   to,
+		ChangedBy:  changedBy,
+		CreatedAt:  now,
+	})
+}
+
+// releaseReservation returns the stock reserved by o, if it still holds a
+// reservation, and clears the reservation
+func (m *MemoryStore) releaseReservation(o *Order) {
+	if o.ReservedUntil == nil {
+		return
+	}
+	o.ReservedUntil = nil
+	m.adjustStock(o.Items, 1)
+}
+
+// adjustStock adds sign times the quantity of each item to its product's
+// stock, if the product's stock is tracked
+func (m *MemoryStore) adjustStock(items []OrderItem, sign int) {
+	for id, quantity := range quantitiesByProduct(items) {
+		p, ok := m.products[id]
+		if !ok || p.Stock == nil {
+			continue
+		}
+		// Replace rather than modify the stock, which callers may share
+		stock := *p.Stock + sign*quantity
+		p.Stock = &stock
+		m.products[id] = p
+	}
+}
+
+// ExpireReservations cancels the unpaid orders whose stock reservation
+// expired before now, releasing their stock, and returns how many it canceled
+func (m *MemoryStore) ExpireReservations(ctx context.Context, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, o := range m.orders {
+		expired := o.ReservedUntil != nil && o.ReservedUntil.Before(now)
+		if expired && (o.Status == OrderStatusPending || o.Status == OrderStatusPaymentFailed) {
+			m.transition(o, OrderStatusCanceled, "system:reservation-expired")
+			n++
+		}
+	}
+	return n, nil
+}
+
+// GetOrderStatusHistory returns the status changes of an order, oldest first
+func (m *MemoryStore) GetOrderStatusHistory(ctx context.Context, orderID int) ([]OrderStatusChange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var changes []OrderStatusChange
+	for _, c := range m.history {
+		if c.OrderID == orderID {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+// DeleteOrder deletes an order, its items and its status history, releasing
+// any stock it has reserved
+func (m *MemoryStore) DeleteOrder(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.orders[id]
+	if !ok {
+		return ErrNotFound
+	}
+	m.releaseReservation(&o)
+	delete(m.orders, id)
+
+	refunds := m.refunds[:0]
+	for _, r := range m.refunds {
+		if r.OrderID != id {
+			refunds = append(refunds, r)
+		}
+	}
+	m.refunds = refunds
+
+	history := m.history[:0]
+	for _, c := range m.history {
+		if c.OrderID != id {
+			history = append(history, c)
+		}
+	}
+	m.history = history
+
+	return nil
+}
+
+// ProcessStripeEvent runs fn and records the Stripe event ID, undoing fn's
+// changes if it fails. If the event has already been processed fn is not
+// called and false is returned.
+func (m *MemoryStore) ProcessStripeEvent(ctx context.Context, eventID, eventType string, fn func(tx StripeEventTx) error) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.events[eventID]; ok {
+		return false, nil
+	}
+
+	rollback := m.snapshotOrders()
+	if err := fn(memoryStripeEventTx{m}); err != nil {
+		rollback()
+		return false, err
+	}
+
+	m.events[eventID] = eventType
+	return true, nil
+}
+
+// snapshotOrders saves the orders, their history and product stock, and
+// returns a function that restores them, with m.mu held
+func (m *MemoryStore) snapshotOrders() func() {
+	orders := make(map[int]Order, len(m.orders))
+	for id, o := range m.orders {
+		orders[id] = o
+	}
+	products := make(map[int]Product, len(m.products))
+	for id, p := range m.products {
+		products[id] = p
+	}
+	nextID := make(map[string]int, len(m.nextID))
+	for table, id := range m.nextID {
+		nextID[table] = id
+	}
+	history := len(m.history)
+
+	return func() {
+		m.orders, m.products, m.nextID = orders, products, nextID
+		m.history = m.history[:history]
+	}
+}
+
+// memoryStripeEventTx is the StripeEventTx of MemoryStore.ProcessStripeEvent,
+// which holds m.mu while it is in use
+type memoryStripeEventTx struct {
+	m *MemoryStore
+}
+
+// TransitionOrderBySessionID moves the order with the given Stripe Checkout
+// Session ID to status, reinstating it if it is paid after being canceled
+func (t memoryStripeEventTx) TransitionOrderBySessionID(ctx context.Context, sessionID string, to OrderStatus, changedBy string) error {
+	o, ok := t.m.orderBySessionID(sessionID)
+	if !ok {
+		return ErrNotFound
+	}
+
+	switch from := o.Status; {
+	case from == OrderStatusCanceled && to == OrderStatusPaid:
+		stock := make(map[int]*int, len(o.Items))
+		for _, item := range o.Items {
+			p, ok := t.m.products[item.ProductID]
+			if !ok {
+				return &PaidAfterCancelError{OrderID: o.ID, Total: o.Total, Err: &ProductNotFoundError{ProductID: item.ProductID}}
+			}
+			stock[p.ID] = p.Stock
+		}
+		if shortages := stockShortages(o.Items, stock); len(shortages) > 0 {
+			return &PaidAfterCancelError{OrderID: o.ID, Total: o.Total, Err: &OutOfStockError{Items: shortages}}
+		}
+		t.m.adjustStock(o.Items, -1)
+	case to == OrderStatusRefunded || to == OrderStatusPartiallyRefunded:
+		if !canRefundTransition(from, to) {
+			return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+		}
+	default:
+		if !canStripeTransition(from, to) {
+			return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+		}
+	}
+
+	t.m.transition(o, to, changedBy)
+	return nil
+}
+
+// SetReservationBySessionID changes when the stock reservation of the unpaid
+// order with the given Stripe Checkout Session ID expires
+func (t memoryStripeEventTx) SetReservationBySessionID(ctx context.Context, sessionID string, until time.Time) error {
+	o, ok := t.m.orderBySessionID(sessionID)
+	if !ok {
+		return ErrNotFound
+	}
+
+	unpaid := o.Status == OrderStatusPending || o.Status == OrderStatusPaymentFailed
+	if o.ReservedUntil != nil && unpaid {
+		o.ReservedUntil = &until
+		o.UpdatedAt = time.Now()
+		t.m.orders[o.ID] = o
+	}
+	return nil
+}
+
+// orderBySessionID returns the order with a Stripe Checkout Session ID, with
+// m.mu held
+func (m *MemoryStore) orderBySessionID(sessionID string) (Order, bool) {
+	for _, o := range m.orders {
+		if o.StripeSessionID == sessionID {
+			return o, true
+		}
+	}
+	return Order{}, false
+}
+
+// BeginRefund records a pending refund of an order, or returns the refund
+// already recorded with req's idempotency key
+func (m *MemoryStore) BeginRefund(ctx context.Context, req RefundRequest) (*Refund, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.orders[req.OrderID]
+	if !ok {
+		return nil, false, ErrNotFound
+	}
+
+	for _, r := range m.refunds {
+		if r.IdempotencyKey == req.IdempotencyKey {
+			if !sameRefund(&r, req) {
+				return nil, false, ErrRefundKeyReused
+			}
+			r = copyRefund(r)
+			return &r, false, nil
+		}
+	}
+
+	if !refundableStatuses[o.Status] {
+		return nil, false, fmt.Errorf("%w: %s", ErrOrderNotRefundable, o.Status)
+	}
+
+	planned, err := planRefund(req, m.refundableItems(o, RefundStatusPending, RefundStatusIssued))
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now()
+	refund := Refund{
+		ID:             m.id("refunds"),
+		OrderID:        req.OrderID,
+		Status:         RefundStatusPending,
+		Full:           len(req.Lines) == 0,
+		Reason:         req.Reason,
+		IdempotencyKey: req.IdempotencyKey,
+		CreatedBy:      req.CreatedBy,
+		Items:          planned,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	for i := range refund.Items {
+		item := &refund.Items[i]
+		item.ID = m.id("refund_items")
+		item.RefundID = refund.ID
+		refund.Amount += item.Amount
+	}
+	m.refunds = append(m.refunds, copyRefund(refund))
+
+	return &refund, true, nil
+}
+
+// refundableItems returns o's items with the quantity of each covered by
+// refunds in one of statuses
+func (m *MemoryStore) refundableItems(o Order, statuses ...RefundStatus) []refundableItem {
+	refunded := make(map[int]int)
+	for _, r := range m.refunds {
+		for _, s := range statuses {
+			if r.OrderID == o.ID && r.Status == s {
+				for _, item := range r.Items {
+					refunded[item.OrderItemID] += item.Quantity
+				}
+			}
+		}
+	}
+
+	items := make([]refundableItem, len(o.Items))
+	for i, item := range o.Items {
+		items[i] = refundableItem{OrderItem: item, Refunded: refunded[item.ID]}
+	}
+	return items
+}
+
+// CompleteRefund marks a pending refund as issued, restocks its items and
+// moves the order to refunded or partially_refunded
+func (m *MemoryStore) CompleteRefund(ctx context.Context, id int, stripeRefundID string) (*Refund, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.refundIndex(id)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	refund := &m.refunds[i]
+	if refund.Status != RefundStatusPending {
+		r := copyRefund(*refund)
+		return &r, nil
+	}
+	refund.Status = RefundStatusIssued
+	refund.StripeRefundID = stripeRefundID
+	refund.UpdatedAt = time.Now()
+
+	o := m.orders[refund.OrderID]
+	byID := make(map[int]OrderItem, len(o.Items))
+	for _, item := range o.Items {
+		byID[item.ID] = item
+	}
+	restocked := make([]OrderItem, len(refund.Items))
+	for i, item := range refund.Items {
+		restocked[i] = OrderItem{ProductID: byID[item.OrderItemID].ProductID, Quantity: item.Quantity}
+	}
+	m.adjustStock(restocked, 1)
+
+	to := OrderStatusRefunded
+	for _, item := range m.refundableItems(o, RefundStatusIssued) {
+		if item.Refunded < item.Quantity {
+			to = OrderStatusPartiallyRefunded
+		}
+	}
+	if canRefundTransition(o.Status, to) {
+		m.transition(o, to, refund.CreatedBy)
+	}
+
+	r := copyRefund(*refund)
+	return &r, nil
+}
+
+// FailRefund marks a pending refund as rejected
+func (m *MemoryStore) FailRefund(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i := m.refundIndex(id); i >= 0 && m.refunds[i].Status == RefundStatusPending {
+		m.refunds[i].Status = RefundStatusFailed
+		m.refunds[i].UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+// GetRefunds returns the refunds of an order, oldest first
+func (m *MemoryStore) GetRefunds(ctx context.Context, orderID int) ([]Refund, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var refunds []Refund
+	for _, r := range m.refunds {
+		if r.OrderID == orderID {
+			refunds = append(refunds, copyRefund(r))
+		}
+	}
+	return refunds, nil
+}
+
+// refundIndex returns the index of a refund in m.refunds, or -1
+func (m *MemoryStore) refundIndex(id int) int {
+	for i, r := range m.refunds {
+		if r.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// copyRefund returns r with its own copy of the items slice
+func copyRefund(r Refund) Refund {
+	r.Items = append([]RefundItem{}, r.Items...)
+	return r
+}
+
+// BeginIdempotentRequest claims req's key, or returns the response stored
+// for it
+func (m *MemoryStore) BeginIdempotentRequest(ctx context.Context, req IdempotentRequest) (*IdempotentResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := idempotencyKey{req.Scope, req.Key}
+	record, ok := m.idempotency[k]
+	switch {
+	case !ok || !record.expiresAt.After(time.Now()):
+		m.idempotency[k] = idempotencyRecord{fingerprint: req.Fingerprint, expiresAt: req.ExpiresAt}
+		return nil, nil
+	case record.fingerprint != req.Fingerprint:
+		return nil, ErrIdempotencyKeyReused
+	case record.response == nil:
+		return nil, ErrIdempotencyKeyInUse
+	}
+
+	resp := *record.response
+	resp.Body = append([]byte(nil), resp.Body...)
+	return &resp, nil
+}
+
+// CompleteIdempotentRequest stores the response to the request holding a key
+func (m *MemoryStore) CompleteIdempotentRequest(ctx context.Context, scope, key string, resp IdempotentResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := idempotencyKey{scope, key}
+	record, ok := m.idempotency[k]
+	if !ok || record.response != nil {
+		return ErrNotFound
+	}
+	resp.Body = append([]byte(nil), resp.Body...)
+	record.response = &resp
+	m.idempotency[k] = record
+	return nil
+}
+
+// ReleaseIdempotentRequest gives up a key claimed by a request that did not
+// complete
+func (m *MemoryStore) ReleaseIdempotentRequest(ctx context.Context, scope, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := idempotencyKey{scope, key}
+	if record, ok := m.idempotency[k]; ok && record.response == nil {
+		delete(m.idempotency, k)
+	}
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys deletes the keys that expired before now
+func (m *MemoryStore) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for k, record := range m.idempotency {
+		if !record.expiresAt.After(now) {
+			delete(m.idempotency, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// memoryCart is a cart in MemoryStore. Its items are priced when read.
+type memoryCart struct {
+	Cart
+	tokenHash string
+}
+
+// cartByOwner returns the cart of owner
+func (m *MemoryStore) cartByOwner(owner CartOwner) (memoryCart, bool) {
+	userID, tokenHash := owner.columns()
+	for _, c := range m.carts {
+		if (userID.Valid && c.UserID != nil && *c.UserID == int(userID.Int64)) || (tokenHash.Valid && c.tokenHash == tokenHash.String) {
+			return c, true
+		}
+	}
+	return memoryCart{}, false
+}
+
+// priced returns a copy of c with its items priced from the products
+func (m *MemoryStore) priced(c memoryCart) *Cart {
+	cart := c.Cart
+	cart.Items = []CartItem{}
+	for _, item := range c.Items {
+		p, ok := m.products[item.ProductID]
+		if !ok {
+			continue
+		}
+		item.Name, item.Price = p.Name, p.Price
+		cart.Items = append(cart.Items, item)
+	}
+	cart.price()
+	return &cart
+}
+
+// GetCart returns the cart of owner, with its items priced
+func (m *MemoryStore) GetCart(ctx context.Context, owner CartOwner) (*Cart, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.cartByOwner(owner)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return m.priced(c), nil
+}
+
+// ProvisionCart returns the cart of owner, creating an empty one if owner
+// has none
+func (m *MemoryStore) ProvisionCart(ctx context.Context, owner CartOwner) (*Cart, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.priced(m.provisionCart(owner)), nil
+}
+
+// provisionCart returns the cart of owner, creating it if needed, with m.mu held
+func (m *MemoryStore) provisionCart(owner CartOwner) memoryCart {
+	if c, ok := m.cartByOwner(owner); ok {
+		return c
+	}
+
+	now := time.Now()
+	c := memoryCart{Cart: Cart{ID: m.id("carts"), CreatedAt: now, UpdatedAt: now}}
+	if userID, tokenHash := owner.columns(); userID.Valid {
+		id := int(userID.Int64)
+		c.UserID = &id
+	} else {
+		c.tokenHash = tokenHash.String
+	}
+	m.carts[c.ID] = c
+	return c
+}
+
+// AddCartItem adds quantity of a product to a cart, on top of any already
+// in it
+func (m *MemoryStore) AddCartItem(ctx context.Context, cartID, productID, quantity int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.carts[cartID]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := m.products[productID]; !ok {
+		return &ProductNotFoundError{ProductID: productID}
+	}
+
+	now := time.Now()
+	c.Items = append([]CartItem(nil), c.Items...)
+	for i := range c.Items {
+		item := &c.Items[i]
+		if item.ProductID != productID {
+			continue
+		}
+		if item.Quantity+quantity > MaxCartQuantity {
+			return ErrCartQuantityLimit
+		}
+		item.Quantity += quantity
+		item.UpdatedAt = now
+		c.UpdatedAt = now
+		m.carts[cartID] = c
+		return nil
+	}
+
+	c.Items = append(c.Items, CartItem{ID: m.id("cart_items"), ProductID: productID, Quantity: quantity, CreatedAt: now, UpdatedAt: now})
+	c.UpdatedAt = now
+	m.carts[cartID] = c
+	return nil
+}
+
+// SetCartItemQuantity sets the quantity of an item in a cart
+func (m *MemoryStore) SetCartItemQuantity(ctx context.Context, cartID, itemID, quantity int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.carts[cartID]
+	if !ok {
+		return ErrNotFound
+	}
+	now := time.Now()
+	c.Items = append([]CartItem(nil), c.Items...)
+	for i := range c.Items {
+		if c.Items[i].ID == itemID {
+			c.Items[i].Quantity = quantity
+			c.Items[i].UpdatedAt = now
+			c.UpdatedAt = now
+			m.carts[cartID] = c
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// RemoveCartItem removes an item from a cart
+func (m *MemoryStore) RemoveCartItem(ctx context.Context, cartID, itemID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.carts[cartID]
+	if !ok {
+		return ErrNotFound
+	}
+	for i, item := range c.Items {
+		if item.ID == itemID {
+			c.Items = append(append([]CartItem(nil), c.Items[:i]...), c.Items[i+1:]...)
+			c.UpdatedAt = time.Now()
+			m.carts[cartID] = c
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// ClearCart removes every item from a cart
+func (m *MemoryStore) ClearCart(ctx context.Context, cartID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.carts[cartID]; ok {
+		c.Items = nil
+		c.UpdatedAt = time.Now()
+		m.carts[cartID] = c
+	}
+	return nil
+}
+
+// MergeCart moves the items of the anonymous cart holding token into the
+// cart of a user and deletes the anonymous cart
+func (m *MemoryStore) MergeCart(ctx context.Context, token string, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from, ok := m.cartByOwner(CartOwner{Token: token})
+	if !ok {
+		return nil
+	}
+	into := m.provisionCart(CartOwner{UserID: userID})
+
+	now := time.Now()
+	into.Items = append([]CartItem(nil), into.Items...)
+	for _, moved := range from.Items {
+		merged := false
+		for i := range into.Items {
+			item := &into.Items[i]
+			if item.ProductID == moved.ProductID {
+				item.Quantity += moved.Quantity
+				if item.Quantity > MaxCartQuantity {
+					item.Quantity = MaxCartQuantity
+				}
+				item.UpdatedAt = now
+				merged = true
+			}
+		}
+		if !merged {
+			moved.ID = m.id("cart_items")
+			moved.UpdatedAt = now
+			into.Items = append(into.Items, moved)
+		}
+	}
+	into.UpdatedAt = now
+
+	m.carts[into.ID] = into
+	delete(m.carts, from.ID)
+	return nil
+}
+
+// ConvertCart creates o from the items of a cart and empties the cart
+func (m *MemoryStore) ConvertCart(ctx context.Context, cartID int, o *Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.carts[cartID]
+	if !ok || len(c.Items) == 0 {
+		return ErrCartEmpty
+	}
+
+	o.Items = make([]OrderItem, len(c.Items))
+	for i, item := range c.Items {
+		o.Items[i] = OrderItem{ProductID: item.ProductID, Quantity: item.Quantity}
+	}
+	if err := m.createOrder(o); err != nil {
+		return err
+	}
+
+	c.Items = nil
+	c.UpdatedAt = o.CreatedAt
+	m.carts[cartID] = c
+	return nil
+}
+
+// DeleteStaleCarts deletes the anonymous carts last changed before before
+func (m *MemoryStore) DeleteStaleCarts(ctx context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for id, c := range m.carts {
+		if c.tokenHash != "" && c.UpdatedAt.Before(before) {
+			delete(m.carts, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// redeemCoupon applies the coupon named by o.CouponCode to o, with m.mu held
+func (m *MemoryStore) redeemCoupon(o *Order, now time.Time) error {
+	var c *Coupon
+	for _, coupon := range m.coupons {
+		if coupon.Code == normalizeCouponCode(o.CouponCode) {
+			c = &coupon
+			break
+		}
+	}
+	if c == nil {
+		return &CouponError{Code: o.CouponCode, Message: "does not exist"}
+	}
+
+	var redeemed, redeemedByUser int
+	for _, other := range m.orders {
+		if other.CouponID != nil && *other.CouponID == c.ID && other.Status != OrderStatusCanceled {
+			redeemed++
+			if other.UserID == o.UserID {
+				redeemedByUser++
+			}
+		}
+	}
+
+	return applyCoupon(c, o, now, redeemed, redeemedByUser)
+}
+
+// GetCoupons returns every coupon, oldest first
+func (m *MemoryStore) GetCoupons(ctx context.Context) ([]Coupon, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	coupons := make([]Coupon, 0, len(m.coupons))
+	for _, c := range m.coupons {
+		coupons = append(coupons, copyCoupon(c))
+	}
+	sort.Slice(coupons, func(i, j int) bool { return coupons[i].ID < coupons[j].ID })
+	return coupons, nil
+}
+
+// GetCouponByID returns a coupon by ID
+func (m *MemoryStore) GetCouponByID(ctx context.Context, id int) (*Coupon, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.coupons[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c = copyCoupon(c)
+	return &c, nil
+}
+
+// CreateCoupon creates a new coupon, normalizing its code
+func (m *MemoryStore) CreateCoupon(ctx context.Context, c *Coupon) error {
+	if !c.validValue() {
+		return ErrInvalidCouponValue
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c.Code = normalizeCouponCode(c.Code)
+	if m.couponCodeTaken(c.Code, 0) {
+		return ErrDuplicateCouponCode
+	}
+	now := time.Now()
+	c.ID = m.id("coupons")
+	c.CreatedAt = now
+	c.UpdatedAt = now
+	m.coupons[c.ID] = copyCoupon(*c)
+
+	return nil
+}
+
+// UpdateCoupon updates a coupon. Orders already placed with it keep their
+// discounts.
+func (m *MemoryStore) UpdateCoupon(ctx context.Context, c *Coupon) error {
+	if !c.validValue() {
+		return ErrInvalidCouponValue
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.coupons[c.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	c.Code = normalizeCouponCode(c.Code)
+	if m.couponCodeTaken(c.Code, c.ID) {
+		return ErrDuplicateCouponCode
+	}
+	c.CreatedAt = existing.CreatedAt
+	c.UpdatedAt = time.Now()
+	m.coupons[c.ID] = copyCoupon(*c)
+
+	return nil
+}
+
+// DeleteCoupon deletes a coupon that no order was placed with
+func (m *MemoryStore) DeleteCoupon(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.coupons[id]; !ok {
+		return ErrNotFound
+	}
+	for _, o := range m.orders {
+		if o.CouponID != nil && *o.CouponID == id {
+			return ErrCouponRedeemed
+		}
+	}
+	delete(m.coupons, id)
+
+	return nil
+}
+
+// couponCodeTaken reports whether a coupon other than id has code, with
+// m.mu held
+func (m *MemoryStore) couponCodeTaken(code string, id int) bool {
+	for _, c := range m.coupons {
+		if c.Code == code && c.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// copyCoupon returns c with its own copy of the product IDs
+func copyCoupon(c Coupon) Coupon {
+	c.ProductIDs = append([]int{}, c.ProductIDs...)
+	return c
+}
+
+// copyOrder returns o with its own copy of the items slice
+func copyOrder(o Order) Order {
+	if o.Items != nil {
+		o.Items = append([]OrderItem(nil), o.Items...)
+	}
+	return o
+}
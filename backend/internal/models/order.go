@@ -1,18 +1,36 @@
 package models
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/your-username/your-repo/internal/database"
 )
 
+// ProductNotFoundError is returned by CreateOrder when an item references a
+// product that does not exist
+type ProductNotFoundError struct {
+	ProductID int
+}
+
+func (e *ProductNotFoundError) Error() string {
+	return fmt.Sprintf("product with id %d not found", e.ProductID)
+}
+
 // Order represents an order in the system
 type Order struct {
 	ID              int         `json:"id"`
 	UserID          int         `json:"user_id"`
-	Status          string      `json:"status"`
-	Total           int         `json:"total"` // Total in cents
+	Status          OrderStatus `json:"status"`
+	Total           int         `json:"total"`    // Total in cents, after the discount
+	Discount        int         `json:"discount"` // Discount in cents
+	CouponID        *int        `json:"coupon_id,omitempty"`
+	CouponCode      string      `json:"coupon_code,omitempty"`
 	StripeSessionID string      `json:"stripe_session_id,omitempty"`
+	ReservedUntil   *time.Time  `json:"reserved_until,omitempty"` // When the order's stock reservation expires
 	Items           []OrderItem `json:"items,omitempty"`
 	CreatedAt       time.Time   `json:"created_at"`
 	UpdatedAt       time.Time   `json:"updated_at"`
@@ -24,107 +42,248 @@ type OrderItem struct {
 	OrderID   int       `json:"order_id"`
 	ProductID int       `json:"product_id"`
 	Quantity  int       `json:"quantity"`
-	Price     int       `json:"price"` // Price at time of purchase in cents
+	Price     int       `json:"price"`    // Price at time of purchase in cents
+	Discount  int       `json:"discount"` // Discount on the line in cents
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
-// GetOrders returns all orders
-func GetOrders(db *database.DB) ([]Order, error) {
-	rows, err := db.Query(`
-		SELECT id, user_id, status, total, stripe_session_id, created_at, updated_at
-		FROM orders
-		ORDER BY created_at DESC
-	`)
+// OrderFilter narrows a list of orders. Zero fields are not applied;
+// CreatedFrom is inclusive and CreatedTo exclusive.
+type OrderFilter struct {
+	Status      OrderStatus
+	UserID      int
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+}
+
+// matches reports whether o passes the filter
+func (f OrderFilter) matches(o Order) bool {
+	return (f.Status == "" || o.Status == f.Status) &&
+		(f.UserID == 0 || o.UserID == f.UserID) &&
+		(f.CreatedFrom.IsZero() || !o.CreatedAt.Before(f.CreatedFrom)) &&
+		(f.CreatedTo.IsZero() || o.CreatedAt.Before(f.CreatedTo))
+}
+
+// orderSorts are the fields orders can be sorted by
+var orderSorts = map[string]sortField[Order]{
+	"created_at": {column: "created_at", sqlType: "timestamp", key: func(o Order) interface{} { return o.CreatedAt }},
+	"total":      {column: "total", sqlType: "integer", key: func(o Order) interface{} { return o.Total }},
+}
+
+// orderID returns the ID of an order, to break ties between sort values
+func orderID(o Order) int { return o.ID }
+
+// PostgresOrderStore implements OrderStore on top of Postgres
+type PostgresOrderStore struct {
+	db *database.DB
+}
+
+// NewPostgresOrderStore creates a new PostgresOrderStore
+func NewPostgresOrderStore(db *database.DB) *PostgresOrderStore {
+	return &PostgresOrderStore{db: db}
+}
+
+// GetOrders returns a page of the orders matching filter, newest first by
+// default, and the cursor of the next page
+func (s *PostgresOrderStore) GetOrders(ctx context.Context, filter OrderFilter, page Page) ([]Order, string, error) {
+	order, err := resolveSort(page.Sort, "-created_at", orderSorts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	var q listQuery
+	if filter.Status != "" {
+		q.where("status = " + q.arg(filter.Status))
+	}
+	if filter.UserID != 0 {
+		q.where("user_id = " + q.arg(filter.UserID))
+	}
+	if !filter.CreatedFrom.IsZero() {
+		q.where("created_at >= " + q.arg(filter.CreatedFrom))
+	}
+	if !filter.CreatedTo.IsZero() {
+		q.where("created_at < " + q.arg(filter.CreatedTo))
+	}
+	tail, err := paginate(&q, page, order)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, status, total, discount, coupon_id, coupon_code, stripe_session_id, reserved_until, created_at, updated_at
+		FROM orders`+q.whereClause()+tail, q.args...)
+	if err != nil {
+		return nil, "", err
 	}
 	defer rows.Close()
 
 	var orders []Order
 	for rows.Next() {
 		var o Order
-		if err := rows.Scan(&o.ID, &o.UserID, &o.Status, &o.Total, &o.StripeSessionID, &o.CreatedAt, &o.UpdatedAt); err != nil {
-			return nil, err
-		}
-		
-		// Get order items
-		items, err := getOrderItems(db, o.ID)
-		if err != nil {
-			return nil, err
+		if err := rows.Scan(&o.ID, &o.UserID, &o.Status, &o.Total, &o.Discount, &o.CouponID, &o.CouponCode, &o.StripeSessionID, &o.ReservedUntil, &o.CreatedAt, &o.UpdatedAt); err != nil {
+			return nil, "", err
 		}
-		o.Items = items
-		
 		orders = append(orders, o)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	// Release the connection before loading items rather than holding two
+	rows.Close()
+
+	orders, next := trimPage(orders, page, order, orderID)
+
+	ptrs := make([]*Order, len(orders))
+	for i := range orders {
+		ptrs[i] = &orders[i]
+	}
+	if err := s.loadOrderItems(ctx, ptrs...); err != nil {
+		return nil, "", err
+	}
 
-	return orders, nil
+	return orders, next, nil
 }
 
 // GetOrderByID returns an order by ID
-func GetOrderByID(db *database.DB, id int) (*Order, error) {
+func (s *PostgresOrderStore) GetOrderByID(ctx context.Context, id int) (*Order, error) {
 	var o Order
-	err := db.QueryRow(`
-		SELECT id, user_id, status, total, stripe_session_id, created_at, updated_at
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, user_id, status, total, discount, coupon_id, coupon_code, stripe_session_id, reserved_until, created_at, updated_at
 		FROM orders
 		WHERE id = $1
-	`, id).Scan(&o.ID, &o.UserID, &o.Status, &o.Total, &o.StripeSessionID, &o.CreatedAt, &o.UpdatedAt)
+	`, id).Scan(&o.ID, &o.UserID, &o.Status, &o.Total, &o.Discount, &o.CouponID, &o.CouponCode, &o.StripeSessionID, &o.ReservedUntil, &o.CreatedAt, &o.UpdatedAt)
 	if err != nil {
+		return nil, notFound(err)
+	}
+
+	if err := s.loadOrderItems(ctx, &o); err != nil {
 		return nil, err
 	}
 
-	// Get order items
-	items, err := getOrderItems(db, o.ID)
+	return &o, nil
+}
+
+// GetOrderByStripeSessionID returns an order by Stripe Checkout Session ID
+func (s *PostgresOrderStore) GetOrderByStripeSessionID(ctx context.Context, sessionID string) (*Order, error) {
+	var o Order
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, user_id, status, total, discount, coupon_id, coupon_code, stripe_session_id, reserved_until, created_at, updated_at
+		FROM orders
+		WHERE stripe_session_id = $1
+	`, sessionID).Scan(&o.ID, &o.UserID, &o.Status, &o.Total, &o.Discount, &o.CouponID, &o.CouponCode, &o.StripeSessionID, &o.ReservedUntil, &o.CreatedAt, &o.UpdatedAt)
 	if err != nil {
+		return nil, notFound(err)
+	}
+
+	if err := s.loadOrderItems(ctx, &o); err != nil {
 		return nil, err
 	}
-	o.Items = items
 
 	return &o, nil
 }
 
-// getOrderItems returns all items for an order
-func getOrderItems(db *database.DB, orderID int) ([]OrderItem, error) {
-	rows, err := db.Query(`
-		SELECT id, order_id, product_id, quantity, price, created_at, updated_at
+// loadOrderItems sets the items of every given order with a single query
+func (s *PostgresOrderStore) loadOrderItems(ctx context.Context, orders ...*Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*Order, len(orders))
+	ids := make([]int64, len(orders))
+	for i, o := range orders {
+		byID[o.ID] = o
+		ids[i] = int64(o.ID)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, order_id, product_id, quantity, price, discount, created_at, updated_at
 		FROM order_items
-		WHERE order_id = $1
-	`, orderID)
+		WHERE order_id = ANY($1)
+		ORDER BY order_id, id
+	`, pq.Array(ids))
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer rows.Close()
 
-	var items []OrderItem
 	for rows.Next() {
 		var i OrderItem
-		if err := rows.Scan(&i.ID, &i.OrderID, &i.ProductID, &i.Quantity, &i.Price, &i.CreatedAt, &i.UpdatedAt); err != nil {
-			return nil, err
+		if err := rows.Scan(&i.ID, &i.OrderID, &i.ProductID, &i.Quantity, &i.Price, &i.Discount, &i.CreatedAt, &i.UpdatedAt); err != nil {
+			return err
 		}
-		items = append(items, i)
+		o := byID[i.OrderID]
+		o.Items = append(o.Items, i)
 	}
 
-	return items, nil
+	return rows.Err()
 }
 
-// CreateOrder creates a new order
-func CreateOrder(db *database.DB, o *Order) error {
-	tx, err := db.Begin()
+// CreateOrder creates a new order. Only the product ID and quantity of each
+// item are used: item prices are snapshotted from the products table and the
+// total is computed within the same transaction, with the product rows locked
+// so their prices cannot change underneath it.
+//
+// The items' stock is taken in the same transaction and reserved for the
+// order until o.ReservedUntil, or DefaultReservationTTL from now if that is
+// nil. If any product is short, no stock is taken and an OutOfStockError
+// lists every short product.
+//
+// If o.CouponCode is set, the coupon is checked and redeemed in the same
+// transaction, discounting the items it applies to, or a CouponError says
+// why it cannot be used.
+func (s *PostgresOrderStore) CreateOrder(ctx context.Context, o *Order) error {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	if err := insertOrder(ctx, tx, o); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertOrder prices, reserves and inserts o as part of tx, for CreateOrder
+// and operations that create orders along with other changes
+func insertOrder(ctx context.Context, tx *sql.Tx, o *Order) error {
+	products, err := lockProducts(ctx, tx, o.Items)
+	if err != nil {
+		return err
+	}
+	if err := reserveStock(ctx, tx, o, products); err != nil {
+		return err
+	}
+
 	now := time.Now()
 	o.CreatedAt = now
 	o.UpdatedAt = now
+	if o.ReservedUntil == nil {
+		reservedUntil := now.Add(DefaultReservationTTL)
+		o.ReservedUntil = &reservedUntil
+	}
+
+	o.Total, o.Discount, o.CouponID = 0, 0, nil
+	for i := range o.Items {
+		item := &o.Items[i]
+		item.Price = products[item.ProductID].Price
+		item.Discount = 0
+		o.Total += item.Price * item.Quantity
+	}
+	if o.CouponCode != "" {
+		if err := redeemCoupon(ctx, tx, o, now); err != nil {
+			return err
+		}
+	}
 
 	// Insert order
-	err = tx.QueryRow(`
-		INSERT INTO orders (user_id, status, total, stripe_session_id, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO orders (user_id, status, total, discount, coupon_id, coupon_code, stripe_session_id, reserved_until, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		RETURNING id
-	`, o.UserID, o.Status, o.Total, o.StripeSessionID, o.CreatedAt, o.UpdatedAt).Scan(&o.ID)
+	`, o.UserID, o.Status, o.Total, o.Discount, o.CouponID, o.CouponCode, o.StripeSessionID, o.ReservedUntil, o.CreatedAt, o.UpdatedAt).Scan(&o.ID)
 	if err != nil {
 		return err
 	}
@@ -136,48 +295,110 @@ func CreateOrder(db *database.DB, o *Order) error {
 		item.CreatedAt = now
 		item.UpdatedAt = now
 
-		err = tx.QueryRow(`
-			INSERT INTO order_items (order_id, product_id, quantity, price, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6)
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO order_items (order_id, product_id, quantity, price, discount, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
 			RETURNING id
-		`, item.OrderID, item.ProductID, item.Quantity, item.Price, item.CreatedAt, item.UpdatedAt).Scan(&item.ID)
+		`, item.OrderID, item.ProductID, item.Quantity, item.Price, item.Discount, item.CreatedAt, item.UpdatedAt).Scan(&item.ID)
 		if err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit()
+	return nil
 }
 
-// UpdateOrder updates an order
-func UpdateOrder(db *database.DB, o *Order) error {
-	o.UpdatedAt = time.Now()
+// lockProducts locks the products referenced by items for the rest of the
+// transaction and returns their current prices and stock by product ID
+func lockProducts(ctx context.Context, tx *sql.Tx, items []OrderItem) (map[int]Product, error) {
+	ids := make([]int64, len(items))
+	for i, item := range items {
+		ids[i] = int64(item.ProductID)
+	}
 
-	_, err := db.Exec(`
-		UPDATE orders
-		SET status = $1, stripe_session_id = $2, updated_at = $3
-		WHERE id = $4
-	`, o.Status, o.StripeSessionID, o.UpdatedAt, o.ID)
+	// Lock in ID order so concurrent orders cannot deadlock
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, price, stock
+		FROM products
+		WHERE id = ANY($1)
+		ORDER BY id
+		FOR UPDATE
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	return err
+	products := make(map[int]Product, len(items))
+	for rows.Next() {
+		var p Product
+		if err := rows.Scan(&p.ID, &p.Price, &p.Stock); err != nil {
+			return nil, err
+		}
+		products[p.ID] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		if _, ok := products[item.ProductID]; !ok {
+			return nil, &ProductNotFoundError{ProductID: item.ProductID}
+		}
+	}
+
+	return products, nil
+}
+
+// SetOrderStripeSessionID records the Stripe Checkout Session of an order.
+// Status changes go through TransitionOrder.
+func (s *PostgresOrderStore) SetOrderStripeSessionID(ctx context.Context, id int, sessionID string) error {
+	return requireRows(s.db.ExecContext(ctx, `
+		UPDATE orders
+		SET stripe_session_id = $1, updated_at = $2
+		WHERE id = $3
+	`, sessionID, time.Now(), id))
 }
 
-// DeleteOrder deletes an order
-func DeleteOrder(db *database.DB, id int) error {
-	tx, err := db.Begin()
+// DeleteOrder deletes an order, releasing any stock it has reserved
+func (s *PostgresOrderStore) DeleteOrder(ctx context.Context, id int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	if err := releaseReservation(ctx, tx, id); err != nil {
+		return err
+	}
+
+	// Delete refunds
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM refund_items
+		WHERE refund_id IN (SELECT id FROM refunds WHERE order_id = $1)
+	`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM refunds WHERE order_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
 	// Delete order items
-	_, err = tx.Exec(`DELETE FROM order_items WHERE order_id = $1`, id)
+	_, err = tx.ExecContext(ctx, `DELETE FROM order_items WHERE order_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	// Delete status history
+	_, err = tx.ExecContext(ctx, `DELETE FROM order_status_history WHERE order_id = $1`, id)
 	if err != nil {
 		return err
 	}
 
 	// Delete order
-	_, err = tx.Exec(`DELETE FROM orders WHERE id = $1`, id)
+	err = requireRows(tx.ExecContext(ctx, `DELETE FROM orders WHERE id = $1`, id))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,256 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// OrderStatus is the lifecycle state of an order
+type OrderStatus string
+
+// Order statuses
+const (
+	OrderStatusPending       OrderStatus = "pending"
+	OrderStatusPaymentFailed OrderStatus = "payment_failed"
+	OrderStatusPaid          OrderStatus = "paid"
+	OrderStatusFulfilled     OrderStatus = "fulfilled"
+	OrderStatusShipped       OrderStatus = "shipped"
+	OrderStatusDelivered     OrderStatus = "delivered"
+	OrderStatusCanceled      OrderStatus = "canceled"
+	OrderStatusRefunded      OrderStatus = "refunded"
+	// OrderStatusPartiallyRefunded orders have had some of their items refunded
+	OrderStatusPartiallyRefunded OrderStatus = "partially_refunded"
+)
+
+// orderTransitions declares the statuses each status may move to. Statuses
+// with no entry are terminal. Refund statuses are not in it: only issuing a
+// refund can reach them, see refundTransitions.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	// A failed payment can be retried in the same Checkout Session
+	OrderStatusPending:       {OrderStatusPaid, OrderStatusPaymentFailed, OrderStatusCanceled},
+	OrderStatusPaymentFailed: {OrderStatusPaid, OrderStatusCanceled},
+	// Paid orders must be refunded rather than canceled
+	OrderStatusPaid:      {OrderStatusFulfilled},
+	OrderStatusFulfilled: {OrderStatusShipped},
+	OrderStatusShipped:   {OrderStatusDelivered},
+	// The items left in a partially refunded order can still be fulfilled
+	OrderStatusPartiallyRefunded: {OrderStatusFulfilled, OrderStatusShipped, OrderStatusDelivered},
+}
+
+// refundTransitions declares the refund statuses each status may move to
+// when a refund is completed or Stripe reports a charge refunded
+var refundTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusPaid:              {OrderStatusPartiallyRefunded, OrderStatusRefunded},
+	OrderStatusFulfilled:         {OrderStatusPartiallyRefunded, OrderStatusRefunded},
+	OrderStatusShipped:           {OrderStatusPartiallyRefunded, OrderStatusRefunded},
+	OrderStatusDelivered:         {OrderStatusPartiallyRefunded, OrderStatusRefunded},
+	OrderStatusPartiallyRefunded: {OrderStatusPartiallyRefunded, OrderStatusRefunded},
+}
+
+// stripeTransitions declares the moves Stripe events may make on top of
+// orderTransitions. Each retry of a failed payment that fails again is
+// recorded, though it leaves the order's status as it was.
+var stripeTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusPaymentFailed: {OrderStatusPaymentFailed},
+}
+
+var (
+	// ErrInvalidTransition is returned when the transition table does not
+	// allow moving between two statuses
+	ErrInvalidTransition = errors.New("invalid order status transition")
+	// ErrStatusConflict is returned when an order is no longer in the status
+	// a transition expected it to be in
+	ErrStatusConflict = errors.New("order status has changed")
+)
+
+// Valid reports whether s is a known order status
+func (s OrderStatus) Valid() bool {
+	switch s {
+	case OrderStatusPending, OrderStatusPaymentFailed, OrderStatusPaid, OrderStatusFulfilled,
+		OrderStatusShipped, OrderStatusDelivered, OrderStatusCanceled, OrderStatusRefunded,
+		OrderStatusPartiallyRefunded:
+		return true
+	}
+	return false
+}
+
+// CanTransition reports whether an order may move from one status to another
+// through TransitionOrder
+func CanTransition(from, to OrderStatus) bool {
+	return allows(orderTransitions, from, to)
+}
+
+// canRefundTransition reports whether refunding an order may move it from one
+// status to another
+func canRefundTransition(from, to OrderStatus) bool {
+	return allows(refundTransitions, from, to)
+}
+
+// canStripeTransition reports whether a Stripe event may move an order from
+// one status to another
+func canStripeTransition(from, to OrderStatus) bool {
+	return CanTransition(from, to) || allows(stripeTransitions, from, to)
+}
+
+// allows reports whether transitions lets an order move from one status to
+// another
+func allows(transitions map[OrderStatus][]OrderStatus, from, to OrderStatus) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderStatusChange is a recorded change of an order's status
+type OrderStatusChange struct {
+	ID         int         `json:"id"`
+	OrderID    int         `json:"order_id"`
+	FromStatus OrderStatus `json:"from_status"`
+	ToStatus   OrderStatus `json:"to_status"`
+	ChangedBy  string      `json:"changed_by"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+// TransitionOrder moves an order from one status to another and records the
+// change, releasing the order's stock reservation if it is canceled. The
+// update only applies if the order is still in the from status, so
+// concurrent transitions cannot overwrite each other; the loser gets
+// ErrStatusConflict. changedBy identifies who made the change.
+func (s *PostgresOrderStore) TransitionOrder(ctx context.Context, id int, from, to OrderStatus, changedBy string) error {
+	if !CanTransition(from, to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE orders
+		SET status = $1, updated_at = $2
+		WHERE id = $3 AND status = $4
+	`, to, time.Now(), id, from)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE id = $1)`, id).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotFound
+		}
+		return ErrStatusConflict
+	}
+
+	if err := settleReservation(ctx, tx, id, to); err != nil {
+		return err
+	}
+	if err := recordStatusChange(ctx, tx, id, from, to, changedBy); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// transitionOrderBySessionID moves the order with the given Stripe Checkout
+// Session ID to status within tx, if the transition tables allow it from the
+// order's current status. It returns ErrNotFound if there is no such order
+// and ErrInvalidTransition if the move is not allowed.
+//
+// Payment for an order that was canceled, such as by its reservation
+// expiring while the customer paid, reinstates the order if its stock can be
+// reserved again. Otherwise the order stays canceled and a
+// PaidAfterCancelError says the payment must be given back.
+func transitionOrderBySessionID(ctx context.Context, tx *sql.Tx, sessionID string, to OrderStatus, changedBy string) error {
+	var id, total int
+	var from OrderStatus
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, status, total
+		FROM orders
+		WHERE stripe_session_id = $1
+		FOR UPDATE
+	`, sessionID).Scan(&id, &from, &total)
+	if err != nil {
+		return notFound(err)
+	}
+
+	if from == OrderStatusCanceled && to == OrderStatusPaid {
+		err := reinstateOrder(ctx, tx, id)
+		var outOfStock *OutOfStockError
+		var productNotFound *ProductNotFoundError
+		if errors.As(err, &outOfStock) || errors.As(err, &productNotFound) {
+			return &PaidAfterCancelError{OrderID: id, Total: total, Err: err}
+		}
+		if err != nil {
+			return err
+		}
+	} else if to == OrderStatusRefunded || to == OrderStatusPartiallyRefunded {
+		if !canRefundTransition(from, to) {
+			return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+		}
+	} else if !canStripeTransition(from, to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE orders
+		SET status = $1, updated_at = $2
+		WHERE id = $3
+	`, to, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	if err := settleReservation(ctx, tx, id, to); err != nil {
+		return err
+	}
+	return recordStatusChange(ctx, tx, id, from, to, changedBy)
+}
+
+// GetOrderStatusHistory returns the status changes of an order, oldest first
+func (s *PostgresOrderStore) GetOrderStatusHistory(ctx context.Context, orderID int) ([]OrderStatusChange, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, order_id, from_status, to_status, changed_by, created_at
+		FROM order_status_history
+		WHERE order_id = $1
+		ORDER BY created_at, id
+	`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []OrderStatusChange
+	for rows.Next() {
+		var c OrderStatusChange
+		if err := rows.Scan(&c.ID, &c.OrderID, &c.FromStatus, &c.ToStatus, &c.ChangedBy, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+
+	return changes, rows.Err()
+}
+
+// recordStatusChange appends a row to the order's status history
+func recordStatusChange(ctx context.Context, tx *sql.Tx, orderID int, from, to OrderStatus, changedBy string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO order_status_history (order_id, from_status, to_status, changed_by, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, orderID, from, to, changedBy, time.Now())
+	return err
+}
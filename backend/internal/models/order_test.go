@@ -0,0 +1,121 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"github.com/your-username/your-repo/internal/database"
+)
+
+// Size of the data BenchmarkGetOrders reads pages from
+const (
+	benchOrders        = 1000
+	benchItemsPerOrder = 3
+)
+
+// BenchmarkGetOrders compares loading a page of orders with one items query
+// per order against the batched loading done by GetOrders. It needs Postgres:
+//
+//	TEST_DATABASE_URL=postgres://... go test -run '^$' -bench GetOrders ./internal/models
+func BenchmarkGetOrders(b *testing.B) {
+	db := testDB(b)
+	ctx := context.Background()
+	if err := seedBenchOrders(ctx, db, benchOrders, benchItemsPerOrder); err != nil {
+		b.Fatalf("seed orders: %v", err)
+	}
+
+	b.Run("per-order", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := getOrdersPerOrder(ctx, db, MaxPageLimit); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("batched", func(b *testing.B) {
+		store := NewPostgresOrderStore(db)
+		page := Page{Limit: MaxPageLimit}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, _, err := store.GetOrders(ctx, OrderFilter{}, page); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// seedBenchOrders creates a user and orders, each with the given number of
+// items
+func seedBenchOrders(ctx context.Context, db *database.DB, orders, items int) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO users (clerk_id, email, name) VALUES ('bench', 'bench@example.com', 'Bench');
+		INSERT INTO products (name, price) SELECT 'Product ' || n, n * 100 FROM generate_series(1, 20) n;
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO orders (user_id, status, total, created_at)
+		SELECT (SELECT id FROM users), 'paid', 0, NOW() - n * INTERVAL '1 minute'
+		FROM generate_series(1, $1) n
+	`, orders)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO order_items (order_id, product_id, quantity, price)
+		SELECT o.id, p.id, 1, p.price
+		FROM orders o
+		CROSS JOIN generate_series(1, $1) n
+		JOIN products p ON p.id = (SELECT MIN(id) FROM products) + (o.id + n) % 20
+	`, items)
+	return err
+}
+
+// getOrdersPerOrder loads a page of orders the way GetOrders used to, with
+// one items query per order
+func getOrdersPerOrder(ctx context.Context, db *database.DB, limit int) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, user_id, status, total, stripe_session_id, created_at, updated_at
+		FROM orders
+		ORDER BY created_at DESC, id DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o Order
+		if err := rows.Scan(&o.ID, &o.UserID, &o.Status, &o.Total, &o.StripeSessionID, &o.CreatedAt, &o.UpdatedAt); err != nil {
+			return err
+		}
+
+		items, err := db.QueryContext(ctx, `
+			SELECT id, order_id, product_id, quantity, price, created_at, updated_at
+			FROM order_items
+			WHERE order_id = $1
+		`, o.ID)
+		if err != nil {
+			return err
+		}
+		for items.Next() {
+			var i OrderItem
+			if err := items.Scan(&i.ID, &i.OrderID, &i.ProductID, &i.Quantity, &i.Price, &i.CreatedAt, &i.UpdatedAt); err != nil {
+				items.Close()
+				return err
+			}
+			o.Items = append(o.Items, i)
+		}
+		items.Close()
+		if err := items.Err(); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/your-username/your-repo/internal/database"
+	"github.com/your-username/your-repo/internal/migrations"
+)
+
+// testSchemas numbers the scratch schemas created by this test process
+var testSchemas int64
+
+// testDB returns a connection to a scratch schema, migrated to the latest
+// version, in the database at TEST_DATABASE_URL. The schema is dropped when
+// the test ends. Tests that need Postgres are skipped if it is not set.
+func testDB(tb testing.TB) *database.DB {
+	tb.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		tb.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+
+	admin, err := database.New(ctx, url, 10*time.Second)
+	if err != nil {
+		tb.Fatalf("connect to test database: %v", err)
+	}
+	tb.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("test_%d_%d", os.Getpid(), atomic.AddInt64(&testSchemas, 1))
+	if _, err := admin.ExecContext(ctx, `CREATE SCHEMA `+schema); err != nil {
+		tb.Fatalf("create schema: %v", err)
+	}
+	tb.Cleanup(func() {
+		if _, err := admin.ExecContext(context.Background(), `DROP SCHEMA `+schema+` CASCADE`); err != nil {
+			tb.Errorf("drop schema %s: %v", schema, err)
+		}
+	})
+
+	db, err := database.New(ctx, withSearchPath(url, schema), 10*time.Second)
+	if err != nil {
+		tb.Fatalf("connect to test schema: %v", err)
+	}
+	tb.Cleanup(func() { db.Close() })
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		tb.Fatalf("load migrations: %v", err)
+	}
+	if err := migrator.Up(ctx); err != nil {
+		tb.Fatalf("migrate test schema: %v", err)
+	}
+
+	return db
+}
+
+// withSearchPath adds a search_path run-time parameter to a connection string
+func withSearchPath(url, schema string) string {
+	if !strings.Contains(url, "://") {
+		return url + " search_path=" + schema
+	}
+	if strings.Contains(url, "?") {
+		return url + "&search_path=" + schema
+	}
+	return url + "?search_path=" + schema
+}
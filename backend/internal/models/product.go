@@ -1,90 +1,184 @@
 package models
 
 import (
+	"context"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/your-username/your-repo/internal/database"
 )
 
 // Product represents a product in the system
 type Product struct {
-	ID             int       `json:"id"`
-	Name           string    `json:"name"`
-	Description    string    `json:"description"`
-	Price          int       `json:"price"` // Price in cents
+	ID              int       `json:"id"`
+	Name            string    `json:"name"`
+	Description     string    `json:"description"`
+	Price           int       `json:"price"` // Price in cents
+	Stock           *int      `json:"stock"` // Units available, nil if not tracked
 	StripeProductID string    `json:"stripe_product_id,omitempty"`
-	StripePriceID  string    `json:"stripe_price_id,omitempty"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
+	StripePriceID   string    `json:"stripe_price_id,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
 }
 
-// GetProducts returns all products
-func GetProducts(db *database.DB) ([]Product, error) {
-	rows, err := db.Query(`
-		SELECT id, name, description, price, stripe_product_id, stripe_price_id, created_at, updated_at
-		FROM products
-		ORDER BY name
-	`)
+// ProductFilter narrows a list of products. Nil bounds are not applied.
+type ProductFilter struct {
+	MinPrice *int
+	MaxPrice *int
+}
+
+// matches reports whether p passes the filter
+func (f ProductFilter) matches(p Product) bool {
+	return (f.MinPrice == nil || p.Price >= *f.MinPrice) && (f.MaxPrice == nil || p.Price <= *f.MaxPrice)
+}
+
+// productSorts are the fields products can be sorted by
+var productSorts = map[string]sortField[Product]{
+	"name":       {column: "name", sqlType: "text", key: func(p Product) interface{} { return p.Name }},
+	"price":      {column: "price", sqlType: "integer", key: func(p Product) interface{} { return p.Price }},
+	"created_at": {column: "created_at", sqlType: "timestamp", key: func(p Product) interface{} { return p.CreatedAt }},
+}
+
+// productID returns the ID of a product, to break ties between sort values
+func productID(p Product) int { return p.ID }
+
+// PostgresProductStore implements ProductStore on top of Postgres
+type PostgresProductStore struct {
+	db *database.DB
+}
+
+// NewPostgresProductStore creates a new PostgresProductStore
+func NewPostgresProductStore(db *database.DB) *PostgresProductStore {
+	return &PostgresProductStore{db: db}
+}
+
+// GetProducts returns a page of the products matching filter, ordered by
+// name by default, and the cursor of the next page
+func (s *PostgresProductStore) GetProducts(ctx context.Context, filter ProductFilter, page Page) ([]Product, string, error) {
+	order, err := resolveSort(page.Sort, "name", productSorts)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	var q listQuery
+	if filter.MinPrice != nil {
+		q.where("price >= " + q.arg(*filter.MinPrice))
+	}
+	if filter.MaxPrice != nil {
+		q.where("price <= " + q.arg(*filter.MaxPrice))
+	}
+	tail, err := paginate(&q, page, order)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, name, description, price, stock, stripe_product_id, stripe_price_id, created_at, updated_at
+		FROM products`+q.whereClause()+tail, q.args...)
+	if err != nil {
+		return nil, "", err
 	}
 	defer rows.Close()
 
 	var products []Product
 	for rows.Next() {
 		var p Product
-		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.StripeProductID, &p.StripePriceID, &p.CreatedAt, &p.UpdatedAt); err != nil {
-			return nil, err
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.StripeProductID, &p.StripePriceID, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, "", err
 		}
 		products = append(products, p)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
 
-	return products, nil
+	products, next := trimPage(products, page, order, productID)
+	return products, next, nil
 }
 
 // GetProductByID returns a product by ID
-func GetProductByID(db *database.DB, id int) (*Product, error) {
+func (s *PostgresProductStore) GetProductByID(ctx context.Context, id int) (*Product, error) {
 	var p Product
-	err := db.QueryRow(`
-		SELECT id, name, description, price, stripe_product_id, stripe_price_id, created_at, updated_at
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, name, description, price, stock, stripe_product_id, stripe_price_id, created_at, updated_at
 		FROM products
 		WHERE id = $1
-	`, id).Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.StripeProductID, &p.StripePriceID, &p.CreatedAt, &p.UpdatedAt)
+	`, id).Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.StripeProductID, &p.StripePriceID, &p.CreatedAt, &p.UpdatedAt)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 
 	return &p, nil
 }
 
+// GetProductsByIDs returns the products with the given IDs, keyed by ID
+func (s *PostgresProductStore) GetProductsByIDs(ctx context.Context, ids []int) (map[int]Product, error) {
+	idArray := make([]int64, len(ids))
+	for i, id := range ids {
+		idArray[i] = int64(id)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, name, description, price, stock, stripe_product_id, stripe_price_id, created_at, updated_at
+		FROM products
+		WHERE id = ANY($1)
+	`, pq.Array(idArray))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := make(map[int]Product, len(ids))
+	for rows.Next() {
+		var p Product
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.StripeProductID, &p.StripePriceID, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		products[p.ID] = p
+	}
+
+	return products, rows.Err()
+}
+
 // CreateProduct creates a new product
-func CreateProduct(db *database.DB, p *Product) error {
+func (s *PostgresProductStore) CreateProduct(ctx context.Context, p *Product) error {
 	now := time.Now()
 	p.CreatedAt = now
 	p.UpdatedAt = now
 
-	return db.QueryRow(`
-		INSERT INTO products (name, description, price, stripe_product_id, stripe_price_id, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO products (name, description, price, stock, stripe_product_id, stripe_price_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		RETURNING id
-	`, p.Name, p.Description, p.Price, p.StripeProductID, p.StripePriceID, p.CreatedAt, p.UpdatedAt).Scan(&p.ID)
+	`, p.Name, p.Description, p.Price, p.Stock, p.StripeProductID, p.StripePriceID, p.CreatedAt, p.UpdatedAt).Scan(&p.ID)
 }
 
-// UpdateProduct updates a product
-func UpdateProduct(db *database.DB, p *Product) error {
+// UpdateProduct updates a product, except for its stock, which is only
+// changed by orders, AdjustProductStock and TrackProductStock. p.Stock is
+// set to the current stock.
+func (s *PostgresProductStore) UpdateProduct(ctx context.Context, p *Product) error {
 	p.UpdatedAt = time.Now()
 
-	_, err := db.Exec(`
+	err := s.db.QueryRowContext(ctx, `
 		UPDATE products
 		SET name = $1, description = $2, price = $3, stripe_product_id = $4, stripe_price_id = $5, updated_at = $6
 		WHERE id = $7
-	`, p.Name, p.Description, p.Price, p.StripeProductID, p.StripePriceID, p.UpdatedAt, p.ID)
+		RETURNING stock, created_at
+	`, p.Name, p.Description, p.Price, p.StripeProductID, p.StripePriceID, p.UpdatedAt, p.ID).Scan(&p.Stock, &p.CreatedAt)
+	return notFound(err)
+}
 
-	return err
+// SetProductStripeIDs records the Stripe Product and Price of a product
+// without touching the rest of it
+func (s *PostgresProductStore) SetProductStripeIDs(ctx context.Context, id int, productID, priceID string) error {
+	return requireRows(s.db.ExecContext(ctx, `
+		UPDATE products
+		SET stripe_product_id = $1, stripe_price_id = $2, updated_at = $3
+		WHERE id = $4
+	`, productID, priceID, time.Now(), id))
 }
 
 // DeleteProduct deletes a product
-func DeleteProduct(db *database.DB, id int) error {
-	_, err := db.Exec(`DELETE FROM products WHERE id = $1`, id)
-	return err
+func (s *PostgresProductStore) DeleteProduct(ctx context.Context, id int) error {
+	return requireRows(s.db.ExecContext(ctx, `DELETE FROM products WHERE id = $1`, id))
 }
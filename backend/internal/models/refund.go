@@ -0,0 +1,532 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// RefundStatus is the state of a refund
+type RefundStatus string
+
+// Refund statuses. Pending refunds have been recorded but Stripe has not
+// answered yet; issued refunds were accepted by Stripe and failed ones
+// rejected.
+const (
+	RefundStatusPending RefundStatus = "pending"
+	RefundStatusIssued  RefundStatus = "issued"
+	RefundStatusFailed  RefundStatus = "failed"
+)
+
+var (
+	// ErrOrderNotRefundable is returned when an order has not been paid or
+	// has already been refunded in full
+	ErrOrderNotRefundable = errors.New("order cannot be refunded in its status")
+	// ErrNothingToRefund is returned for a full refund of an order whose
+	// items have all been refunded or are being refunded, and for refunds of
+	// items that were discounted to nothing
+	ErrNothingToRefund = errors.New("order has nothing left to refund")
+	// ErrRefundKeyReused is returned when an idempotency key that created a
+	// refund is sent again for a different refund
+	ErrRefundKeyReused = errors.New("idempotency key was used for a different refund")
+)
+
+// RefundLineError is returned when a requested refund line cannot be refunded
+type RefundLineError struct {
+	// Index is the position of the line in the request
+	Index   int
+	Message string
+}
+
+func (e *RefundLineError) Error() string {
+	return fmt.Sprintf("refund line %d %s", e.Index, e.Message)
+}
+
+// Refund is money returned for some or all of an order's items
+type Refund struct {
+	ID             int          `json:"id"`
+	OrderID        int          `json:"order_id"`
+	Amount         int          `json:"amount"` // Amount in cents
+	Status         RefundStatus `json:"status"`
+	Full           bool         `json:"full"`
+	Reason         string       `json:"reason,omitempty"`
+	StripeRefundID string       `json:"stripe_refund_id,omitempty"`
+	IdempotencyKey string       `json:"-"`
+	CreatedBy      string       `json:"created_by"`
+	Items          []RefundItem `json:"items"`
+	CreatedAt      time.Time    `json:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+}
+
+// RefundItem is a quantity of an order item covered by a refund
+type RefundItem struct {
+	ID          int `json:"id"`
+	RefundID    int `json:"refund_id"`
+	OrderItemID int `json:"order_item_id"`
+	Quantity    int `json:"quantity"`
+	Amount      int `json:"amount"` // Amount in cents
+}
+
+// RefundLine asks for a quantity of an order item to be refunded
+type RefundLine struct {
+	OrderItemID int
+	Quantity    int
+}
+
+// RefundRequest describes a refund to record
+type RefundRequest struct {
+	OrderID int
+	// Lines are the items to refund; with none, everything not yet refunded is
+	Lines          []RefundLine
+	Reason         string
+	IdempotencyKey string
+	CreatedBy      string
+}
+
+// refundableStatuses are the statuses of orders that can be refunded
+var refundableStatuses = map[OrderStatus]bool{
+	OrderStatusPaid:              true,
+	OrderStatusFulfilled:         true,
+	OrderStatusShipped:           true,
+	OrderStatusDelivered:         true,
+	OrderStatusPartiallyRefunded: true,
+}
+
+// refundableItem is an order item and how much of it has been refunded
+type refundableItem struct {
+	OrderItem
+	Refunded int
+}
+
+// planRefund works out the refund items for req from an order's items
+func planRefund(req RefundRequest, items []refundableItem) ([]RefundItem, error) {
+	var planned []RefundItem
+	if len(req.Lines) == 0 {
+		for _, item := range items {
+			if left := item.Quantity - item.Refunded; left > 0 {
+				planned = append(planned, RefundItem{OrderItemID: item.ID, Quantity: left, Amount: item.refundAmount(item.Refunded, left)})
+			}
+		}
+		return planned, checkRefundAmount(planned)
+	}
+
+	byID := make(map[int]refundableItem, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+	requested := make(map[int]int, len(req.Lines))
+	for i, line := range req.Lines {
+		item, ok := byID[line.OrderItemID]
+		if !ok {
+			return nil, &RefundLineError{Index: i, Message: "is not an item of this order"}
+		}
+		done := item.Refunded + requested[item.ID]
+		requested[item.ID] += line.Quantity
+		if left := item.Quantity - item.Refunded; requested[item.ID] > left {
+			return nil, &RefundLineError{Index: i, Message: fmt.Sprintf("has only %d left to refund", left)}
+		}
+		planned = append(planned, RefundItem{OrderItemID: item.ID, Quantity: line.Quantity, Amount: item.refundAmount(done, line.Quantity)})
+	}
+	return planned, checkRefundAmount(planned)
+}
+
+// refundAmount returns what refunding quantity units of the item pays back
+// once done units have been refunded. The item's discount is spread over its
+// units, with the cents lost to rounding refunded with the last unit, so that
+// refunding every unit pays back exactly what was paid for the line.
+func (item refundableItem) refundAmount(done, quantity int) int {
+	paid := func(units int) int {
+		return units*item.Price - item.Discount*units/item.Quantity
+	}
+	return paid(done+quantity) - paid(done)
+}
+
+// checkRefundAmount returns ErrNothingToRefund if refunding items would pay
+// nothing back, as when they were discounted to nothing
+func checkRefundAmount(items []RefundItem) error {
+	for _, item := range items {
+		if item.Amount > 0 {
+			return nil
+		}
+	}
+	return ErrNothingToRefund
+}
+
+// sameRefund reports whether req asks for the refund r recorded
+func sameRefund(r *Refund, req RefundRequest) bool {
+	if r.OrderID != req.OrderID || r.Full != (len(req.Lines) == 0) {
+		return false
+	}
+	if r.Full {
+		return true
+	}
+	if len(r.Items) != len(req.Lines) {
+		return false
+	}
+	for i, line := range req.Lines {
+		if r.Items[i].OrderItemID != line.OrderItemID || r.Items[i].Quantity != line.Quantity {
+			return false
+		}
+	}
+	return true
+}
+
+// BeginRefund records a pending refund of an order, to be sent to Stripe and
+// then completed with CompleteRefund or FailRefund. The lines are checked
+// against what is left to refund, counting refunds still pending, with the
+// order locked so that concurrent refunds cannot overlap.
+//
+// If a refund was already recorded with req's idempotency key, it is
+// returned instead, with created false, or ErrRefundKeyReused if it was for
+// a different refund.
+func (s *PostgresOrderStore) BeginRefund(ctx context.Context, req RefundRequest) (refund *Refund, created bool, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	var status OrderStatus
+	err = tx.QueryRowContext(ctx, `SELECT status FROM orders WHERE id = $1 FOR UPDATE`, req.OrderID).Scan(&status)
+	if err != nil {
+		return nil, false, notFound(err)
+	}
+
+	var existingID int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM refunds WHERE idempotency_key = $1`, req.IdempotencyKey).Scan(&existingID)
+	switch {
+	case err == nil:
+		existing, err := getRefund(ctx, tx, existingID)
+		if err != nil {
+			return nil, false, err
+		}
+		if !sameRefund(existing, req) {
+			return nil, false, ErrRefundKeyReused
+		}
+		return existing, false, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, false, err
+	}
+
+	if !refundableStatuses[status] {
+		return nil, false, fmt.Errorf("%w: %s", ErrOrderNotRefundable, status)
+	}
+
+	items, err := refundableItems(ctx, tx, req.OrderID)
+	if err != nil {
+		return nil, false, err
+	}
+	planned, err := planRefund(req, items)
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now()
+	refund = &Refund{
+		OrderID:        req.OrderID,
+		Status:         RefundStatusPending,
+		Full:           len(req.Lines) == 0,
+		Reason:         req.Reason,
+		IdempotencyKey: req.IdempotencyKey,
+		CreatedBy:      req.CreatedBy,
+		Items:          planned,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	for _, item := range planned {
+		refund.Amount += item.Amount
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO refunds (order_id, amount, status, full_refund, reason, idempotency_key, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		RETURNING id
+	`, refund.OrderID, refund.Amount, refund.Status, refund.Full, refund.Reason, refund.IdempotencyKey, refund.CreatedBy, now).Scan(&refund.ID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for i := range refund.Items {
+		item := &refund.Items[i]
+		item.RefundID = refund.ID
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO refund_items (refund_id, order_item_id, quantity, amount)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id
+		`, item.RefundID, item.OrderItemID, item.Quantity, item.Amount).Scan(&item.ID)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	return refund, true, tx.Commit()
+}
+
+// refundableItems returns an order's items with the quantity of each that
+// has been refunded or is being refunded
+func refundableItems(ctx context.Context, tx *sql.Tx, orderID int) ([]refundableItem, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT oi.id, oi.product_id, oi.quantity, oi.price, oi.discount,
+			COALESCE(SUM(ri.quantity) FILTER (WHERE r.status <> $2), 0)
+		FROM order_items oi
+		LEFT JOIN refund_items ri ON ri.order_item_id = oi.id
+		LEFT JOIN refunds r ON r.id = ri.refund_id
+		WHERE oi.order_id = $1
+		GROUP BY oi.id
+		ORDER BY oi.id
+	`, orderID, RefundStatusFailed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []refundableItem
+	for rows.Next() {
+		item := refundableItem{OrderItem: OrderItem{OrderID: orderID}}
+		if err := rows.Scan(&item.ID, &item.ProductID, &item.Quantity, &item.Price, &item.Discount, &item.Refunded); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// CompleteRefund marks a pending refund as issued by Stripe, restocks the
+// refunded items and moves the order to refunded, once every item has been
+// refunded, or partially_refunded. Refunds that are no longer pending are
+// returned unchanged.
+func (s *PostgresOrderStore) CompleteRefund(ctx context.Context, id int, stripeRefundID string) (*Refund, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the order before the refund, in the same order as BeginRefund
+	var orderID int
+	var status OrderStatus
+	err = tx.QueryRowContext(ctx, `
+		SELECT o.id, o.status
+		FROM orders o
+		JOIN refunds r ON r.order_id = o.id
+		WHERE r.id = $1
+		FOR UPDATE OF o
+	`, id).Scan(&orderID, &status)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE refunds
+		SET status = $1, stripe_refund_id = $2, updated_at = $3
+		WHERE id = $4 AND status = $5
+	`, RefundStatusIssued, stripeRefundID, time.Now(), id, RefundStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return getRefund(ctx, tx, id)
+	}
+
+	if err := restockRefund(ctx, tx, id); err != nil {
+		return nil, err
+	}
+
+	var left int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(oi.quantity), 0) - COALESCE((
+			SELECT SUM(ri.quantity)
+			FROM refund_items ri
+			JOIN refunds r ON r.id = ri.refund_id
+			WHERE r.order_id = $1 AND r.status = $2
+		), 0)
+		FROM order_items oi
+		WHERE oi.order_id = $1
+	`, orderID, RefundStatusIssued).Scan(&left)
+	if err != nil {
+		return nil, err
+	}
+
+	to := OrderStatusPartiallyRefunded
+	if left <= 0 {
+		to = OrderStatusRefunded
+	}
+
+	refund, err := getRefund(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// A charge.refunded webhook may have marked the order refunded already
+	if canRefundTransition(status, to) {
+		_, err = tx.ExecContext(ctx, `UPDATE orders SET status = $1, updated_at = $2 WHERE id = $3`, to, time.Now(), orderID)
+		if err != nil {
+			return nil, err
+		}
+		if err := recordStatusChange(ctx, tx, orderID, status, to, refund.CreatedBy); err != nil {
+			return nil, err
+		}
+	}
+
+	return refund, tx.Commit()
+}
+
+// restockRefund returns the refunded quantities to the products' stock, in
+// product ID order so that it cannot deadlock with CreateOrder
+func restockRefund(ctx context.Context, tx *sql.Tx, refundID int) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT oi.product_id, SUM(ri.quantity)
+		FROM refund_items ri
+		JOIN order_items oi ON oi.id = ri.order_item_id
+		WHERE ri.refund_id = $1
+		GROUP BY oi.product_id
+		ORDER BY oi.product_id
+	`, refundID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type restock struct{ productID, quantity int }
+	var restocks []restock
+	for rows.Next() {
+		var r restock
+		if err := rows.Scan(&r.productID, &r.quantity); err != nil {
+			return err
+		}
+		restocks = append(restocks, r)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, r := range restocks {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE products
+			SET stock = stock + $1
+			WHERE id = $2 AND stock IS NOT NULL
+		`, r.quantity, r.productID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FailRefund marks a pending refund as rejected by Stripe, so that its items
+// can be refunded again
+func (s *PostgresOrderStore) FailRefund(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE refunds
+		SET status = $1, updated_at = $2
+		WHERE id = $3 AND status = $4
+	`, RefundStatusFailed, time.Now(), id, RefundStatusPending)
+	return err
+}
+
+// GetRefunds returns the refunds of an order, oldest first
+func (s *PostgresOrderStore) GetRefunds(ctx context.Context, orderID int) ([]Refund, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+refundColumns+`
+		FROM refunds
+		WHERE order_id = $1
+		ORDER BY created_at, id
+	`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refunds []Refund
+	for rows.Next() {
+		var r Refund
+		if err := scanRefund(rows, &r); err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	ptrs := make([]*Refund, len(refunds))
+	for i := range refunds {
+		ptrs[i] = &refunds[i]
+	}
+	if err := loadRefundItems(ctx, s.db, ptrs...); err != nil {
+		return nil, err
+	}
+
+	return refunds, nil
+}
+
+// refundColumns are the columns scanRefund reads
+const refundColumns = `id, order_id, amount, status, full_refund, reason, stripe_refund_id, idempotency_key, created_by, created_at, updated_at`
+
+// scanRefund scans refundColumns into r
+func scanRefund(row interface{ Scan(...interface{}) error }, r *Refund) error {
+	return row.Scan(&r.ID, &r.OrderID, &r.Amount, &r.Status, &r.Full, &r.Reason, &r.StripeRefundID, &r.IdempotencyKey, &r.CreatedBy, &r.CreatedAt, &r.UpdatedAt)
+}
+
+// getRefund returns a refund and its items within tx
+func getRefund(ctx context.Context, tx *sql.Tx, id int) (*Refund, error) {
+	var r Refund
+	err := scanRefund(tx.QueryRowContext(ctx, `SELECT `+refundColumns+` FROM refunds WHERE id = $1`, id), &r)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if err := loadRefundItems(ctx, tx, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// loadRefundItems sets the items of every given refund with a single query
+func loadRefundItems(ctx context.Context, q interface {
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+}, refunds ...*Refund) error {
+	if len(refunds) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*Refund, len(refunds))
+	ids := make([]int64, len(refunds))
+	for i, r := range refunds {
+		r.Items = []RefundItem{}
+		byID[r.ID] = r
+		ids[i] = int64(r.ID)
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, refund_id, order_item_id, quantity, amount
+		FROM refund_items
+		WHERE refund_id = ANY($1)
+		ORDER BY refund_id, id
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item RefundItem
+		if err := rows.Scan(&item.ID, &item.RefundID, &item.OrderItemID, &item.Quantity, &item.Amount); err != nil {
+			return err
+		}
+		r := byID[item.RefundID]
+		r.Items = append(r.Items, item)
+	}
+
+	return rows.Err()
+}
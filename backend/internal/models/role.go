@@ -0,0 +1,164 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Role is the access level of a user. Each role includes everything the
+// roles below it may do.
+type Role string
+
+// Roles, from least to most privileged
+const (
+	RoleCustomer Role = "customer"
+	RoleStaff    Role = "staff"
+	RoleAdmin    Role = "admin"
+)
+
+// roleRanks orders the roles by privilege
+var roleRanks = map[Role]int{
+	RoleCustomer: 1,
+	RoleStaff:    2,
+	RoleAdmin:    3,
+}
+
+// ErrRoleConflict is returned when a user no longer has the role a role
+// change expected them to have
+var ErrRoleConflict = errors.New("user role has changed")
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// AtLeast reports whether r is min or a more privileged role
+func (r Role) AtLeast(min Role) bool {
+	return r.Valid() && roleRanks[r] >= roleRanks[min]
+}
+
+// RoleChange is an entry in the audit trail of user role changes
+type RoleChange struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	FromRole  Role      `json:"from_role"`
+	ToRole    Role      `json:"to_role"`
+	ChangedBy string    `json:"changed_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SetUserRole changes a user's role and records the change. The update only
+// applies if the user still has the from role, so concurrent changes cannot
+// overwrite each other; the loser gets ErrRoleConflict. changedBy identifies
+// who made the change.
+func (s *PostgresUserStore) SetUserRole(ctx context.Context, id int, from, to Role, changedBy string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE users
+		SET role = $1, updated_at = $2
+		WHERE id = $3 AND role = $4
+	`, to, time.Now(), id, from)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, id).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotFound
+		}
+		return ErrRoleConflict
+	}
+
+	if err := recordRoleChange(ctx, tx, id, from, to, changedBy); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SeedAdmin makes the user with the given Clerk ID an admin if there are no
+// admins yet, and reports whether it did
+func (s *PostgresUserStore) SeedAdmin(ctx context.Context, clerkID string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	var from Role
+	err = tx.QueryRowContext(ctx, `
+		WITH target AS (
+			SELECT id, role FROM users WHERE clerk_id = $1 FOR UPDATE
+		)
+		UPDATE users u
+		SET role = 'admin', updated_at = $2
+		FROM target
+		WHERE u.id = target.id
+			AND u.role <> 'admin'
+			AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin')
+		RETURNING u.id, target.role
+	`, clerkID, time.Now()).Scan(&id, &from)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := recordRoleChange(ctx, tx, id, from, RoleAdmin, "system:seed"); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// GetRoleChanges returns the role changes of a user, oldest first
+func (s *PostgresUserStore) GetRoleChanges(ctx context.Context, userID int) ([]RoleChange, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, from_role, to_role, changed_by, created_at
+		FROM user_role_changes
+		WHERE user_id = $1
+		ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []RoleChange
+	for rows.Next() {
+		var c RoleChange
+		if err := rows.Scan(&c.ID, &c.UserID, &c.FromRole, &c.ToRole, &c.ChangedBy, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+
+	return changes, rows.Err()
+}
+
+// recordRoleChange appends a row to the role change audit trail
+func recordRoleChange(ctx context.Context, tx *sql.Tx, userID int, from, to Role, changedBy string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO user_role_changes (user_id, from_role, to_role, changed_by, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, userID, from, to, changedBy, time.Now())
+	return err
+}
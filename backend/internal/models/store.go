@@ -0,0 +1,110 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// UserStore provides access to users
+type UserStore interface {
+	GetUsers(ctx context.Context, filter UserFilter, page Page) ([]User, string, error)
+	GetUserByID(ctx context.Context, id int) (*User, error)
+	GetUserByClerkID(ctx context.Context, clerkID string) (*User, error)
+	CreateUser(ctx context.Context, u *User) error
+	ProvisionUser(ctx context.Context, u *User) error
+	UpdateUser(ctx context.Context, u *User) error
+	DeleteUser(ctx context.Context, id int) error
+	SetUserRole(ctx context.Context, id int, from, to Role, changedBy string) error
+	SeedAdmin(ctx context.Context, clerkID string) (bool, error)
+	GetRoleChanges(ctx context.Context, userID int) ([]RoleChange, error)
+	UpsertClerkUser(ctx context.Context, u *User, syncedAt time.Time) (bool, error)
+	DeleteClerkUser(ctx context.Context, clerkID string, deletedAt time.Time) (bool, error)
+}
+
+// ProductStore provides access to products
+type ProductStore interface {
+	GetProducts(ctx context.Context, filter ProductFilter, page Page) ([]Product, string, error)
+	GetProductByID(ctx context.Context, id int) (*Product, error)
+	GetProductsByIDs(ctx context.Context, ids []int) (map[int]Product, error)
+	CreateProduct(ctx context.Context, p *Product) error
+	UpdateProduct(ctx context.Context, p *Product) error
+	AdjustProductStock(ctx context.Context, id, delta int) (int, error)
+	TrackProductStock(ctx context.Context, id, stock int) error
+	SetProductStripeIDs(ctx context.Context, id int, productID, priceID string) error
+	DeleteProduct(ctx context.Context, id int) error
+}
+
+// OrderStore provides access to orders and their items
+type OrderStore interface {
+	GetOrders(ctx context.Context, filter OrderFilter, page Page) ([]Order, string, error)
+	GetOrderByID(ctx context.Context, id int) (*Order, error)
+	GetOrderByStripeSessionID(ctx context.Context, sessionID string) (*Order, error)
+	CreateOrder(ctx context.Context, o *Order) error
+	SetOrderStripeSessionID(ctx context.Context, id int, sessionID string) error
+	TransitionOrder(ctx context.Context, id int, from, to OrderStatus, changedBy string) error
+	GetOrderStatusHistory(ctx context.Context, orderID int) ([]OrderStatusChange, error)
+	ExpireReservations(ctx context.Context, now time.Time) (int, error)
+	DeleteOrder(ctx context.Context, id int) error
+}
+
+// RefundStore provides access to the refunds of orders
+type RefundStore interface {
+	BeginRefund(ctx context.Context, req RefundRequest) (*Refund, bool, error)
+	CompleteRefund(ctx context.Context, id int, stripeRefundID string) (*Refund, error)
+	FailRefund(ctx context.Context, id int) error
+	GetRefunds(ctx context.Context, orderID int) ([]Refund, error)
+}
+
+// IdempotencyStore records Idempotency-Keys and the responses to the
+// requests that sent them
+type IdempotencyStore interface {
+	BeginIdempotentRequest(ctx context.Context, req IdempotentRequest) (*IdempotentResponse, error)
+	CompleteIdempotentRequest(ctx context.Context, scope, key string, resp IdempotentResponse) error
+	ReleaseIdempotentRequest(ctx context.Context, scope, key string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error)
+}
+
+// CartStore provides access to shopping carts
+type CartStore interface {
+	GetCart(ctx context.Context, owner CartOwner) (*Cart, error)
+	ProvisionCart(ctx context.Context, owner CartOwner) (*Cart, error)
+	AddCartItem(ctx context.Context, cartID, productID, quantity int) error
+	SetCartItemQuantity(ctx context.Context, cartID, itemID, quantity int) error
+	RemoveCartItem(ctx context.Context, cartID, itemID int) error
+	ClearCart(ctx context.Context, cartID int) error
+	MergeCart(ctx context.Context, token string, userID int) error
+	ConvertCart(ctx context.Context, cartID int, o *Order) error
+	DeleteStaleCarts(ctx context.Context, before time.Time) (int, error)
+}
+
+// CouponStore provides access to coupon codes
+type CouponStore interface {
+	GetCoupons(ctx context.Context) ([]Coupon, error)
+	GetCouponByID(ctx context.Context, id int) (*Coupon, error)
+	CreateCoupon(ctx context.Context, c *Coupon) error
+	UpdateCoupon(ctx context.Context, c *Coupon) error
+	DeleteCoupon(ctx context.Context, id int) error
+}
+
+// StripeEventStore records the Stripe events that have been processed
+type StripeEventStore interface {
+	ProcessStripeEvent(ctx context.Context, eventID, eventType string, fn func(tx StripeEventTx) error) (bool, error)
+}
+
+// StripeEventTx makes the order changes of a Stripe event, atomically with
+// recording the event
+type StripeEventTx interface {
+	TransitionOrderBySessionID(ctx context.Context, sessionID string, to OrderStatus, changedBy string) error
+	SetReservationBySessionID(ctx context.Context, sessionID string, until time.Time) error
+}
+
+var (
+	_ UserStore        = (*PostgresUserStore)(nil)
+	_ ProductStore     = (*PostgresProductStore)(nil)
+	_ OrderStore       = (*PostgresOrderStore)(nil)
+	_ RefundStore      = (*PostgresOrderStore)(nil)
+	_ IdempotencyStore = (*PostgresIdempotencyStore)(nil)
+	_ CartStore        = (*PostgresCartStore)(nil)
+	_ CouponStore      = (*PostgresCouponStore)(nil)
+	_ StripeEventStore = (*PostgresStripeEventStore)(nil)
+)
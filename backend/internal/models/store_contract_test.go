@@ -0,0 +1,1026 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// storeSet is one implementation of every store, over the same data
+type storeSet struct {
+	users       UserStore
+	products    ProductStore
+	orders      OrderStore
+	refunds     RefundStore
+	idempotency IdempotencyStore
+	carts       CartStore
+	coupons     CouponStore
+	events      StripeEventStore
+}
+
+// newMemoryStores returns a storeSet backed by a new MemoryStore
+func newMemoryStores(t *testing.T) storeSet {
+	m := NewMemoryStore()
+	return storeSet{users: m, products: m, orders: m, refunds: m, idempotency: m, carts: m, coupons: m, events: m}
+}
+
+// newPostgresStores returns a storeSet backed by a scratch Postgres schema,
+// skipping the test if TEST_DATABASE_URL is not set
+func newPostgresStores(t *testing.T) storeSet {
+	db := testDB(t)
+	orders := NewPostgresOrderStore(db)
+	return storeSet{
+		users:       NewPostgresUserStore(db),
+		products:    NewPostgresProductStore(db),
+		orders:      orders,
+		refunds:     orders,
+		idempotency: NewPostgresIdempotencyStore(db),
+		carts:       NewPostgresCartStore(db),
+		coupons:     NewPostgresCouponStore(db),
+		events:      NewPostgresStripeEventStore(db),
+	}
+}
+
+// storeContracts are the behaviors MemoryStore must share with the Postgres
+// stores, so that tests run against it hold for production
+var storeContracts = []struct {
+	name string
+	test func(t *testing.T, s storeSet)
+}{
+	{"Users", testUsersContract},
+	{"UserRoles", testUserRolesContract},
+	{"ClerkSync", testClerkSyncContract},
+	{"ProductStock", testProductStockContract},
+	{"ProductList", testProductListContract},
+	{"CreateOrder", testCreateOrderContract},
+	{"TransitionOrder", testTransitionOrderContract},
+	{"ExpireReservations", testExpireReservationsContract},
+	{"StripeEvents", testStripeEventsContract},
+	{"Refunds", testRefundsContract},
+	{"Idempotency", testIdempotencyContract},
+	{"Carts", testCartsContract},
+	{"Coupons", testCouponsContract},
+}
+
+func TestMemoryStoreContract(t *testing.T) {
+	runStoreContracts(t, newMemoryStores)
+}
+
+func TestPostgresStoreContract(t *testing.T) {
+	runStoreContracts(t, newPostgresStores)
+}
+
+// runStoreContracts runs each contract against a new storeSet
+func runStoreContracts(t *testing.T, newStores func(t *testing.T) storeSet) {
+	for _, c := range storeContracts {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.test(t, newStores(t))
+		})
+	}
+}
+
+// intPtr returns a pointer to n
+func intPtr(n int) *int {
+	return &n
+}
+
+// mustCreateUser creates a customer with the given Clerk ID
+func mustCreateUser(t *testing.T, s storeSet, clerkID string) *User {
+	t.Helper()
+	u := &User{ClerkID: clerkID, Email: clerkID + "@example.com", Name: clerkID}
+	if err := s.users.CreateUser(context.Background(), u); err != nil {
+		t.Fatalf("CreateUser(%s): %v", clerkID, err)
+	}
+	return u
+}
+
+// mustCreateProduct creates a product with the given price and stock, nil
+// if its stock is not tracked
+func mustCreateProduct(t *testing.T, s storeSet, name string, price int, stock *int) *Product {
+	t.Helper()
+	p := &Product{Name: name, Description: name, Price: price, Stock: stock}
+	if err := s.products.CreateProduct(context.Background(), p); err != nil {
+		t.Fatalf("CreateProduct(%s): %v", name, err)
+	}
+	return p
+}
+
+// mustCreateOrder creates a pending order of items for a user
+func mustCreateOrder(t *testing.T, s storeSet, userID int, items ...OrderItem) *Order {
+	t.Helper()
+	o := &Order{UserID: userID, Status: OrderStatusPending, Items: items}
+	if err := s.orders.CreateOrder(context.Background(), o); err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	return o
+}
+
+// stockOf returns the stock of a product, or -1 if it is not tracked
+func stockOf(t *testing.T, s storeSet, productID int) int {
+	t.Helper()
+	p, err := s.products.GetProductByID(context.Background(), productID)
+	if err != nil {
+		t.Fatalf("GetProductByID(%d): %v", productID, err)
+	}
+	if p.Stock == nil {
+		return -1
+	}
+	return *p.Stock
+}
+
+// statusOf returns the status of an order
+func statusOf(t *testing.T, s storeSet, orderID int) OrderStatus {
+	t.Helper()
+	o, err := s.orders.GetOrderByID(context.Background(), orderID)
+	if err != nil {
+		t.Fatalf("GetOrderByID(%d): %v", orderID, err)
+	}
+	return o.Status
+}
+
+func testUsersContract(t *testing.T, s storeSet) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "user_1")
+	if u.ID == 0 || u.Role != RoleCustomer {
+		t.Errorf("created user = %+v, want an ID and the customer role", u)
+	}
+
+	got, err := s.users.GetUserByClerkID(ctx, "user_1")
+	if err != nil {
+		t.Fatalf("GetUserByClerkID: %v", err)
+	}
+	if got.ID != u.ID || got.Email != "user_1@example.com" {
+		t.Errorf("GetUserByClerkID = %+v", got)
+	}
+
+	update := &User{ID: u.ID, Email: "new@example.com", Name: "New"}
+	if err := s.users.UpdateUser(ctx, update); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if update.ClerkID != "user_1" || update.Role != RoleCustomer {
+		t.Errorf("UpdateUser filled in %+v, want the stored Clerk ID and role", update)
+	}
+	got, err = s.users.GetUserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if got.Email != "new@example.com" || got.Name != "New" {
+		t.Errorf("updated user = %+v", got)
+	}
+
+	provisioned := &User{ClerkID: "user_1", Email: "other@example.com"}
+	if err := s.users.ProvisionUser(ctx, provisioned); err != nil {
+		t.Fatalf("ProvisionUser: %v", err)
+	}
+	if provisioned.ID != u.ID || provisioned.Email != "new@example.com" {
+		t.Errorf("ProvisionUser of an existing user = %+v, want the stored user", provisioned)
+	}
+
+	if err := s.users.DeleteUser(ctx, u.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := s.users.GetUserByID(ctx, u.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetUserByID after delete error = %v, want ErrNotFound", err)
+	}
+	if err := s.users.DeleteUser(ctx, u.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteUser of a deleted user error = %v, want ErrNotFound", err)
+	}
+	if err := s.users.UpdateUser(ctx, &User{ID: u.ID}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateUser of a deleted user error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.users.GetUserByClerkID(ctx, "user_unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetUserByClerkID of an unknown user error = %v, want ErrNotFound", err)
+	}
+}
+
+func testUserRolesContract(t *testing.T, s storeSet) {
+	ctx := context.Background()
+	first := mustCreateUser(t, s, "user_1")
+	second := mustCreateUser(t, s, "user_2")
+
+	seeded, err := s.users.SeedAdmin(ctx, "user_1")
+	if err != nil || !seeded {
+		t.Fatalf("SeedAdmin = %v, %v, want true", seeded, err)
+	}
+	seeded, err = s.users.SeedAdmin(ctx, "user_2")
+	if err != nil || seeded {
+		t.Errorf("SeedAdmin with an admin already = %v, %v, want false", seeded, err)
+	}
+
+	if err := s.users.SetUserRole(ctx, second.ID, RoleAdmin, RoleStaff, "user_1"); !errors.Is(err, ErrRoleConflict) {
+		t.Errorf("SetUserRole from the wrong role error = %v, want ErrRoleConflict", err)
+	}
+	if err := s.users.SetUserRole(ctx, second.ID, RoleCustomer, RoleStaff, "user_1"); err != nil {
+		t.Fatalf("SetUserRole: %v", err)
+	}
+	if err := s.users.SetUserRole(ctx, 9999, RoleCustomer, RoleStaff, "user_1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetUserRole of an unknown user error = %v, want ErrNotFound", err)
+	}
+
+	got, err := s.users.GetUserByID(ctx, second.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if got.Role != RoleStaff {
+		t.Errorf("role = %s, want %s", got.Role, RoleStaff)
+	}
+
+	changes, err := s.users.GetRoleChanges(ctx, second.ID)
+	if err != nil {
+		t.Fatalf("GetRoleChanges: %v", err)
+	}
+	if len(changes) != 1 || changes[0].FromRole != RoleCustomer || changes[0].ToRole != RoleStaff || changes[0].ChangedBy != "user_1" {
+		t.Errorf("role changes = %+v", changes)
+	}
+	if changes, err := s.users.GetRoleChanges(ctx, first.ID); err != nil || len(changes) != 1 || changes[0].ChangedBy != "system:seed" {
+		t.Errorf("role changes of the seeded admin = %+v, %v", changes, err)
+	}
+}
+
+func testClerkSyncContract(t *testing.T, s storeSet) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	u := &User{ClerkID: "user_1", Email: "a@example.com", Name: "A"}
+	if applied, err := s.users.UpsertClerkUser(ctx, u, now); err != nil || !applied {
+		t.Fatalf("UpsertClerkUser = %v, %v, want applied", applied, err)
+	}
+	if u.ID == 0 || u.Role != RoleCustomer {
+		t.Errorf("upserted user = %+v", u)
+	}
+
+	stale := &User{ClerkID: "user_1", Email: "stale@example.com"}
+	if applied, err := s.users.UpsertClerkUser(ctx, stale, now.Add(-time.Minute)); err != nil || applied {
+		t.Errorf("UpsertClerkUser of a stale event = %v, %v, want not applied", applied, err)
+	}
+
+	if applied, err := s.users.DeleteClerkUser(ctx, "user_1", now.Add(time.Minute)); err != nil || !applied {
+		t.Fatalf("DeleteClerkUser = %v, %v, want applied", applied, err)
+	}
+	got, err := s.users.GetUserByClerkID(ctx, "user_1")
+	if err != nil {
+		t.Fatalf("GetUserByClerkID: %v", err)
+	}
+	if got.DeletedAt == nil || got.Email != "" || got.Name != "" {
+		t.Errorf("deleted user = %+v, want it marked deleted and scrubbed", got)
+	}
+
+	late := &User{ClerkID: "user_1", Email: "late@example.com"}
+	if applied, err := s.users.UpsertClerkUser(ctx, late, now.Add(2*time.Minute)); err != nil || applied {
+		t.Errorf("UpsertClerkUser of a deleted user = %v, %v, want not applied", applied, err)
+	}
+
+	// Deleting a user we never saw leaves a tombstone for late events
+	if applied, err := s.users.DeleteClerkUser(ctx, "user_2", now); err != nil || !applied {
+		t.Fatalf("DeleteClerkUser of an unknown user = %v, %v, want applied", applied, err)
+	}
+	if applied, err := s.users.UpsertClerkUser(ctx, &User{ClerkID: "user_2"}, now.Add(-time.Minute)); err != nil || applied {
+		t.Errorf("UpsertClerkUser before the deletion = %v, %v, want not applied", applied, err)
+	}
+}
+
+func testProductStockContract(t *testing.T, s storeSet) {
+	ctx := context.Background()
+	tracked := mustCreateProduct(t, s, "Tracked", 1000, intPtr(5))
+	untracked := mustCreateProduct(t, s, "Untracked", 500, nil)
+
+	update := &Product{ID: tracked.ID, Name: "Renamed", Price: 1200}
+	if err := s.products.UpdateProduct(ctx, update); err != nil {
+		t.Fatalf("UpdateProduct: %v", err)
+	}
+	if update.Stock == nil || *update.Stock != 5 || stockOf(t, s, tracked.ID) != 5 {
+		t.Errorf("UpdateProduct changed the stock")
+	}
+	if err := s.products.UpdateProduct(ctx, &Product{ID: 9999, Name: "Missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateProduct of an unknown product error = %v, want ErrNotFound", err)
+	}
+
+	if stock, err := s.products.AdjustProductStock(ctx, tracked.ID, 3); err != nil || stock != 8 {
+		t.Errorf("AdjustProductStock(+3) = %d, %v, want 8", stock, err)
+	}
+	if stock, err := s.products.AdjustProductStock(ctx, tracked.ID, -8); err != nil || stock != 0 {
+		t.Errorf("AdjustProductStock(-8) = %d, %v, want 0", stock, err)
+	}
+	if _, err := s.products.AdjustProductStock(ctx, tracked.ID, -1); !errors.Is(err, ErrStockNegative) {
+		t.Errorf("AdjustProductStock below zero error = %v, want ErrStockNegative", err)
+	}
+	if _, err := s.products.AdjustProductStock(ctx, untracked.ID, 1); !errors.Is(err, ErrStockNotTracked) {
+		t.Errorf("AdjustProductStock of an untracked product error = %v, want ErrStockNotTracked", err)
+	}
+	if _, err := s.products.AdjustProductStock(ctx, 9999, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AdjustProductStock of an unknown product error = %v, want ErrNotFound", err)
+	}
+
+	if err := s.products.TrackProductStock(ctx, untracked.ID, 2); err != nil {
+		t.Fatalf("TrackProductStock: %v", err)
+	}
+	if stockOf(t, s, untracked.ID) != 2 {
+		t.Errorf("stock after TrackProductStock = %d, want 2", stockOf(t, s, untracked.ID))
+	}
+	if err := s.products.TrackProductStock(ctx, untracked.ID, 4); !errors.Is(err, ErrStockTracked) {
+		t.Errorf("TrackProductStock of a tracked product error = %v, want ErrStockTracked", err)
+	}
+	if err := s.products.TrackProductStock(ctx, 9999, 4); !errors.Is(err, ErrNotFound) {
+		t.Errorf("TrackProductStock of an unknown product error = %v, want ErrNotFound", err)
+	}
+
+	products, err := s.products.GetProductsByIDs(ctx, []int{tracked.ID, 9999})
+	if err != nil {
+		t.Fatalf("GetProductsByIDs: %v", err)
+	}
+	if len(products) != 1 || products[tracked.ID].Name != "Renamed" {
+		t.Errorf("GetProductsByIDs = %+v, want only the existing product", products)
+	}
+}
+
+func testProductListContract(t *testing.T, s storeSet) {
+	ctx := context.Background()
+	// Three products share a price, so the pages have to break ties by ID
+	var ids []int
+	for i, price := range []int{300, 200, 100, 200, 200} {
+		ids = append(ids, mustCreateProduct(t, s, "Product "+string(rune('A'+i)), price, nil).ID)
+	}
+
+	tests := []struct {
+		sort string
+		want []int
+	}{
+		{"price", []int{ids[2], ids[1], ids[3], ids[4], ids[0]}},
+		{"-price", []int{ids[0], ids[4], ids[3], ids[1], ids[2]}},
+	}
+	for _, tt := range tests {
+		var got []int
+		page := Page{Limit: 2, Sort: tt.sort}
+		for {
+			products, next, err := s.products.GetProducts(ctx, ProductFilter{}, page)
+			if err != nil {
+				t.Fatalf("GetProducts(%s): %v", tt.sort, err)
+			}
+			for _, p := range products {
+				got = append(got, p.ID)
+			}
+			if next == "" || len(got) > len(ids) {
+				break
+			}
+			page.Cursor = next
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("products sorted by %s = %v, want %v", tt.sort, got, tt.want)
+		}
+	}
+
+	_, next, err := s.products.GetProducts(ctx, ProductFilter{}, Page{Limit: 2, Sort: "price"})
+	if err != nil || next == "" {
+		t.Fatalf("GetProducts = %q, %v, want a next page", next, err)
+	}
+	for _, sort := range []string{"-price", "name"} {
+		if _, _, err := s.products.GetProducts(ctx, ProductFilter{}, Page{Limit: 2, Sort: sort, Cursor: next}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("GetProducts(%s) with a price cursor error = %v, want ErrInvalidCursor", sort, err)
+		}
+	}
+	if _, _, err := s.products.GetProducts(ctx, ProductFilter{}, Page{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("GetProducts with a malformed cursor error = %v, want ErrInvalidCursor", err)
+	}
+	var sortErr *InvalidSortError
+	if _, _, err := s.products.GetProducts(ctx, ProductFilter{}, Page{Sort: "stock"}); !errors.As(err, &sortErr) {
+		t.Errorf("GetProducts sorted by stock error = %v, want InvalidSortError", err)
+	}
+}
+
+func testCreateOrderContract(t *testing.T, s storeSet) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "user_1")
+	mug := mustCreateProduct(t, s, "Mug", 1000, intPtr(5))
+	poster := mustCreateProduct(t, s, "Poster", 250, nil)
+
+	// Item prices come from the products, whatever the caller sent
+	o := mustCreateOrder(t, s, u.ID,
+		OrderItem{ProductID: mug.ID, Quantity: 2, Price: 1},
+		OrderItem{ProductID: poster.ID, Quantity: 3},
+	)
+	if o.ID == 0 || o.Total != 2*1000+3*250 || o.ReservedUntil == nil {
+		t.Errorf("created order = %+v", o)
+	}
+	if o.Items[0].Price != 1000 || o.Items[1].Price != 250 || o.Items[0].ID == 0 || o.Items[0].OrderID != o.ID {
+		t.Errorf("created items = %+v", o.Items)
+	}
+	if stock := stockOf(t, s, mug.ID); stock != 3 {
+		t.Errorf("stock after ordering 2 of 5 = %d, want 3", stock)
+	}
+
+	got, err := s.orders.GetOrderByID(ctx, o.ID)
+	if err != nil {
+		t.Fatalf("GetOrderByID: %v", err)
+	}
+	if got.UserID != u.ID || got.Status != OrderStatusPending || got.Total != o.Total || len(got.Items) != 2 {
+		t.Errorf("stored order = %+v", got)
+	}
+
+	short := &Order{UserID: u.ID, Status: OrderStatusPending, Items: []OrderItem{{ProductID: mug.ID, Quantity: 2}, {ProductID: mug.ID, Quantity: 2}}}
+	var outOfStock *OutOfStockError
+	if err := s.orders.CreateOrder(ctx, short); !errors.As(err, &outOfStock) {
+		t.Fatalf("CreateOrder beyond the stock error = %v, want OutOfStockError", err)
+	}
+	want := []StockShortage{{ProductID: mug.ID, Requested: 4, Available: 3}}
+	if !reflect.DeepEqual(outOfStock.Items, want) {
+		t.Errorf("shortages = %+v, want %+v", outOfStock.Items, want)
+	}
+	if stock := stockOf(t, s, mug.ID); stock != 3 {
+		t.Errorf("stock after a failed order = %d, want 3", stock)
+	}
+
+	missing := &Order{UserID: u.ID, Status: OrderStatusPending, Items: []OrderItem{{ProductID: 9999, Quantity: 1}}}
+	var notFound *ProductNotFoundError
+	if err := s.orders.CreateOrder(ctx, missing); !errors.As(err, &notFound) || notFound.ProductID != 9999 {
+		t.Errorf("CreateOrder of an unknown product error = %v, want ProductNotFoundError", err)
+	}
+
+	other := mustCreateUser(t, s, "user_2")
+	mustCreateOrder(t, s, other.ID, OrderItem{ProductID: poster.ID, Quantity: 1})
+	orders, _, err := s.orders.GetOrders(ctx, OrderFilter{UserID: u.ID}, Page{Limit: 10})
+	if err != nil {
+		t.Fatalf("GetOrders: %v", err)
+	}
+	if len(orders) != 1 || orders[0].ID != o.ID || len(orders[0].Items) != 2 {
+		t.Errorf("GetOrders for the user = %+v, want only their order with its items", orders)
+	}
+
+	if err := s.orders.SetOrderStripeSessionID(ctx, o.ID, "cs_test_1"); err != nil {
+		t.Fatalf("SetOrderStripeSessionID: %v", err)
+	}
+	if got, err := s.orders.GetOrderByStripeSessionID(ctx, "cs_test_1"); err != nil || got.ID != o.ID {
+		t.Errorf("GetOrderByStripeSessionID = %+v, %v", got, err)
+	}
+
+	if err := s.orders.DeleteOrder(ctx, o.ID); err != nil {
+		t.Fatalf("DeleteOrder: %v", err)
+	}
+	if stock := stockOf(t, s, mug.ID); stock != 5 {
+		t.Errorf("stock after deleting the order = %d, want 5", stock)
+	}
+	if _, err := s.orders.GetOrderByID(ctx, o.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetOrderByID after delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func testTransitionOrderContract(t *testing.T, s storeSet) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "user_1")
+	mug := mustCreateProduct(t, s, "Mug", 1000, intPtr(5))
+	paid := mustCreateOrder(t, s, u.ID, OrderItem{ProductID: mug.ID, Quantity: 2})
+	canceled := mustCreateOrder(t, s, u.ID, OrderItem{ProductID: mug.ID, Quantity: 1})
+
+	if err := s.orders.TransitionOrder(ctx, paid.ID, OrderStatusPending, OrderStatusPaid, "stripe:evt_1"); err != nil {
+		t.Fatalf("TransitionOrder to paid: %v", err)
+	}
+	if err := s.orders.TransitionOrder(ctx, paid.ID, OrderStatusPending, OrderStatusPaid, "stripe:evt_1"); !errors.Is(err, ErrStatusConflict) {
+		t.Errorf("TransitionOrder from a stale status error = %v, want ErrStatusConflict", err)
+	}
+	if err := s.orders.TransitionOrder(ctx, paid.ID, OrderStatusPaid, OrderStatusCanceled, "staff"); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("TransitionOrder from paid to canceled error = %v, want ErrInvalidTransition", err)
+	}
+	if err := s.orders.TransitionOrder(ctx, paid.ID, OrderStatusPaid, OrderStatusRefunded, "staff"); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("TransitionOrder to refunded error = %v, want ErrInvalidTransition", err)
+	}
+	failed := mustCreateOrder(t, s, u.ID, OrderItem{ProductID: mug.ID, Quantity: 1})
+	if err := s.orders.TransitionOrder(ctx, failed.ID, OrderStatusPending, OrderStatusPaymentFailed, "stripe:evt_2"); err != nil {
+		t.Fatalf("TransitionOrder to payment_failed: %v", err)
+	}
+	// Only Stripe events record repeated payment failures
+	if err := s.orders.TransitionOrder(ctx, failed.ID, OrderStatusPaymentFailed, OrderStatusPaymentFailed, "staff"); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("TransitionOrder from payment_failed to itself error = %v, want ErrInvalidTransition", err)
+	}
+	if err := s.orders.TransitionOrder(ctx, failed.ID, OrderStatusPaymentFailed, OrderStatusCanceled, "user_1"); err != nil {
+		t.Fatalf("TransitionOrder from payment_failed to canceled: %v", err)
+	}
+	if err := s.orders.TransitionOrder(ctx, 9999, OrderStatusPending, OrderStatusPaid, "staff"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("TransitionOrder of an unknown order error = %v, want ErrNotFound", err)
+	}
+
+	if err := s.orders.TransitionOrder(ctx, canceled.ID, OrderStatusPending, OrderStatusCanceled, "user_1"); err != nil {
+		t.Fatalf("TransitionOrder to canceled: %v", err)
+	}
+	// The paid order keeps its stock and the canceled one gives it back
+	if stock := stockOf(t, s, mug.ID); stock != 3 {
+		t.Errorf("stock = %d, want 3", stock)
+	}
+
+	history, err := s.orders.GetOrderStatusHistory(ctx, paid.ID)
+	if err != nil {
+		t.Fatalf("GetOrderStatusHistory: %v", err)
+	}
+	if len(history) != 1 || history[0].FromStatus != OrderStatusPending || history[0].ToStatus != OrderStatusPaid || history[0].ChangedBy != "stripe:evt_1" {
+		t.Errorf("history = %+v", history)
+	}
+
+	got, err := s.orders.GetOrderByID(ctx, paid.ID)
+	if err != nil {
+		t.Fatalf("GetOrderByID: %v", err)
+	}
+	if got.ReservedUntil != nil {
+		t.Errorf("paid order still holds a reservation until %v", got.ReservedUntil)
+	}
+}
+
+func testExpireReservationsContract(t *testing.T, s storeSet) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "user_1")
+	mug := mustCreateProduct(t, s, "Mug", 1000, intPtr(10))
+
+	newOrder := func(reservedUntil time.Time) *Order {
+		o := &Order{UserID: u.ID, Status: OrderStatusPending, ReservedUntil: &reservedUntil, Items: []OrderItem{{ProductID: mug.ID, Quantity: 1}}}
+		if err := s.orders.CreateOrder(ctx, o); err != nil {
+			t.Fatalf("CreateOrder: %v", err)
+		}
+		return o
+	}
+	now := time.Now()
+	expired := newOrder(now.Add(-time.Minute))
+	failed := newOrder(now.Add(-time.Minute))
+	paid := newOrder(now.Add(-time.Minute))
+	held := newOrder(now.Add(time.Hour))
+
+	if err := s.orders.TransitionOrder(ctx, failed.ID, OrderStatusPending, OrderStatusPaymentFailed, "stripe:evt_1"); err != nil {
+		t.Fatalf("TransitionOrder to payment_failed: %v", err)
+	}
+	if err := s.orders.TransitionOrder(ctx, paid.ID, OrderStatusPending, OrderStatusPaid, "stripe:evt_2"); err != nil {
+		t.Fatalf("TransitionOrder to paid: %v", err)
+	}
+
+	n, err := s.orders.ExpireReservations(ctx, now)
+	if err != nil || n != 2 {
+		t.Fatalf("ExpireReservations = %d, %v, want 2", n, err)
+	}
+	for id, want := range map[int]OrderStatus{
+		expired.ID: OrderStatusCanceled,
+		failed.ID:  OrderStatusCanceled,
+		paid.ID:    OrderStatusPaid,
+		held.ID:    OrderStatusPending,
+	} {
+		if got := statusOf(t, s, id); got != want {
+			t.Errorf("order %d status = %s, want %s", id, got, want)
+		}
+	}
+	if stock := stockOf(t, s, mug.ID); stock != 8 {
+		t.Errorf("stock = %d, want 8", stock)
+	}
+
+	history, err := s.orders.GetOrderStatusHistory(ctx, expired.ID)
+	if err != nil {
+		t.Fatalf("GetOrderStatusHistory: %v", err)
+	}
+	if len(history) != 1 || history[0].ChangedBy != "system:reservation-expired" {
+		t.Errorf("history = %+v", history)
+	}
+
+	if n, err := s.orders.ExpireReservations(ctx, now); err != nil || n != 0 {
+		t.Errorf("ExpireReservations again = %d, %v, want 0", n, err)
+	}
+}
+
+func testStripeEventsContract(t *testing.T, s storeSet) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "user_1")
+	mug := mustCreateProduct(t, s, "Mug", 1000, intPtr(10))
+	o := mustCreateOrder(t, s, u.ID, OrderItem{ProductID: mug.ID, Quantity: 2})
+	if err := s.orders.SetOrderStripeSessionID(ctx, o.ID, "cs_1"); err != nil {
+		t.Fatalf("SetOrderStripeSessionID: %v", err)
+	}
+
+	// process runs a Stripe event that applies fn
+	process := func(eventID string, fn func(tx StripeEventTx) error) (bool, error) {
+		return s.events.ProcessStripeEvent(ctx, eventID, "test.event", fn)
+	}
+	transition := func(to OrderStatus) func(tx StripeEventTx) error {
+		return func(tx StripeEventTx) error {
+			return tx.TransitionOrderBySessionID(ctx, "cs_1", to, "stripe:test")
+		}
+	}
+
+	if processed, err := process("evt_1", transition(OrderStatusPaymentFailed)); err != nil || !processed {
+		t.Fatalf("ProcessStripeEvent = %v, %v; want true", processed, err)
+	}
+	if got := statusOf(t, s, o.ID); got != OrderStatusPaymentFailed {
+		t.Errorf("status = %s, want %s", got, OrderStatusPaymentFailed)
+	}
+	called := false
+	processed, err := process("evt_1", func(tx StripeEventTx) error {
+		called = true
+		return nil
+	})
+	if err != nil || processed || called {
+		t.Errorf("redelivered ProcessStripeEvent = %v, %v, called %v; want false without calling fn", processed, err, called)
+	}
+
+	// Each further failed payment is recorded
+	if _, err := process("evt_1b", transition(OrderStatusPaymentFailed)); err != nil {
+		t.Errorf("ProcessStripeEvent repeating payment_failed: %v", err)
+	}
+	history, err := s.orders.GetOrderStatusHistory(ctx, o.ID)
+	if err != nil {
+		t.Fatalf("GetOrderStatusHistory: %v", err)
+	}
+	if len(history) != 2 || history[1].FromStatus != OrderStatusPaymentFailed || history[1].ToStatus != OrderStatusPaymentFailed {
+		t.Errorf("history = %+v, want two payment failures", history)
+	}
+
+	// A failing event is rolled back and not recorded
+	errFail := errors.New("fail")
+	_, err = process("evt_2", func(tx StripeEventTx) error {
+		if err := tx.TransitionOrderBySessionID(ctx, "cs_1", OrderStatusCanceled, "stripe:test"); err != nil {
+			return err
+		}
+		return errFail
+	})
+	if !errors.Is(err, errFail) {
+		t.Fatalf("ProcessStripeEvent = %v, want %v", err, errFail)
+	}
+	if got := statusOf(t, s, o.ID); got != OrderStatusPaymentFailed {
+		t.Errorf("status after failed event = %s, want %s", got, OrderStatusPaymentFailed)
+	}
+	if got := stockOf(t, s, mug.ID); got != 8 {
+		t.Errorf("stock after failed event = %d, want 8", got)
+	}
+
+	until := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	if _, err := process("evt_2", func(tx StripeEventTx) error {
+		return tx.SetReservationBySessionID(ctx, "cs_1", until)
+	}); err != nil {
+		t.Fatalf("ProcessStripeEvent: %v", err)
+	}
+	got, err := s.orders.GetOrderByID(ctx, o.ID)
+	if err != nil {
+		t.Fatalf("GetOrderByID: %v", err)
+	}
+	if got.ReservedUntil == nil || !got.ReservedUntil.Equal(until) {
+		t.Errorf("reserved until %v, want %v", got.ReservedUntil, until)
+	}
+
+	// Payment after the order was canceled takes its stock again
+	if _, err := process("evt_3", transition(OrderStatusCanceled)); err != nil {
+		t.Fatalf("ProcessStripeEvent: %v", err)
+	}
+	if got := stockOf(t, s, mug.ID); got != 10 {
+		t.Errorf("stock after cancel = %d, want 10", got)
+	}
+	if _, err := process("evt_4", transition(OrderStatusPaid)); err != nil {
+		t.Fatalf("ProcessStripeEvent: %v", err)
+	}
+	if got := statusOf(t, s, o.ID); got != OrderStatusPaid {
+		t.Errorf("status = %s, want %s", got, OrderStatusPaid)
+	}
+	if got := stockOf(t, s, mug.ID); got != 8 {
+		t.Errorf("stock after reinstating = %d, want 8", got)
+	}
+
+	_, err = process("evt_5", func(tx StripeEventTx) error {
+		return tx.TransitionOrderBySessionID(ctx, "cs_unknown", OrderStatusPaid, "stripe:test")
+	})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("TransitionOrderBySessionID(unknown session) = %v, want ErrNotFound", err)
+	}
+	_, err = process("evt_5", transition(OrderStatusPending))
+	if !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("TransitionOrderBySessionID(paid to pending) = %v, want ErrInvalidTransition", err)
+	}
+}
+
+func testRefundsContract(t *testing.T, s storeSet) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "user_1")
+	mug := mustCreateProduct(t, s, "Mug", 1000, intPtr(5))
+	poster := mustCreateProduct(t, s, "Poster", 250, nil)
+	o := mustCreateOrder(t, s, u.ID, OrderItem{ProductID: mug.ID, Quantity: 2}, OrderItem{ProductID: poster.ID, Quantity: 1})
+	mugItem := o.Items[0].ID
+
+	if _, _, err := s.refunds.BeginRefund(ctx, RefundRequest{OrderID: o.ID, IdempotencyKey: "refund-0", CreatedBy: "staff"}); !errors.Is(err, ErrOrderNotRefundable) {
+		t.Errorf("BeginRefund of an unpaid order error = %v, want ErrOrderNotRefundable", err)
+	}
+	if err := s.orders.TransitionOrder(ctx, o.ID, OrderStatusPending, OrderStatusPaid, "stripe:evt_1"); err != nil {
+		t.Fatalf("TransitionOrder to paid: %v", err)
+	}
+
+	req := RefundRequest{OrderID: o.ID, Lines: []RefundLine{{OrderItemID: mugItem, Quantity: 1}}, IdempotencyKey: "refund-1", CreatedBy: "staff"}
+	refund, created, err := s.refunds.BeginRefund(ctx, req)
+	if err != nil || !created {
+		t.Fatalf("BeginRefund = %v, %v, want created", created, err)
+	}
+	if refund.Amount != 1000 || refund.Status != RefundStatusPending || refund.Full {
+		t.Errorf("refund = %+v", refund)
+	}
+
+	again, created, err := s.refunds.BeginRefund(ctx, req)
+	if err != nil || created || again.ID != refund.ID {
+		t.Errorf("BeginRefund with the same key = %+v, %v, %v, want the first refund", again, created, err)
+	}
+	reused := req
+	reused.Lines = []RefundLine{{OrderItemID: mugItem, Quantity: 2}}
+	if _, _, err := s.refunds.BeginRefund(ctx, reused); !errors.Is(err, ErrRefundKeyReused) {
+		t.Errorf("BeginRefund reusing a key error = %v, want ErrRefundKeyReused", err)
+	}
+
+	// The pending refund counts against what is left
+	over := RefundRequest{OrderID: o.ID, Lines: []RefundLine{{OrderItemID: mugItem, Quantity: 2}}, IdempotencyKey: "refund-2", CreatedBy: "staff"}
+	var lineErr *RefundLineError
+	if _, _, err := s.refunds.BeginRefund(ctx, over); !errors.As(err, &lineErr) {
+		t.Errorf("BeginRefund of more than is left error = %v, want RefundLineError", err)
+	}
+
+	completed, err := s.refunds.CompleteRefund(ctx, refund.ID, "re_1")
+	if err != nil {
+		t.Fatalf("CompleteRefund: %v", err)
+	}
+	if completed.Status != RefundStatusIssued || completed.StripeRefundID != "re_1" {
+		t.Errorf("completed refund = %+v", completed)
+	}
+	if got := statusOf(t, s, o.ID); got != OrderStatusPartiallyRefunded {
+		t.Errorf("order status = %s, want %s", got, OrderStatusPartiallyRefunded)
+	}
+	if stock := stockOf(t, s, mug.ID); stock != 4 {
+		t.Errorf("stock after refunding 1 of 2 = %d, want 4", stock)
+	}
+
+	rest, created, err := s.refunds.BeginRefund(ctx, RefundRequest{OrderID: o.ID, IdempotencyKey: "refund-3", CreatedBy: "staff"})
+	if err != nil || !created {
+		t.Fatalf("BeginRefund of the rest = %v, %v", created, err)
+	}
+	if rest.Amount != 1000+250 || !rest.Full {
+		t.Errorf("refund of the rest = %+v", rest)
+	}
+	if _, err := s.refunds.CompleteRefund(ctx, rest.ID, "re_2"); err != nil {
+		t.Fatalf("CompleteRefund: %v", err)
+	}
+	if got := statusOf(t, s, o.ID); got != OrderStatusRefunded {
+		t.Errorf("order status = %s, want %s", got, OrderStatusRefunded)
+	}
+
+	if _, _, err := s.refunds.BeginRefund(ctx, RefundRequest{OrderID: o.ID, IdempotencyKey: "refund-4", CreatedBy: "staff"}); !errors.Is(err, ErrOrderNotRefundable) {
+		t.Errorf("BeginRefund of a refunded order error = %v, want ErrOrderNotRefundable", err)
+	}
+
+	refunds, err := s.refunds.GetRefunds(ctx, o.ID)
+	if err != nil {
+		t.Fatalf("GetRefunds: %v", err)
+	}
+	if len(refunds) != 2 || refunds[0].ID != refund.ID || refunds[1].ID != rest.ID || len(refunds[1].Items) != 2 {
+		t.Errorf("refunds = %+v", refunds)
+	}
+}
+
+func testIdempotencyContract(t *testing.T, s storeSet) {
+	ctx := context.Background()
+	now := time.Now()
+	req := IdempotentRequest{Scope: "user_1", Key: "key-1", Fingerprint: "POST /api/orders abc", ExpiresAt: now.Add(time.Hour)}
+
+	if resp, err := s.idempotency.BeginIdempotentRequest(ctx, req); err != nil || resp != nil {
+		t.Fatalf("BeginIdempotentRequest = %+v, %v, want the key claimed", resp, err)
+	}
+	if _, err := s.idempotency.BeginIdempotentRequest(ctx, req); !errors.Is(err, ErrIdempotencyKeyInUse) {
+		t.Errorf("BeginIdempotentRequest in flight error = %v, want ErrIdempotencyKeyInUse", err)
+	}
+	other := req
+	other.Scope = "user_2"
+	if resp, err := s.idempotency.BeginIdempotentRequest(ctx, other); err != nil || resp != nil {
+		t.Errorf("BeginIdempotentRequest in another scope = %+v, %v, want the key claimed", resp, err)
+	}
+
+	stored := IdempotentResponse{Status: 201, ContentType: "application/json", Body: []byte(`{"id":1}`)}
+	if err := s.idempotency.CompleteIdempotentRequest(ctx, req.Scope, req.Key, stored); err != nil {
+		t.Fatalf("CompleteIdempotentRequest: %v", err)
+	}
+	if err := s.idempotency.CompleteIdempotentRequest(ctx, req.Scope, req.Key, stored); !errors.Is(err, ErrNotFound) {
+		t.Errorf("CompleteIdempotentRequest twice error = %v, want ErrNotFound", err)
+	}
+	resp, err := s.idempotency.BeginIdempotentRequest(ctx, req)
+	if err != nil || resp == nil || !reflect.DeepEqual(*resp, stored) {
+		t.Errorf("BeginIdempotentRequest after completion = %+v, %v, want the stored response", resp, err)
+	}
+
+	changed := req
+	changed.Fingerprint = "POST /api/orders def"
+	if _, err := s.idempotency.BeginIdempotentRequest(ctx, changed); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("BeginIdempotentRequest with another request error = %v, want ErrIdempotencyKeyReused", err)
+	}
+
+	// Released keys can be claimed again, completed ones cannot be released
+	if err := s.idempotency.ReleaseIdempotentRequest(ctx, other.Scope, other.Key); err != nil {
+		t.Fatalf("ReleaseIdempotentRequest: %v", err)
+	}
+	if resp, err := s.idempotency.BeginIdempotentRequest(ctx, other); err != nil || resp != nil {
+		t.Errorf("BeginIdempotentRequest after release = %+v, %v, want the key claimed", resp, err)
+	}
+	if err := s.idempotency.ReleaseIdempotentRequest(ctx, req.Scope, req.Key); err != nil {
+		t.Fatalf("ReleaseIdempotentRequest: %v", err)
+	}
+	if resp, err := s.idempotency.BeginIdempotentRequest(ctx, req); err != nil || resp == nil {
+		t.Errorf("BeginIdempotentRequest after releasing a completed key = %+v, %v, want the stored response", resp, err)
+	}
+
+	expired := IdempotentRequest{Scope: "user_1", Key: "key-2", Fingerprint: "a", ExpiresAt: now.Add(-time.Second)}
+	if _, err := s.idempotency.BeginIdempotentRequest(ctx, expired); err != nil {
+		t.Fatalf("BeginIdempotentRequest: %v", err)
+	}
+	expired.Fingerprint = "b"
+	if resp, err := s.idempotency.BeginIdempotentRequest(ctx, expired); err != nil || resp != nil {
+		t.Errorf("BeginIdempotentRequest of an expired key = %+v, %v, want the key claimed", resp, err)
+	}
+
+	if n, err := s.idempotency.DeleteExpiredIdempotencyKeys(ctx, now.Add(2*time.Hour)); err != nil || n != 3 {
+		t.Errorf("DeleteExpiredIdempotencyKeys = %d, %v, want 3", n, err)
+	}
+}
+
+func testCartsContract(t *testing.T, s storeSet) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "user_1")
+	mug := mustCreateProduct(t, s, "Mug", 1000, intPtr(5))
+	poster := mustCreateProduct(t, s, "Poster", 250, nil)
+	anonymous := CartOwner{Token: "token-1"}
+
+	if _, err := s.carts.GetCart(ctx, anonymous); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetCart before provisioning error = %v, want ErrNotFound", err)
+	}
+	cart, err := s.carts.ProvisionCart(ctx, anonymous)
+	if err != nil {
+		t.Fatalf("ProvisionCart: %v", err)
+	}
+	if again, err := s.carts.ProvisionCart(ctx, anonymous); err != nil || again.ID != cart.ID {
+		t.Errorf("ProvisionCart again = %+v, %v, want the same cart", again, err)
+	}
+
+	for _, add := range []struct{ productID, quantity int }{{mug.ID, 1}, {poster.ID, 2}, {mug.ID, 2}} {
+		if err := s.carts.AddCartItem(ctx, cart.ID, add.productID, add.quantity); err != nil {
+			t.Fatalf("AddCartItem(%d, %d): %v", add.productID, add.quantity, err)
+		}
+	}
+	if err := s.carts.AddCartItem(ctx, cart.ID, poster.ID, MaxCartQuantity); !errors.Is(err, ErrCartQuantityLimit) {
+		t.Errorf("AddCartItem over the limit error = %v, want ErrCartQuantityLimit", err)
+	}
+	var notFound *ProductNotFoundError
+	if err := s.carts.AddCartItem(ctx, cart.ID, 9999, 1); !errors.As(err, &notFound) {
+		t.Errorf("AddCartItem of an unknown product error = %v, want ProductNotFoundError", err)
+	}
+
+	cart, err = s.carts.GetCart(ctx, anonymous)
+	if err != nil {
+		t.Fatalf("GetCart: %v", err)
+	}
+	if len(cart.Items) != 2 || cart.Items[0].ProductID != mug.ID || cart.Items[0].Quantity != 3 || cart.Items[0].Name != "Mug" || cart.Subtotal != 3*1000+2*250 {
+		t.Errorf("cart = %+v", cart)
+	}
+
+	posterItem := cart.Items[1].ID
+	if err := s.carts.SetCartItemQuantity(ctx, cart.ID, posterItem, 4); err != nil {
+		t.Fatalf("SetCartItemQuantity: %v", err)
+	}
+	if err := s.carts.SetCartItemQuantity(ctx, cart.ID, 9999, 4); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetCartItemQuantity of an unknown item error = %v, want ErrNotFound", err)
+	}
+
+	// The user's own cart already has a mug, so the quantities add up
+	userCart, err := s.carts.ProvisionCart(ctx, CartOwner{UserID: u.ID})
+	if err != nil {
+		t.Fatalf("ProvisionCart: %v", err)
+	}
+	if err := s.carts.AddCartItem(ctx, userCart.ID, mug.ID, 1); err != nil {
+		t.Fatalf("AddCartItem: %v", err)
+	}
+	if err := s.carts.MergeCart(ctx, anonymous.Token, u.ID); err != nil {
+		t.Fatalf("MergeCart: %v", err)
+	}
+	if _, err := s.carts.GetCart(ctx, anonymous); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetCart of the merged cart error = %v, want ErrNotFound", err)
+	}
+	if err := s.carts.MergeCart(ctx, anonymous.Token, u.ID); err != nil {
+		t.Errorf("MergeCart without a cart: %v", err)
+	}
+	userCart, err = s.carts.GetCart(ctx, CartOwner{UserID: u.ID})
+	if err != nil {
+		t.Fatalf("GetCart: %v", err)
+	}
+	quantities := make(map[int]int)
+	for _, item := range userCart.Items {
+		quantities[item.ProductID] = item.Quantity
+	}
+	if want := map[int]int{mug.ID: 4, poster.ID: 4}; !reflect.DeepEqual(quantities, want) {
+		t.Errorf("merged quantities = %v, want %v", quantities, want)
+	}
+
+	o := &Order{UserID: u.ID, Status: OrderStatusPending}
+	if err := s.carts.ConvertCart(ctx, userCart.ID, o); err != nil {
+		t.Fatalf("ConvertCart: %v", err)
+	}
+	if o.ID == 0 || len(o.Items) != 2 || o.Total != 4*1000+4*250 {
+		t.Errorf("order from the cart = %+v", o)
+	}
+	if stock := stockOf(t, s, mug.ID); stock != 1 {
+		t.Errorf("stock after converting the cart = %d, want 1", stock)
+	}
+	if err := s.carts.ConvertCart(ctx, userCart.ID, &Order{UserID: u.ID, Status: OrderStatusPending}); !errors.Is(err, ErrCartEmpty) {
+		t.Errorf("ConvertCart of an empty cart error = %v, want ErrCartEmpty", err)
+	}
+
+	// A cart that cannot be ordered is kept
+	if err := s.carts.AddCartItem(ctx, userCart.ID, mug.ID, 2); err != nil {
+		t.Fatalf("AddCartItem: %v", err)
+	}
+	var outOfStock *OutOfStockError
+	if err := s.carts.ConvertCart(ctx, userCart.ID, &Order{UserID: u.ID, Status: OrderStatusPending}); !errors.As(err, &outOfStock) {
+		t.Errorf("ConvertCart beyond the stock error = %v, want OutOfStockError", err)
+	}
+	if cart, err := s.carts.GetCart(ctx, CartOwner{UserID: u.ID}); err != nil || len(cart.Items) != 1 {
+		t.Errorf("cart after a failed conversion = %+v, %v, want its item kept", cart, err)
+	}
+
+	if err := s.carts.ClearCart(ctx, userCart.ID); err != nil {
+		t.Fatalf("ClearCart: %v", err)
+	}
+	if cart, err := s.carts.GetCart(ctx, CartOwner{UserID: u.ID}); err != nil || len(cart.Items) != 0 {
+		t.Errorf("cleared cart = %+v, %v", cart, err)
+	}
+
+	if _, err := s.carts.ProvisionCart(ctx, CartOwner{Token: "token-2"}); err != nil {
+		t.Fatalf("ProvisionCart: %v", err)
+	}
+	if n, err := s.carts.DeleteStaleCarts(ctx, time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Errorf("DeleteStaleCarts = %d, %v, want only the anonymous cart deleted", n, err)
+	}
+}
+
+func testCouponsContract(t *testing.T, s storeSet) {
+	ctx := context.Background()
+	u := mustCreateUser(t, s, "user_1")
+	mug := mustCreateProduct(t, s, "Mug", 1000, nil)
+	poster := mustCreateProduct(t, s, "Poster", 500, nil)
+
+	coupon := &Coupon{Code: " mugs10 ", Kind: CouponPercent, Value: 10, ProductIDs: []int{mug.ID}, MaxRedemptionsPerUser: intPtr(1)}
+	if err := s.coupons.CreateCoupon(ctx, coupon); err != nil {
+		t.Fatalf("CreateCoupon: %v", err)
+	}
+	if coupon.Code != "MUGS10" {
+		t.Errorf("code = %q, want it normalized to MUGS10", coupon.Code)
+	}
+	unused := &Coupon{Code: "UNUSED", Kind: CouponFixed, Value: 100, ProductIDs: []int{}}
+	if err := s.coupons.CreateCoupon(ctx, unused); err != nil {
+		t.Fatalf("CreateCoupon: %v", err)
+	}
+
+	o := &Order{UserID: u.ID, Status: OrderStatusPending, CouponCode: "Mugs10", Items: []OrderItem{{ProductID: mug.ID, Quantity: 2}, {ProductID: poster.ID, Quantity: 1}}}
+	if err := s.orders.CreateOrder(ctx, o); err != nil {
+		t.Fatalf("CreateOrder with a coupon: %v", err)
+	}
+	if o.Discount != 200 || o.Total != 2*1000+500-200 || o.Items[0].Discount != 200 || o.Items[1].Discount != 0 {
+		t.Errorf("discounted order = %+v", o)
+	}
+	if o.CouponID == nil || *o.CouponID != coupon.ID {
+		t.Errorf("order coupon = %v, want %d", o.CouponID, coupon.ID)
+	}
+
+	var couponErr *CouponError
+	again := &Order{UserID: u.ID, Status: OrderStatusPending, CouponCode: "MUGS10", Items: []OrderItem{{ProductID: mug.ID, Quantity: 1}}}
+	if err := s.orders.CreateOrder(ctx, again); !errors.As(err, &couponErr) {
+		t.Errorf("CreateOrder over the per-user limit error = %v, want CouponError", err)
+	}
+	missing := &Order{UserID: u.ID, Status: OrderStatusPending, CouponCode: "NOPE", Items: []OrderItem{{ProductID: mug.ID, Quantity: 1}}}
+	if err := s.orders.CreateOrder(ctx, missing); !errors.As(err, &couponErr) {
+		t.Errorf("CreateOrder with an unknown coupon error = %v, want CouponError", err)
+	}
+
+	// Canceled orders do not count towards the limits
+	if err := s.orders.TransitionOrder(ctx, o.ID, OrderStatusPending, OrderStatusCanceled, "user_1"); err != nil {
+		t.Fatalf("TransitionOrder to canceled: %v", err)
+	}
+	if err := s.orders.CreateOrder(ctx, again); err != nil {
+		t.Errorf("CreateOrder after the first order was canceled: %v", err)
+	}
+
+	if err := s.coupons.DeleteCoupon(ctx, coupon.ID); !errors.Is(err, ErrCouponRedeemed) {
+		t.Errorf("DeleteCoupon of a redeemed coupon error = %v, want ErrCouponRedeemed", err)
+	}
+	if err := s.coupons.DeleteCoupon(ctx, unused.ID); err != nil {
+		t.Fatalf("DeleteCoupon: %v", err)
+	}
+	if err := s.coupons.DeleteCoupon(ctx, unused.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteCoupon of a deleted coupon error = %v, want ErrNotFound", err)
+	}
+
+	if err := s.coupons.CreateCoupon(ctx, &Coupon{Code: "TOOMUCH", Kind: CouponPercent, Value: 150}); !errors.Is(err, ErrInvalidCouponValue) {
+		t.Errorf("CreateCoupon over 100 percent error = %v, want ErrInvalidCouponValue", err)
+	}
+	invalid := *coupon
+	invalid.Kind, invalid.Value = CouponFixed, 0
+	if err := s.coupons.UpdateCoupon(ctx, &invalid); !errors.Is(err, ErrInvalidCouponValue) {
+		t.Errorf("UpdateCoupon to a zero fixed amount error = %v, want ErrInvalidCouponValue", err)
+	}
+
+	coupons, err := s.coupons.GetCoupons(ctx)
+	if err != nil {
+		t.Fatalf("GetCoupons: %v", err)
+	}
+	if len(coupons) != 1 || coupons[0].ID != coupon.ID || !reflect.DeepEqual(coupons[0].ProductIDs, []int{mug.ID}) {
+		t.Errorf("coupons = %+v", coupons)
+	}
+}
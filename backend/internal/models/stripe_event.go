@@ -0,0 +1,66 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/your-username/your-repo/internal/database"
+)
+
+// PostgresStripeEventStore implements StripeEventStore on top of Postgres
+type PostgresStripeEventStore struct {
+	db *database.DB
+}
+
+// NewPostgresStripeEventStore creates a new PostgresStripeEventStore
+func NewPostgresStripeEventStore(db *database.DB) *PostgresStripeEventStore {
+	return &PostgresStripeEventStore{db: db}
+}
+
+// ProcessStripeEvent runs fn in a transaction that also records the Stripe
+// event ID. If the event has already been processed fn is not called and
+// false is returned, so redelivered webhooks are handled exactly once.
+func (s *PostgresStripeEventStore) ProcessStripeEvent(ctx context.Context, eventID, eventType string, fn func(tx StripeEventTx) error) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Concurrent deliveries of the same event block on the primary key here
+	// until the first one commits, then see the conflict
+	var id string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO stripe_events (id, type, processed_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO NOTHING
+		RETURNING id
+	`, eventID, eventType, time.Now()).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := fn(postgresStripeEventTx{tx}); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// postgresStripeEventTx is the StripeEventTx of a Postgres transaction
+type postgresStripeEventTx struct {
+	tx *sql.Tx
+}
+
+func (t postgresStripeEventTx) TransitionOrderBySessionID(ctx context.Context, sessionID string, to OrderStatus, changedBy string) error {
+	return transitionOrderBySessionID(ctx, t.tx, sessionID, to, changedBy)
+}
+
+func (t postgresStripeEventTx) SetReservationBySessionID(ctx context.Context, sessionID string, until time.Time) error {
+	return setReservationBySessionID(ctx, t.tx, sessionID, until)
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"context"
+	"strings"
 	"time"
 
 	"github.com/your-username/your-repo/internal/database"
@@ -8,81 +10,171 @@ import (
 
 // User represents a user in the system
 type User struct {
-	ID        int       `json:"id"`
-	ClerkID   string    `json:"clerk_id"`
-	Email     string    `json:"email"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        int        `json:"id"`
+	ClerkID   string     `json:"clerk_id"`
+	Email     string     `json:"email"`
+	Name      string     `json:"name"`
+	Role      Role       `json:"role"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
-// GetUsers returns all users
-func GetUsers(db *database.DB) ([]User, error) {
-	rows, err := db.Query(`
-		SELECT id, clerk_id, email, name, created_at, updated_at
-		FROM users
-		ORDER BY email
-	`)
+// UserFilter narrows a list of users. Zero fields match every user that has
+// not been deleted.
+type UserFilter struct {
+	EmailPrefix string
+}
+
+// matches reports whether u passes the filter
+func (f UserFilter) matches(u User) bool {
+	return u.DeletedAt == nil && strings.HasPrefix(strings.ToLower(u.Email), strings.ToLower(f.EmailPrefix))
+}
+
+// userSorts are the fields users can be sorted by
+var userSorts = map[string]sortField[User]{
+	"email":      {column: "email", sqlType: "text", key: func(u User) interface{} { return u.Email }},
+	"created_at": {column: "created_at", sqlType: "timestamp", key: func(u User) interface{} { return u.CreatedAt }},
+}
+
+// userID returns the ID of a user, to break ties between sort values
+func userID(u User) int { return u.ID }
+
+// PostgresUserStore implements UserStore on top of Postgres
+type PostgresUserStore struct {
+	db *database.DB
+}
+
+// NewPostgresUserStore creates a new PostgresUserStore
+func NewPostgresUserStore(db *database.DB) *PostgresUserStore {
+	return &PostgresUserStore{db: db}
+}
+
+// GetUsers returns a page of the users matching filter, ordered by email by
+// default, and the cursor of the next page
+func (s *PostgresUserStore) GetUsers(ctx context.Context, filter UserFilter, page Page) ([]User, string, error) {
+	order, err := resolveSort(page.Sort, "email", userSorts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var q listQuery
+	q.where("deleted_at IS NULL")
+	if filter.EmailPrefix != "" {
+		q.where("starts_with(LOWER(email), LOWER(" + q.arg(filter.EmailPrefix) + "))")
+	}
+	tail, err := paginate(&q, page, order)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, clerk_id, email, name, role, created_at, updated_at, deleted_at
+		FROM users`+q.whereClause()+tail, q.args...)
+	if err != nil {
+		return nil, "", err
 	}
 	defer rows.Close()
 
 	var users []User
 	for rows.Next() {
 		var u User
-		if err := rows.Scan(&u.ID, &u.ClerkID, &u.Email, &u.Name, &u.CreatedAt, &u.UpdatedAt); err != nil {
-			return nil, err
+		if err := rows.Scan(&u.ID, &u.ClerkID, &u.Email, &u.Name, &u.Role, &u.CreatedAt, &u.UpdatedAt, &u.DeletedAt); err != nil {
+			return nil, "", err
 		}
 		users = append(users, u)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
 
-	return users, nil
+	users, next := trimPage(users, page, order, userID)
+	return users, next, nil
 }
 
 // GetUserByID returns a user by ID
-func GetUserByID(db *database.DB, id int) (*User, error) {
+func (s *PostgresUserStore) GetUserByID(ctx context.Context, id int) (*User, error) {
 	var u User
-	err := db.QueryRow(`
-		SELECT id, clerk_id, email, name, created_at, updated_at
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, clerk_id, email, name, role, created_at, updated_at, deleted_at
 		FROM users
 		WHERE id = $1
-	`, id).Scan(&u.ID, &u.ClerkID, &u.Email, &u.Name, &u.CreatedAt, &u.UpdatedAt)
+	`, id).Scan(&u.ID, &u.ClerkID, &u.Email, &u.Name, &u.Role, &u.CreatedAt, &u.UpdatedAt, &u.DeletedAt)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err)
 	}
 
 	return &u, nil
 }
 
-// CreateUser creates a new user
-func CreateUser(db *database.DB, u *User) error {
+// GetUserByClerkID returns a user by Clerk user ID
+func (s *PostgresUserStore) GetUserByClerkID(ctx context.Context, clerkID string) (*User, error) {
+	var u User
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, clerk_id, email, name, role, created_at, updated_at, deleted_at
+		FROM users
+		WHERE clerk_id = $1
+	`, clerkID).Scan(&u.ID, &u.ClerkID, &u.Email, &u.Name, &u.Role, &u.CreatedAt, &u.UpdatedAt, &u.DeletedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &u, nil
+}
+
+// CreateUser creates a new user, as a customer unless it has a role
+func (s *PostgresUserStore) CreateUser(ctx context.Context, u *User) error {
+	if u.Role == "" {
+		u.Role = RoleCustomer
+	}
 	now := time.Now()
 	u.CreatedAt = now
 	u.UpdatedAt = now
 
-	return db.QueryRow(`
-		INSERT INTO users (clerk_id, email, name, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5)
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO users (clerk_id, email, name, role, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id
-	`, u.ClerkID, u.Email, u.Name, u.CreatedAt, u.UpdatedAt).Scan(&u.ID)
+	`, u.ClerkID, u.Email, u.Name, u.Role, u.CreatedAt, u.UpdatedAt).Scan(&u.ID)
 }
 
-// UpdateUser updates a user
-func UpdateUser(db *database.DB, u *User) error {
+// ProvisionUser creates u, as a customer, unless a user with its Clerk ID
+// already exists, and fills u in from the stored user either way
+func (s *PostgresUserStore) ProvisionUser(ctx context.Context, u *User) error {
+	now := time.Now()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO users (clerk_id, email, name, role, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (clerk_id) DO NOTHING
+	`, u.ClerkID, u.Email, u.Name, RoleCustomer, now)
+	if err != nil {
+		return err
+	}
+
+	stored, err := s.GetUserByClerkID(ctx, u.ClerkID)
+	if err != nil {
+		return err
+	}
+	*u = *stored
+	return nil
+}
+
+// UpdateUser updates a user's email and name, filling in the rest of u from
+// the stored user. Roles are changed with SetUserRole.
+func (s *PostgresUserStore) UpdateUser(ctx context.Context, u *User) error {
 	u.UpdatedAt = time.Now()
 
-	_, err := db.Exec(`
+	err := s.db.QueryRowContext(ctx, `
 		UPDATE users
 		SET email = $1, name = $2, updated_at = $3
 		WHERE id = $4
-	`, u.Email, u.Name, u.UpdatedAt, u.ID)
-
-	return err
+		RETURNING clerk_id, role, created_at, deleted_at
+	`, u.Email, u.Name, u.UpdatedAt, u.ID).Scan(&u.ClerkID, &u.Role, &u.CreatedAt, &u.DeletedAt)
+	return notFound(err)
 }
 
 // DeleteUser deletes a user
-func DeleteUser(db *database.DB, id int) error {
-	_, err := db.Exec(`DELETE FROM users WHERE id = $1`, id)
-	return err
+func (s *PostgresUserStore) DeleteUser(ctx context.Context, id int) error {
+	return requireRows(s.db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id))
 }
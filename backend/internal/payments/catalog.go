@@ -0,0 +1,193 @@
+package payments
+
+import (
+	"context"
+	"errors"
+
+	"github.com/stripe/stripe-go/v74"
+)
+
+// ErrNotFound is returned when a Stripe object does not exist
+var ErrNotFound = errors.New("payments: not found")
+
+// CatalogProduct is a Stripe Product
+type CatalogProduct struct {
+	ID          string
+	Name        string
+	Description string
+	Active      bool
+	// Metadata links the product to ours, under "product_id"
+	Metadata map[string]string
+}
+
+// CatalogPrice is a one-off Stripe Price of a product
+type CatalogPrice struct {
+	ID         string
+	ProductID  string
+	UnitAmount int64 // Unit price in cents
+	Currency   string
+	Active     bool
+}
+
+// Catalog is the subset of the Stripe API used to keep Stripe's products and
+// prices in step with ours
+type Catalog interface {
+	// CreateProduct creates a Stripe Product. idempotencyKey makes retries of
+	// the same creation return the first product.
+	CreateProduct(ctx context.Context, p *CatalogProduct, idempotencyKey string) (*CatalogProduct, error)
+	// UpdateProduct updates the name, description, active flag and metadata
+	// of a Stripe Product
+	UpdateProduct(ctx context.Context, p *CatalogProduct) (*CatalogProduct, error)
+	// GetProduct returns a Stripe Product, or ErrNotFound
+	GetProduct(ctx context.Context, id string) (*CatalogProduct, error)
+	// ListProducts returns every Stripe Product, active or archived
+	ListProducts(ctx context.Context) ([]CatalogProduct, error)
+
+	// CreatePrice creates a Stripe Price. Prices are immutable, so a new
+	// one is created whenever an amount changes.
+	CreatePrice(ctx context.Context, p *CatalogPrice, idempotencyKey string) (*CatalogPrice, error)
+	// GetPrice returns a Stripe Price, or ErrNotFound
+	GetPrice(ctx context.Context, id string) (*CatalogPrice, error)
+	// ArchivePrice deactivates a Stripe Price so it can no longer be used
+	ArchivePrice(ctx context.Context, id string) error
+}
+
+var _ Catalog = (*StripeClient)(nil)
+
+// CreateProduct creates a Stripe Product
+func (c *StripeClient) CreateProduct(ctx context.Context, p *CatalogProduct, idempotencyKey string) (*CatalogProduct, error) {
+	params := productParams(p)
+	params.Context = ctx
+	params.SetIdempotencyKey(idempotencyKey)
+
+	product, err := c.api.Products.New(params)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+	return catalogProduct(product), nil
+}
+
+// UpdateProduct updates a Stripe Product
+func (c *StripeClient) UpdateProduct(ctx context.Context, p *CatalogProduct) (*CatalogProduct, error) {
+	params := productParams(p)
+	params.Context = ctx
+	params.Active = stripe.Bool(p.Active)
+	// An empty description clears it on update
+	params.Description = stripe.String(p.Description)
+
+	product, err := c.api.Products.Update(p.ID, params)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+	return catalogProduct(product), nil
+}
+
+// GetProduct returns a Stripe Product
+func (c *StripeClient) GetProduct(ctx context.Context, id string) (*CatalogProduct, error) {
+	params := &stripe.ProductParams{}
+	params.Context = ctx
+
+	product, err := c.api.Products.Get(id, params)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+	return catalogProduct(product), nil
+}
+
+// ListProducts returns every Stripe Product
+func (c *StripeClient) ListProducts(ctx context.Context) ([]CatalogProduct, error) {
+	params := &stripe.ProductListParams{}
+	params.Context = ctx
+	params.Limit = stripe.Int64(100)
+
+	var products []CatalogProduct
+	iter := c.api.Products.List(params)
+	for iter.Next() {
+		products = append(products, *catalogProduct(iter.Product()))
+	}
+	return products, iter.Err()
+}
+
+// CreatePrice creates a Stripe Price
+func (c *StripeClient) CreatePrice(ctx context.Context, p *CatalogPrice, idempotencyKey string) (*CatalogPrice, error) {
+	params := &stripe.PriceParams{
+		Product:    stripe.String(p.ProductID),
+		UnitAmount: stripe.Int64(p.UnitAmount),
+		Currency:   stripe.String(p.Currency),
+	}
+	params.Context = ctx
+	params.SetIdempotencyKey(idempotencyKey)
+
+	price, err := c.api.Prices.New(params)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+	return catalogPrice(price), nil
+}
+
+// GetPrice returns a Stripe Price
+func (c *StripeClient) GetPrice(ctx context.Context, id string) (*CatalogPrice, error) {
+	params := &stripe.PriceParams{}
+	params.Context = ctx
+
+	price, err := c.api.Prices.Get(id, params)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+	return catalogPrice(price), nil
+}
+
+// ArchivePrice deactivates a Stripe Price
+func (c *StripeClient) ArchivePrice(ctx context.Context, id string) error {
+	params := &stripe.PriceParams{Active: stripe.Bool(false)}
+	params.Context = ctx
+
+	_, err := c.api.Prices.Update(id, params)
+	return stripeError(err)
+}
+
+// productParams returns the parameters shared by product creates and updates
+func productParams(p *CatalogProduct) *stripe.ProductParams {
+	params := &stripe.ProductParams{Name: stripe.String(p.Name)}
+	if p.Description != "" {
+		params.Description = stripe.String(p.Description)
+	}
+	for k, v := range p.Metadata {
+		params.AddMetadata(k, v)
+	}
+	return params
+}
+
+// catalogProduct converts a Stripe Product
+func catalogProduct(p *stripe.Product) *CatalogProduct {
+	return &CatalogProduct{
+		ID:          p.ID,
+		Name:        p.Name,
+		Description: p.Description,
+		Active:      p.Active,
+		Metadata:    p.Metadata,
+	}
+}
+
+// catalogPrice converts a Stripe Price
+func catalogPrice(p *stripe.Price) *CatalogPrice {
+	price := &CatalogPrice{
+		ID:         p.ID,
+		UnitAmount: p.UnitAmount,
+		Currency:   string(p.Currency),
+		Active:     p.Active,
+	}
+	if p.Product != nil {
+		price.ProductID = p.Product.ID
+	}
+	return price
+}
+
+// stripeError translates Stripe's missing-resource errors into ErrNotFound
+func stripeError(err error) error {
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) && stripeErr.Code == stripe.ErrorCodeResourceMissing {
+		return ErrNotFound
+	}
+	return err
+}
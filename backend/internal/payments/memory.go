@@ -0,0 +1,164 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MemoryCatalog is an in-memory Catalog with the same semantics as Stripe's
+// product and price APIs, for use in tests
+type MemoryCatalog struct {
+	mu       sync.Mutex
+	products map[string]CatalogProduct
+	prices   map[string]CatalogPrice
+	// created maps idempotency keys to the ID of the object they created
+	created map[string]string
+	nextID  int
+}
+
+var _ Catalog = (*MemoryCatalog)(nil)
+
+// NewMemoryCatalog creates a new, empty MemoryCatalog
+func NewMemoryCatalog() *MemoryCatalog {
+	return &MemoryCatalog{
+		products: make(map[string]CatalogProduct),
+		prices:   make(map[string]CatalogPrice),
+		created:  make(map[string]string),
+	}
+}
+
+// id returns a new Stripe-style object ID with the given prefix
+func (c *MemoryCatalog) id(prefix string) string {
+	c.nextID++
+	return fmt.Sprintf("%s_%d", prefix, c.nextID)
+}
+
+// CreateProduct creates an active product
+func (c *MemoryCatalog) CreateProduct(ctx context.Context, p *CatalogProduct, idempotencyKey string) (*CatalogProduct, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if id, ok := c.created[idempotencyKey]; ok && idempotencyKey != "" {
+		product := c.products[id]
+		return &product, nil
+	}
+
+	product := *p
+	product.ID = c.id("prod")
+	product.Active = true
+	product.Metadata = copyMetadata(p.Metadata)
+	c.products[product.ID] = product
+	if idempotencyKey != "" {
+		c.created[idempotencyKey] = product.ID
+	}
+
+	return &product, nil
+}
+
+// UpdateProduct updates a product, merging its metadata like Stripe does
+func (c *MemoryCatalog) UpdateProduct(ctx context.Context, p *CatalogProduct) (*CatalogProduct, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	product, ok := c.products[p.ID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	product.Name = p.Name
+	product.Description = p.Description
+	product.Active = p.Active
+	product.Metadata = copyMetadata(product.Metadata)
+	for k, v := range p.Metadata {
+		product.Metadata[k] = v
+	}
+	c.products[p.ID] = product
+
+	return &product, nil
+}
+
+// GetProduct returns a product
+func (c *MemoryCatalog) GetProduct(ctx context.Context, id string) (*CatalogProduct, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	product, ok := c.products[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &product, nil
+}
+
+// ListProducts returns every product, ordered by ID
+func (c *MemoryCatalog) ListProducts(ctx context.Context) ([]CatalogProduct, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	products := make([]CatalogProduct, 0, len(c.products))
+	for _, p := range c.products {
+		products = append(products, p)
+	}
+	sort.Slice(products, func(i, j int) bool { return products[i].ID < products[j].ID })
+	return products, nil
+}
+
+// CreatePrice creates an active price for an existing product
+func (c *MemoryCatalog) CreatePrice(ctx context.Context, p *CatalogPrice, idempotencyKey string) (*CatalogPrice, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if id, ok := c.created[idempotencyKey]; ok && idempotencyKey != "" {
+		price := c.prices[id]
+		return &price, nil
+	}
+	if _, ok := c.products[p.ProductID]; !ok {
+		return nil, ErrNotFound
+	}
+
+	price := *p
+	price.ID = c.id("price")
+	price.Active = true
+	c.prices[price.ID] = price
+	if idempotencyKey != "" {
+		c.created[idempotencyKey] = price.ID
+	}
+
+	return &price, nil
+}
+
+// GetPrice returns a price
+func (c *MemoryCatalog) GetPrice(ctx context.Context, id string) (*CatalogPrice, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	price, ok := c.prices[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &price, nil
+}
+
+// ArchivePrice deactivates a price
+func (c *MemoryCatalog) ArchivePrice(ctx context.Context, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	price, ok := c.prices[id]
+	if !ok {
+		return ErrNotFound
+	}
+	price.Active = false
+	c.prices[id] = price
+
+	return nil
+}
+
+// copyMetadata returns a copy of a metadata map that is never nil
+func copyMetadata(metadata map[string]string) map[string]string {
+	copied := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,264 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/client"
+)
+
+// CheckoutLineItem is a single line of a Checkout Session. It charges the
+// Stripe Price if one is set, and otherwise an ad hoc price made from the
+// name, description and unit amount.
+type CheckoutLineItem struct {
+	Price       string // Stripe Price ID
+	Name        string
+	Description string
+	UnitAmount  int64 // Unit price in cents
+	Quantity    int64
+}
+
+// CheckoutDiscount is an amount taken off a whole Checkout Session
+type CheckoutDiscount struct {
+	Name      string // Shown to the customer, such as the coupon code
+	AmountOff int64  // Amount in cents
+}
+
+// CheckoutSessionParams describes a Checkout Session to create for an order
+type CheckoutSessionParams struct {
+	OrderID       int
+	Currency      string
+	CustomerEmail string
+	LineItems     []CheckoutLineItem
+	// Discount, if set, is taken off the line items' total
+	Discount   *CheckoutDiscount
+	SuccessURL string
+	CancelURL  string
+	// ExpiresAt, if set, is when the session stops accepting payment
+	ExpiresAt time.Time
+}
+
+// CheckoutSession is a created Checkout Session
+type CheckoutSession struct {
+	ID  string
+	URL string
+}
+
+// RefundParams describes a refund of the payment for an order
+type RefundParams struct {
+	OrderID           int
+	RefundID          int
+	CheckoutSessionID string
+	Amount            int64 // Amount in cents
+	// Reason is one of Stripe's refund reasons, or empty
+	Reason string
+	// IdempotencyKey, if set, replaces the key derived from RefundID, for
+	// refunds that are not recorded as a refund of the order
+	IdempotencyKey string
+}
+
+// Refund is a created Stripe Refund
+type Refund struct {
+	ID     string
+	Status string
+}
+
+// ErrRefundRejected is returned when Stripe declines to create a refund.
+// Other refund errors leave it unknown whether the refund was made.
+var ErrRefundRejected = errors.New("payments: refund rejected")
+
+// Client is the subset of the Stripe API used by the backend
+type Client interface {
+	// CreateCheckoutSession creates a hosted Checkout Session for an order
+	CreateCheckoutSession(ctx context.Context, params *CheckoutSessionParams) (*CheckoutSession, error)
+
+	// CheckoutSessionIDForPaymentIntent returns the ID of the Checkout Session
+	// that created the given PaymentIntent, or "" if there is none
+	CheckoutSessionIDForPaymentIntent(ctx context.Context, paymentIntentID string) (string, error)
+
+	// RefundPayment refunds part or all of the payment made through a
+	// Checkout Session. Retrying the same refund does not refund twice.
+	RefundPayment(ctx context.Context, params *RefundParams) (*Refund, error)
+}
+
+// StripeClient implements Client using the Stripe API
+type StripeClient struct {
+	api *client.API
+}
+
+// NewStripeClient creates a new StripeClient. apiURL, if set, replaces the
+// Stripe API base URL, for example to run against stripe-mock.
+func NewStripeClient(secretKey, apiURL string) *StripeClient {
+	var backends *stripe.Backends
+	if apiURL != "" {
+		config := &stripe.BackendConfig{URL: stripe.String(apiURL)}
+		backends = &stripe.Backends{
+			API:     stripe.GetBackendWithConfig(stripe.APIBackend, config),
+			Connect: stripe.GetBackendWithConfig(stripe.ConnectBackend, config),
+			Uploads: stripe.GetBackendWithConfig(stripe.UploadsBackend, config),
+		}
+	}
+	return &StripeClient{api: client.New(secretKey, backends)}
+}
+
+// CreateCheckoutSession creates a payment-mode Checkout Session priced from
+// the given line items
+func (c *StripeClient) CreateCheckoutSession(ctx context.Context, p *CheckoutSessionParams) (*CheckoutSession, error) {
+	orderID := strconv.Itoa(p.OrderID)
+
+	params := &stripe.CheckoutSessionParams{
+		Mode:              stripe.String(string(stripe.CheckoutSessionModePayment)),
+		SuccessURL:        stripe.String(p.SuccessURL),
+		CancelURL:         stripe.String(p.CancelURL),
+		ClientReferenceID: stripe.String(orderID),
+		PaymentIntentData: &stripe.CheckoutSessionPaymentIntentDataParams{
+			Metadata: map[string]string{"order_id": orderID},
+		},
+	}
+	params.Context = ctx
+	params.AddMetadata("order_id", orderID)
+	// Retrying checkout for the same order must not open a second session
+	params.SetIdempotencyKey("checkout-order-" + orderID)
+
+	if p.CustomerEmail != "" {
+		params.CustomerEmail = stripe.String(p.CustomerEmail)
+	}
+	if !p.ExpiresAt.IsZero() {
+		params.ExpiresAt = stripe.Int64(p.ExpiresAt.Unix())
+	}
+
+	for _, item := range p.LineItems {
+		if item.Price != "" {
+			params.LineItems = append(params.LineItems, &stripe.CheckoutSessionLineItemParams{
+				Price:    stripe.String(item.Price),
+				Quantity: stripe.Int64(item.Quantity),
+			})
+			continue
+		}
+
+		productData := &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
+			Name: stripe.String(item.Name),
+		}
+		if item.Description != "" {
+			productData.Description = stripe.String(item.Description)
+		}
+
+		params.LineItems = append(params.LineItems, &stripe.CheckoutSessionLineItemParams{
+			PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
+				Currency:    stripe.String(p.Currency),
+				ProductData: productData,
+				UnitAmount:  stripe.Int64(item.UnitAmount),
+			},
+			Quantity: stripe.Int64(item.Quantity),
+		})
+	}
+
+	if p.Discount != nil {
+		coupon, err := c.createCheckoutCoupon(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		params.Discounts = []*stripe.CheckoutSessionDiscountParams{{Coupon: stripe.String(coupon.ID)}}
+	}
+
+	session, err := c.api.CheckoutSessions.New(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CheckoutSession{ID: session.ID, URL: session.URL}, nil
+}
+
+// createCheckoutCoupon creates a single-use Stripe coupon for the discount
+// of a Checkout Session. The order's discount is already worked out, so the
+// coupon is a fixed amount off rather than a copy of the order's coupon.
+func (c *StripeClient) createCheckoutCoupon(ctx context.Context, p *CheckoutSessionParams) (*stripe.Coupon, error) {
+	params := &stripe.CouponParams{
+		AmountOff:      stripe.Int64(p.Discount.AmountOff),
+		Currency:       stripe.String(p.Currency),
+		Duration:       stripe.String(string(stripe.CouponDurationOnce)),
+		MaxRedemptions: stripe.Int64(1),
+		Name:           stripe.String(p.Discount.Name),
+	}
+	params.Context = ctx
+	params.AddMetadata("order_id", strconv.Itoa(p.OrderID))
+	params.SetIdempotencyKey(fmt.Sprintf("checkout-order-%d-coupon", p.OrderID))
+
+	return c.api.Coupons.New(params)
+}
+
+// CheckoutSessionIDForPaymentIntent looks up the Checkout Session for a PaymentIntent
+func (c *StripeClient) CheckoutSessionIDForPaymentIntent(ctx context.Context, paymentIntentID string) (string, error) {
+	params := &stripe.CheckoutSessionListParams{
+		PaymentIntent: stripe.String(paymentIntentID),
+	}
+	params.Context = ctx
+	params.Limit = stripe.Int64(1)
+
+	iter := c.api.CheckoutSessions.List(params)
+	if iter.Next() {
+		return iter.CheckoutSession().ID, nil
+	}
+
+	return "", iter.Err()
+}
+
+// RefundPayment refunds the PaymentIntent of a Checkout Session
+func (c *StripeClient) RefundPayment(ctx context.Context, p *RefundParams) (*Refund, error) {
+	sessionParams := &stripe.CheckoutSessionParams{}
+	sessionParams.Context = ctx
+	session, err := c.api.CheckoutSessions.Get(p.CheckoutSessionID, sessionParams)
+	if err != nil {
+		return nil, err
+	}
+	if session.PaymentIntent == nil {
+		return nil, fmt.Errorf("%w: checkout session %s has no payment", ErrRefundRejected, p.CheckoutSessionID)
+	}
+
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(session.PaymentIntent.ID),
+		Amount:        stripe.Int64(p.Amount),
+	}
+	params.Context = ctx
+	params.AddMetadata("order_id", strconv.Itoa(p.OrderID))
+	params.AddMetadata("refund_id", strconv.Itoa(p.RefundID))
+	if p.IdempotencyKey != "" {
+		params.SetIdempotencyKey(p.IdempotencyKey)
+	} else {
+		params.SetIdempotencyKey("refund-" + strconv.Itoa(p.RefundID))
+	}
+	if p.Reason != "" {
+		params.Reason = stripe.String(p.Reason)
+	}
+
+	refund, err := c.api.Refunds.New(params)
+	if rejected(err) {
+		return nil, fmt.Errorf("%w: %v", ErrRefundRejected, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Refund{ID: refund.ID, Status: string(refund.Status)}, nil
+}
+
+// rejected reports whether err is Stripe declining a request, as opposed to
+// a failure after which the request may or may not have taken effect
+func rejected(err error) bool {
+	var stripeErr *stripe.Error
+	if !errors.As(err, &stripeErr) {
+		return false
+	}
+	switch code := stripeErr.HTTPStatusCode; {
+	case code == http.StatusConflict, code == http.StatusTooManyRequests:
+		// Concurrent use of the idempotency key and rate limiting
+		return false
+	default:
+		return code >= http.StatusBadRequest && code < http.StatusInternalServerError
+	}
+}
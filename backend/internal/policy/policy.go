@@ -0,0 +1,145 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/your-username/your-repo/internal/models"
+)
+
+// ErrForbidden is returned when a subject may not perform an action
+var ErrForbidden = errors.New("policy: forbidden")
+
+// Kind is a type of resource
+type Kind string
+
+// Resource kinds
+const (
+	KindUser    Kind = "user"
+	KindProduct Kind = "product"
+	KindOrder   Kind = "order"
+	KindRole    Kind = "role"
+	KindRefund  Kind = "refund"
+	KindCoupon  Kind = "coupon"
+)
+
+// Action is something a subject does to a resource
+type Action string
+
+// Actions
+const (
+	ActionList   Action = "list"
+	ActionRead   Action = "read"
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// Subject is the caller an authorization decision is made for. Callers with
+// no user record have no role.
+type Subject struct {
+	UserID int
+	Role   models.Role
+}
+
+// Resource is what an action is performed on. OwnerID is the ID of the user
+// the resource belongs to: the user itself for users and roles, the buyer for
+// orders and refunds, and for lists the user the list is restricted to, or 0
+// for everyone's.
+type Resource struct {
+	Kind    Kind
+	OwnerID int
+}
+
+// rule decides whether a subject may act on a resource
+type rule func(sub Subject, res Resource) bool
+
+// anyone allows every subject
+func anyone(Subject, Resource) bool { return true }
+
+// owner allows subjects acting on their own resources
+func owner(sub Subject, res Resource) bool { return sub.UserID != 0 && res.OwnerID == sub.UserID }
+
+// atLeast allows subjects with role or a more privileged one
+func atLeast(role models.Role) rule {
+	return func(sub Subject, _ Resource) bool { return sub.Role.AtLeast(role) }
+}
+
+var (
+	staff = atLeast(models.RoleStaff)
+	admin = atLeast(models.RoleAdmin)
+)
+
+// anyOf allows a subject if any of the rules do
+func anyOf(rules ...rule) rule {
+	return func(sub Subject, res Resource) bool {
+		for _, allow := range rules {
+			if allow(sub, res) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// rules is the policy: actions missing from it are denied. Staff run the
+// catalog and fulfil orders; deleting records and managing roles is left to
+// admins.
+var rules = map[Kind]map[Action]rule{
+	KindUser: {
+		ActionList:   staff,
+		ActionRead:   anyOf(owner, staff),
+		ActionCreate: admin,
+		ActionUpdate: anyOf(owner, admin),
+		ActionDelete: admin,
+	},
+	KindProduct: {
+		ActionList:   anyone,
+		ActionRead:   anyone,
+		ActionCreate: staff,
+		ActionUpdate: staff,
+		ActionDelete: admin,
+	},
+	KindOrder: {
+		ActionList:   anyOf(owner, staff),
+		ActionRead:   anyOf(owner, staff),
+		ActionCreate: owner,
+		ActionUpdate: staff,
+		ActionDelete: admin,
+	},
+	KindRole: {
+		ActionRead:   admin,
+		ActionCreate: admin,
+		ActionDelete: admin,
+	},
+	KindRefund: {
+		ActionList:   anyOf(owner, staff),
+		ActionRead:   anyOf(owner, staff),
+		ActionCreate: staff,
+	},
+	KindCoupon: {
+		ActionList:   staff,
+		ActionRead:   staff,
+		ActionCreate: staff,
+		ActionUpdate: staff,
+		ActionDelete: admin,
+	},
+}
+
+// Authorize returns ErrForbidden unless sub may perform action on res
+func Authorize(sub Subject, action Action, res Resource) error {
+	if allow, ok := rules[res.Kind][action]; ok && allow(sub, res) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s %s", ErrForbidden, action, res.Kind)
+}
+
+// Scope returns the owner a list of kind must be restricted to when sub does
+// not ask for a particular one: 0 if sub may list everyone's resources, and
+// sub's own user ID otherwise
+func Scope(sub Subject, kind Kind) int {
+	if Authorize(sub, ActionList, Resource{Kind: kind}) == nil {
+		return 0
+	}
+	return sub.UserID
+}
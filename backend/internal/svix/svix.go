@@ -0,0 +1,86 @@
+package svix
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// secretPrefix prefixes the base64 signing secrets Svix issues
+const secretPrefix = "whsec_"
+
+var (
+	// ErrInvalidSignature is returned when no signature matches the payload
+	ErrInvalidSignature = errors.New("svix: invalid signature")
+	// ErrTimestampOutOfRange is returned for messages signed too long ago or
+	// too far in the future, which may be replays
+	ErrTimestampOutOfRange = errors.New("svix: timestamp out of range")
+)
+
+// Verifier checks the signatures of Svix webhook messages, as sent by Clerk
+type Verifier struct {
+	key []byte
+	// Tolerance is how far a message's timestamp may be from now
+	Tolerance time.Duration
+	// Now returns the current time; it defaults to time.Now
+	Now func() time.Time
+}
+
+// NewVerifier creates a Verifier for a "whsec_" signing secret
+func NewVerifier(secret string) (*Verifier, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, secretPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("svix: invalid signing secret: %w", err)
+	}
+	if len(key) == 0 {
+		return nil, errors.New("svix: empty signing secret")
+	}
+
+	return &Verifier{key: key, Tolerance: 5 * time.Minute, Now: time.Now}, nil
+}
+
+// Verify checks the svix-id, svix-timestamp and svix-signature headers
+// against payload and returns the time the message was signed
+func (v *Verifier) Verify(header http.Header, payload []byte) (time.Time, error) {
+	id := header.Get("svix-id")
+	timestamp := header.Get("svix-timestamp")
+	signatures := header.Get("svix-signature")
+	if id == "" || timestamp == "" || signatures == "" {
+		return time.Time{}, fmt.Errorf("%w: missing headers", ErrInvalidSignature)
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: malformed timestamp", ErrInvalidSignature)
+	}
+	signedAt := time.Unix(seconds, 0)
+	if age := v.Now().Sub(signedAt); age > v.Tolerance || age < -v.Tolerance {
+		return time.Time{}, ErrTimestampOutOfRange
+	}
+
+	mac := hmac.New(sha256.New, v.key)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+
+	// The header holds space-separated "version,signature" pairs, one per
+	// active secret during rotation
+	for _, versioned := range strings.Fields(signatures) {
+		version, signature, ok := strings.Cut(versioned, ",")
+		if !ok || version != "v1" {
+			continue
+		}
+		actual, err := base64.StdEncoding.DecodeString(signature)
+		if err == nil && hmac.Equal(actual, expected) {
+			return signedAt, nil
+		}
+	}
+
+	return time.Time{}, ErrInvalidSignature
+}
@@ -0,0 +1,122 @@
+package svix
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testKey is the key of testSecret
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+var testSecret = secretPrefix + base64.StdEncoding.EncodeToString(testKey)
+
+// testNow is the time the test verifier sees as now
+var testNow = time.Unix(1700000000, 0)
+
+// sign returns the v1 signature of a message with key
+func sign(key []byte, id string, signedAt time.Time, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + strconv.FormatInt(signedAt.Unix(), 10) + "." + payload))
+	return "v1," + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// headers returns the Svix headers of a message
+func headers(id string, signedAt time.Time, signatures string) http.Header {
+	h := http.Header{}
+	h.Set("svix-id", id)
+	h.Set("svix-timestamp", strconv.FormatInt(signedAt.Unix(), 10))
+	h.Set("svix-signature", signatures)
+	return h
+}
+
+// testVerifier returns a Verifier for secret whose clock reads testNow
+func testVerifier(t *testing.T, secret string) *Verifier {
+	t.Helper()
+	v, err := NewVerifier(secret)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	v.Now = func() time.Time { return testNow }
+	return v
+}
+
+func TestVerify(t *testing.T) {
+	const payload = `{"type":"user.created"}`
+	otherKey := []byte("fedcba9876543210fedcba9876543210")
+	valid := sign(testKey, "msg_1", testNow, payload)
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		payload string
+		wantErr error
+	}{
+		{"valid", headers("msg_1", testNow, valid), payload, nil},
+		{"tampered body", headers("msg_1", testNow, valid), `{"type":"user.deleted"}`, ErrInvalidSignature},
+		{"other message ID", headers("msg_2", testNow, valid), payload, ErrInvalidSignature},
+		{"other key", headers("msg_1", testNow, sign(otherKey, "msg_1", testNow, payload)), payload, ErrInvalidSignature},
+		{
+			"signed too long ago",
+			headers("msg_1", testNow.Add(-6*time.Minute), sign(testKey, "msg_1", testNow.Add(-6*time.Minute), payload)),
+			payload, ErrTimestampOutOfRange,
+		},
+		{
+			"signed in the future",
+			headers("msg_1", testNow.Add(6*time.Minute), sign(testKey, "msg_1", testNow.Add(6*time.Minute), payload)),
+			payload, ErrTimestampOutOfRange,
+		},
+		{
+			"within tolerance",
+			headers("msg_1", testNow.Add(-4*time.Minute), sign(testKey, "msg_1", testNow.Add(-4*time.Minute), payload)),
+			payload, nil,
+		},
+		{
+			"one of several signatures",
+			headers("msg_1", testNow, sign(otherKey, "msg_1", testNow, payload)+" v1,bm90IGEgc2lnbmF0dXJl "+valid),
+			payload, nil,
+		},
+		{"unknown version", headers("msg_1", testNow, "v2"+valid[2:]), payload, ErrInvalidSignature},
+		{"missing headers", http.Header{}, payload, ErrInvalidSignature},
+		{"malformed timestamp", func() http.Header {
+			h := headers("msg_1", testNow, valid)
+			h.Set("svix-timestamp", "yesterday")
+			return h
+		}(), payload, ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signedAt, err := testVerifier(t, testSecret).Verify(tt.header, []byte(tt.payload))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify = %v, want %v", err, tt.wantErr)
+			}
+			if got := strconv.FormatInt(signedAt.Unix(), 10); err == nil && got != tt.header.Get("svix-timestamp") {
+				t.Errorf("signed at %s, want %s", got, tt.header.Get("svix-timestamp"))
+			}
+		})
+	}
+}
+
+func TestNewVerifierSecretPrefix(t *testing.T) {
+	const payload = `{}`
+	header := headers("msg_1", testNow, sign(testKey, "msg_1", testNow, payload))
+
+	// Secrets work with or without Svix's whsec_ prefix
+	for _, secret := range []string{testSecret, base64.StdEncoding.EncodeToString(testKey)} {
+		if _, err := testVerifier(t, secret).Verify(header, []byte(payload)); err != nil {
+			t.Errorf("Verify with secret %q = %v, want nil", secret, err)
+		}
+	}
+
+	for _, secret := range []string{"whsec_not base64!", "whsec_", ""} {
+		if _, err := NewVerifier(secret); err == nil {
+			t.Errorf("NewVerifier(%q) succeeded, want an error", secret)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DecodeError is returned by DecodeJSON when the body is not valid JSON for
+// the destination type
+type DecodeError struct {
+	Message string
+	// Field is the JSON name of the offending field, if known
+	Field string
+	err   error
+}
+
+func (e *DecodeError) Error() string {
+	return e.Message
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.err
+}
+
+// DecodeJSON decodes a single JSON object from the request body into dst,
+// rejecting unknown fields and bodies larger than maxBytes, then validates
+// dst. It returns a *DecodeError, *http.MaxBytesError or Errors on failure.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}, maxBytes int64) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBytes))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		return decodeError(err)
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return &DecodeError{Message: "Request body must contain a single JSON object"}
+	}
+
+	return Validate(dst)
+}
+
+// decodeError converts an encoding/json error into a client-safe error
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxBytesErr *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return err
+	case errors.Is(err, io.EOF):
+		return &DecodeError{Message: "Request body must not be empty", err: err}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &DecodeError{Message: "Request body contains malformed JSON", err: err}
+	case errors.As(err, &syntaxErr):
+		return &DecodeError{Message: fmt.Sprintf("Request body contains malformed JSON at position %d", syntaxErr.Offset), err: err}
+	case errors.As(err, &typeErr):
+		return &DecodeError{
+			Message: fmt.Sprintf("Field %q must be of type %s", typeErr.Field, typeErr.Type),
+			Field:   typeErr.Field,
+			err:     err,
+		}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &DecodeError{Message: fmt.Sprintf("Unknown field %q", field), Field: field, err: err}
+	}
+
+	return &DecodeError{Message: "Request body is not valid JSON", err: err}
+}
@@ -0,0 +1,72 @@
+package validation
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		maxBytes  int64
+		wantMsg   string // DecodeError message, if one is wanted
+		wantField string
+		wantSize  bool // Whether an *http.MaxBytesError is wanted
+		wantValid bool // Whether validation errors are wanted
+	}{
+		{name: "valid", body: `{"product_id":1,"quantity":2}`},
+		{name: "empty", body: ``, wantMsg: "Request body must not be empty"},
+		{name: "malformed", body: `{"product_id":1,`, wantMsg: "Request body contains malformed JSON"},
+		{name: "syntax error", body: `{"product_id" 1}`, wantMsg: "Request body contains malformed JSON at position 15"},
+		{name: "wrong type", body: `{"product_id":"1","quantity":2}`, wantMsg: `Field "product_id" must be of type int`, wantField: "product_id"},
+		{name: "unknown field", body: `{"product_id":1,"quantity":2,"price":1}`, wantMsg: `Unknown field "price"`, wantField: "price"},
+		{name: "trailing data", body: `{"product_id":1,"quantity":2}{}`, wantMsg: "Request body must contain a single JSON object"},
+		{name: "oversized", body: `{"product_id":1,"quantity":2}`, maxBytes: 10, wantSize: true},
+		{name: "invalid", body: `{"product_id":0,"quantity":2}`, wantValid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxBytes := tt.maxBytes
+			if maxBytes == 0 {
+				maxBytes = 1 << 10
+			}
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			var dst testItem
+
+			err := DecodeJSON(httptest.NewRecorder(), r, &dst, maxBytes)
+
+			var decodeErr *DecodeError
+			var maxBytesErr *http.MaxBytesError
+			var validationErrs Errors
+			switch {
+			case tt.wantMsg != "":
+				if !errors.As(err, &decodeErr) {
+					t.Fatalf("DecodeJSON = %v, want a DecodeError", err)
+				}
+				if decodeErr.Message != tt.wantMsg || decodeErr.Field != tt.wantField {
+					t.Errorf("DecodeError = %q (field %q), want %q (field %q)", decodeErr.Message, decodeErr.Field, tt.wantMsg, tt.wantField)
+				}
+			case tt.wantSize:
+				if !errors.As(err, &maxBytesErr) || maxBytesErr.Limit != maxBytes {
+					t.Errorf("DecodeJSON = %v, want an http.MaxBytesError with limit %d", err, maxBytes)
+				}
+			case tt.wantValid:
+				if !errors.As(err, &validationErrs) {
+					t.Errorf("DecodeJSON = %v, want validation Errors", err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("DecodeJSON = %v, want nil", err)
+				}
+				if dst != (testItem{ProductID: 1, Quantity: 2}) {
+					t.Errorf("decoded %+v", dst)
+				}
+			}
+		})
+	}
+}
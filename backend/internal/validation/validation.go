@@ -0,0 +1,378 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// FieldError describes a rule a single field failed
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// Errors is the list of field errors from validating a value
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks v, a struct or pointer to struct, against the rules in its
+// `validate` struct tags and returns Errors if any fail. Fields are named by
+// their JSON names, with nested structs and slices as "items[0].quantity".
+//
+// Rules are comma-separated:
+//
+//	required     the field must not be the zero value or empty
+//	min=N        strings have at least N characters, slices N elements, numbers are >= N
+//	max=N        strings have at most N characters, slices N elements, numbers are <= N
+//	gt=N         numbers are > N
+//	email        strings are a bare email address
+//	oneof=a b c  the field is one of the space-separated values
+//
+// Rules other than required are skipped for empty strings, collections and
+// nil pointers, so they only constrain optional fields that were supplied.
+// Numeric rules always apply, since zero is a meaningful number.
+//
+// Tags are parsed once per type. Invalid tags are a programming error and
+// panic; Check reports them instead.
+func Validate(v interface{}) error {
+	var errs Errors
+	validateValue(reflect.ValueOf(v), "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateValue walks structs, pointers and slices, validating struct fields
+func validateValue(v reflect.Value, path string, errs *Errors) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			validateValue(v.Elem(), path, errs)
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+
+	case reflect.Struct:
+		fields, err := structFields(v.Type())
+		if err != nil {
+			panic(err)
+		}
+		for _, f := range fields {
+			name := f.name
+			if path != "" {
+				name = path + "." + name
+			}
+
+			fv := v.Field(f.index)
+			if msg := checkRules(fv, f.rules); msg != "" {
+				*errs = append(*errs, FieldError{Field: name, Message: msg})
+				continue
+			}
+			validateValue(fv, name, errs)
+		}
+	}
+}
+
+// rule is a parsed validation rule
+type rule struct {
+	name    string
+	arg     string
+	bound   float64  // For min, max and gt
+	options []string // For oneof
+}
+
+// field is an exported struct field and its parsed rules
+type field struct {
+	index int
+	name  string // JSON name
+	rules []rule
+}
+
+// parsedFields caches the fields of each struct type, or the error from
+// parsing its tags, so that tags are parsed once per type
+var parsedFields sync.Map // reflect.Type -> parsedType
+
+// parsedType is an entry of parsedFields
+type parsedType struct {
+	fields []field
+	err    error
+}
+
+// Check parses the `validate` tags of v's type and the types it contains,
+// returning an error for unknown rules, invalid arguments and rules that do
+// not apply to their field's type. Validate panics on such tags, so tests
+// should Check the types they are used with.
+func Check(v interface{}) error {
+	return checkType(reflect.TypeOf(v), make(map[reflect.Type]bool))
+}
+
+// checkType parses the tags of t and the types it contains
+func checkType(t reflect.Type, seen map[reflect.Type]bool) error {
+	if t == nil || seen[t] {
+		return nil
+	}
+	seen[t] = true
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return checkType(t.Elem(), seen)
+	case reflect.Struct:
+		fields, err := structFields(t)
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			if err := checkType(t.Field(f.index).Type, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// structFields returns the fields of a struct type with their parsed rules
+func structFields(t reflect.Type) ([]field, error) {
+	if cached, ok := parsedFields.Load(t); ok {
+		p := cached.(parsedType)
+		return p.fields, p.err
+	}
+
+	var p parsedType
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := fieldName(sf)
+		if name == "-" {
+			continue
+		}
+
+		rules, err := parseRules(sf.Type, sf.Tag.Get("validate"))
+		if err != nil {
+			p = parsedType{err: fmt.Errorf("validation: %s.%s: %w", t, sf.Name, err)}
+			break
+		}
+		p.fields = append(p.fields, field{index: i, name: name, rules: rules})
+	}
+
+	parsedFields.Store(t, p)
+	return p.fields, p.err
+}
+
+// fieldName returns the JSON name of a struct field
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// parseRules parses the comma-separated rules of a field of type t
+func parseRules(t reflect.Type, tag string) ([]rule, error) {
+	if tag == "" {
+		return nil, nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var rules []rule
+	for _, s := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(s), "=")
+		r := rule{name: name, arg: arg}
+
+		switch name {
+		case "required":
+		case "min", "max", "gt":
+			bound, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid bound %q for %s", arg, name)
+			}
+			r.bound = bound
+			if !isNumberKind(t.Kind()) && (name == "gt" || !hasLength(t.Kind())) {
+				return nil, fmt.Errorf("%s does not apply to %s fields", name, t.Kind())
+			}
+		case "email":
+			if t.Kind() != reflect.String {
+				return nil, fmt.Errorf("email does not apply to %s fields", t.Kind())
+			}
+		case "oneof":
+			r.options = strings.Fields(arg)
+			if len(r.options) == 0 {
+				return nil, errors.New("oneof has no values")
+			}
+		default:
+			return nil, fmt.Errorf("unknown rule %q", name)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// checkRules applies a field's rules in order and returns the first failure
+func checkRules(v reflect.Value, rules []rule) string {
+	for _, r := range rules {
+		if r.name == "required" {
+			if isEmpty(v) {
+				return "is required"
+			}
+			continue
+		}
+		if isEmpty(v) && !isNumber(v) {
+			continue
+		}
+		for v.Kind() == reflect.Ptr {
+			v = v.Elem()
+		}
+
+		var msg string
+		switch r.name {
+		case "min":
+			msg = checkBound(v, r, false)
+		case "max":
+			msg = checkBound(v, r, true)
+		case "gt":
+			msg = checkGreater(v, r)
+		case "email":
+			msg = checkEmail(v)
+		case "oneof":
+			msg = checkOneOf(v, r)
+		}
+		if msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+// isEmpty reports whether v is the zero value, an empty string or collection
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+// checkBound applies a min or max rule
+func checkBound(v reflect.Value, r rule, isMax bool) string {
+	bound, arg := r.bound, r.arg
+
+	var n float64
+	var unit string
+	switch v.Kind() {
+	case reflect.String:
+		n, unit = float64(utf8.RuneCountInString(v.String())), " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		n, unit = float64(v.Len()), " items"
+	default:
+		n = number(v)
+	}
+
+	switch {
+	case isMax && n > bound:
+		if unit != "" {
+			return fmt.Sprintf("must be at most %s%s", arg, unit)
+		}
+		return "must be at most " + arg
+	case !isMax && n < bound:
+		if unit != "" {
+			return fmt.Sprintf("must be at least %s%s", arg, unit)
+		}
+		return "must be at least " + arg
+	}
+	return ""
+}
+
+// checkGreater applies a gt rule
+func checkGreater(v reflect.Value, r rule) string {
+	if number(v) <= r.bound {
+		if r.bound == 0 {
+			return "must be positive"
+		}
+		return "must be greater than " + r.arg
+	}
+	return ""
+}
+
+// checkEmail applies an email rule
+func checkEmail(v reflect.Value) string {
+	s := v.String()
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s {
+		return "must be a valid email address"
+	}
+	return ""
+}
+
+// checkOneOf applies a oneof rule
+func checkOneOf(v reflect.Value, r rule) string {
+	s := fmt.Sprint(v.Interface())
+	for _, option := range r.options {
+		if s == option {
+			return ""
+		}
+	}
+	return "must be one of: " + strings.Join(r.options, ", ")
+}
+
+// isNumber reports whether v is an integer or float
+func isNumber(v reflect.Value) bool {
+	return isNumberKind(v.Kind())
+}
+
+// isNumberKind reports whether k is an integer or float kind
+func isNumberKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// hasLength reports whether min and max limit the length of kind k
+func hasLength(k reflect.Kind) bool {
+	switch k {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return true
+	}
+	return false
+}
+
+// number returns the value of a numeric field
+func number(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	panic(fmt.Sprintf("validation: numeric rule on %s field", v.Kind()))
+}
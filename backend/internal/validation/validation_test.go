@@ -0,0 +1,141 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testItem and testRequest exercise every rule, on nested structs too
+type testItem struct {
+	ProductID int `json:"product_id" validate:"gt=0"`
+	Quantity  int `json:"quantity" validate:"min=1,max=10"`
+}
+
+type testRequest struct {
+	Name   string     `json:"name" validate:"required,min=2,max=5"`
+	Email  string     `json:"email" validate:"email"`
+	Kind   string     `json:"kind" validate:"oneof=percent fixed"`
+	Price  float64    `json:"price" validate:"gt=0"`
+	Stock  *int       `json:"stock" validate:"min=0"`
+	Items  []testItem `json:"items" validate:"max=2"`
+	Hidden string     `json:"-" validate:"required"`
+}
+
+// validRequest returns a testRequest that passes every rule
+func validRequest() testRequest {
+	return testRequest{Name: "Mug", Email: "ada@example.com", Kind: "fixed", Price: 1, Items: []testItem{{ProductID: 1, Quantity: 1}}}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(r *testRequest)
+		want   Errors
+	}{
+		{"valid", func(r *testRequest) {}, nil},
+		{"required missing", func(r *testRequest) { r.Name = "" }, Errors{{"name", "is required"}}},
+		{"required blank", func(r *testRequest) { r.Name = "   " }, Errors{{"name", "is required"}}},
+		{"min string", func(r *testRequest) { r.Name = "M" }, Errors{{"name", "must be at least 2 characters"}}},
+		{"max string counts characters", func(r *testRequest) { r.Name = "ÄÖÜßé" }, nil},
+		{"max string", func(r *testRequest) { r.Name = "Mugs!!" }, Errors{{"name", "must be at most 5 characters"}}},
+		{"email", func(r *testRequest) { r.Email = "not an email" }, Errors{{"email", "must be a valid email address"}}},
+		{"email with name", func(r *testRequest) { r.Email = "Ada <ada@example.com>" }, Errors{{"email", "must be a valid email address"}}},
+		{"optional email omitted", func(r *testRequest) { r.Email = "" }, nil},
+		{"oneof", func(r *testRequest) { r.Kind = "free" }, Errors{{"kind", "must be one of: percent, fixed"}}},
+		{"gt zero", func(r *testRequest) { r.Price = 0 }, Errors{{"price", "must be positive"}}},
+		{"min pointer", func(r *testRequest) { r.Stock = intPtr(-1) }, Errors{{"stock", "must be at least 0"}}},
+		{"min pointer zero", func(r *testRequest) { r.Stock = intPtr(0) }, nil},
+		{"max slice", func(r *testRequest) { r.Items = make([]testItem, 3) }, Errors{{"items", "must be at most 2 items"}}},
+		{
+			"nested",
+			func(r *testRequest) { r.Items = []testItem{{ProductID: 1, Quantity: 1}, {ProductID: 0, Quantity: 11}} },
+			Errors{{"items[1].product_id", "must be positive"}, {"items[1].quantity", "must be at most 10"}},
+		},
+		{
+			"several fields",
+			func(r *testRequest) { r.Name, r.Kind = "", "free" },
+			Errors{{"name", "is required"}, {"kind", "must be one of: percent, fixed"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := validRequest()
+			tt.modify(&r)
+
+			err := Validate(&r)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("Validate = %v, want nil", err)
+				}
+				return
+			}
+			var got Errors
+			if !errors.As(err, &got) {
+				t.Fatalf("Validate = %v, want Errors", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want string // Substring of the error, empty if the tags are valid
+	}{
+		{"valid", testRequest{}, ""},
+		{"unknown rule", struct {
+			A string `validate:"required,nonempty"`
+		}{}, `unknown rule "nonempty"`},
+		{"invalid bound", struct {
+			A int `validate:"max=ten"`
+		}{}, `invalid bound "ten"`},
+		{"gt on string", struct {
+			A string `validate:"gt=0"`
+		}{}, "gt does not apply to string fields"},
+		{"email on int", struct {
+			A int `validate:"email"`
+		}{}, "email does not apply to int fields"},
+		{"empty oneof", struct {
+			A string `validate:"oneof="`
+		}{}, "oneof has no values"},
+		{"nested in slice", struct {
+			A []struct {
+				B int `validate:"between=1 2"`
+			}
+		}{}, `unknown rule "between"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(tt.v)
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("Check = %v, want nil", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("Check = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePanicsOnInvalidTags(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Validate did not panic")
+		}
+	}()
+	Validate(struct {
+		A string `validate:"nonempty"`
+	}{})
+}
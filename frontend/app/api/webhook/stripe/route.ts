@@ -1,34 +0,0 @@
-import { type NextRequest, NextResponse } from "next/server"
-import { headers } from "next/headers"
-import { stripe } from "@/lib/stripe"
-import { db } from "@/lib/db"
-import { orders } from "@/lib/db/schema"
-import { eq } from "drizzle-orm"
-import type Stripe from "stripe"
-
-export async function POST(req: NextRequest) {
-  const body = await req.text()
-  const signature = headers().get("Stripe-Signature") as string
-
-  let event: Stripe.Event
-
-  try {
-    event = stripe.webhooks.constructEvent(body, signature, process.env.STRIPE_WEBHOOK_SECRET as string)
-  } catch (error: any) {
-    return new NextResponse(`Webhook Error: ${error.message}`, { status: 400 })
-  }
-
-  const session = event.data.object as Stripe.Checkout.Session
-
-  if (event.type === "checkout.session.completed") {
-    // Update order status to paid
-    if (session.metadata?.orderId) {
-      await db
-        .update(orders)
-        .set({ status: "paid" })
-        .where(eq(orders.id, Number.parseInt(session.metadata.orderId)))
-    }
-  }
-
-  return new NextResponse(null, { status: 200 })
-}
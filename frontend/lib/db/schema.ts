@@ -1,4 +1,4 @@
-import { pgTable, serial, text, timestamp, integer } from "drizzle-orm/pg-core"
+import { pgTable, serial, text, timestamp, integer, boolean } from "drizzle-orm/pg-core"
 import { relations } from "drizzle-orm"
 import { createInsertSchema, createSelectSchema } from "drizzle-zod"
 import { z } from "zod"
@@ -9,8 +9,21 @@ export const users = pgTable("users", {
   clerkId: text("clerk_id").notNull().unique(),
   email: text("email").notNull(),
   name: text("name"),
+  role: text("role").notNull().default("customer"), // customer, staff or admin
+  clerkSyncedAt: timestamp("clerk_synced_at"), // Time of the newest Clerk webhook applied
   createdAt: timestamp("created_at").defaultNow().notNull(),
   updatedAt: timestamp("updated_at").defaultNow().notNull(),
+  deletedAt: timestamp("deleted_at"),
+})
+
+// Audit trail of user role changes, kept after the user is deleted
+export const userRoleChanges = pgTable("user_role_changes", {
+  id: serial("id").primaryKey(),
+  userId: integer("user_id").notNull(),
+  fromRole: text("from_role").notNull(),
+  toRole: text("to_role").notNull(),
+  changedBy: text("changed_by").notNull(),
+  createdAt: timestamp("created_at").defaultNow().notNull(),
 })
 
 // Products table
@@ -19,6 +32,7 @@ export const products = pgTable("products", {
   name: text("name").notNull(),
   description: text("description"),
   price: integer("price").notNull(), // Price in cents
+  stock: integer("stock"), // Units available, null if not tracked
   stripeProductId: text("stripe_product_id"),
   stripePriceId: text("stripe_price_id"),
   createdAt: timestamp("created_at").defaultNow().notNull(),
@@ -32,8 +46,12 @@ export const orders = pgTable("orders", {
     .references(() => users.id)
     .notNull(),
   status: text("status").notNull().default("pending"),
-  total: integer("total").notNull(), // Total in cents
+  total: integer("total").notNull(), // Total in cents, after the discount
+  discount: integer("discount").notNull().default(0), // Discount in cents
+  couponId: integer("coupon_id").references(() => coupons.id),
+  couponCode: text("coupon_code").notNull().default(""),
   stripeSessionId: text("stripe_session_id"),
+  reservedUntil: timestamp("reserved_until"),
   createdAt: timestamp("created_at").defaultNow().notNull(),
   updatedAt: timestamp("updated_at").defaultNow().notNull(),
 })
@@ -49,10 +67,101 @@ export const orderItems = pgTable("order_items", {
     .notNull(),
   quantity: integer("quantity").notNull(),
   price: integer("price").notNull(), // Price at time of purchase in cents
+  discount: integer("discount").notNull().default(0), // Discount on the line in cents
+  createdAt: timestamp("created_at").defaultNow().notNull(),
+  updatedAt: timestamp("updated_at").defaultNow().notNull(),
+})
+
+// Order status history table
+export const orderStatusHistory = pgTable("order_status_history", {
+  id: serial("id").primaryKey(),
+  orderId: integer("order_id")
+    .references(() => orders.id)
+    .notNull(),
+  fromStatus: text("from_status").notNull(),
+  toStatus: text("to_status").notNull(),
+  changedBy: text("changed_by").notNull(),
+  createdAt: timestamp("created_at").defaultNow().notNull(),
+})
+
+// Refunds table
+export const refunds = pgTable("refunds", {
+  id: serial("id").primaryKey(),
+  orderId: integer("order_id")
+    .references(() => orders.id)
+    .notNull(),
+  amount: integer("amount").notNull(), // Amount in cents
+  status: text("status").notNull().default("pending"),
+  fullRefund: boolean("full_refund").notNull().default(false),
+  reason: text("reason").notNull().default(""),
+  stripeRefundId: text("stripe_refund_id").notNull().default(""),
+  idempotencyKey: text("idempotency_key").notNull().unique(),
+  createdBy: text("created_by").notNull(),
   createdAt: timestamp("created_at").defaultNow().notNull(),
   updatedAt: timestamp("updated_at").defaultNow().notNull(),
 })
 
+// Refund items table
+export const refundItems = pgTable("refund_items", {
+  id: serial("id").primaryKey(),
+  refundId: integer("refund_id")
+    .references(() => refunds.id)
+    .notNull(),
+  orderItemId: integer("order_item_id")
+    .references(() => orderItems.id)
+    .notNull(),
+  quantity: integer("quantity").notNull(),
+  amount: integer("amount").notNull(), // Amount in cents
+})
+
+// Carts table: a user's cart, or an anonymous one found by its token's hash
+export const carts = pgTable("carts", {
+  id: serial("id").primaryKey(),
+  userId: integer("user_id")
+    .references(() => users.id, { onDelete: "cascade" })
+    .unique(),
+  tokenHash: text("token_hash").unique(),
+  createdAt: timestamp("created_at").defaultNow().notNull(),
+  updatedAt: timestamp("updated_at").defaultNow().notNull(),
+})
+
+// Cart items table, priced from products when read
+export const cartItems = pgTable("cart_items", {
+  id: serial("id").primaryKey(),
+  cartId: integer("cart_id")
+    .references(() => carts.id, { onDelete: "cascade" })
+    .notNull(),
+  productId: integer("product_id")
+    .references(() => products.id, { onDelete: "cascade" })
+    .notNull(),
+  quantity: integer("quantity").notNull(),
+  createdAt: timestamp("created_at").defaultNow().notNull(),
+  updatedAt: timestamp("updated_at").defaultNow().notNull(),
+})
+
+// Coupons table: percent or fixed-amount discount codes, stored upper-case
+export const coupons = pgTable("coupons", {
+  id: serial("id").primaryKey(),
+  code: text("code").notNull().unique(),
+  kind: text("kind").notNull(), // "percent" or "fixed"
+  value: integer("value").notNull(), // Percent off, or amount off in cents
+  productIds: integer("product_ids").array().notNull().default([]), // Empty for the whole order
+  minSubtotal: integer("min_subtotal").notNull().default(0), // Minimum subtotal in cents
+  startsAt: timestamp("starts_at"),
+  endsAt: timestamp("ends_at"),
+  maxRedemptions: integer("max_redemptions"), // Null if unlimited
+  maxRedemptionsPerUser: integer("max_redemptions_per_user"), // Null if unlimited
+  createdAt: timestamp("created_at").defaultNow().notNull(),
+  updatedAt: timestamp("updated_at").defaultNow().notNull(),
+})
+
+// Processed Stripe webhook events, used to handle redeliveries idempotently
+export const stripeEvents = pgTable("stripe_events", {
+  id: text("id").primaryKey(),
+  type: text("type").notNull(),
+  processedAt: timestamp("processed_at").defaultNow().notNull(),
+})
+
 // Relations
 export const usersRelations = relations(users, ({ many }) => ({
   orders: many(orders),
@@ -63,7 +172,12 @@ export const ordersRelations = relations(orders, ({ one, many }) => ({
     fields: [orders.userId],
     references: [users.id],
   }),
+  coupon: one(coupons, {
+    fields: [orders.couponId],
+    references: [coupons.id],
+  }),
   items: many(orderItems),
+  refunds: many(refunds),
 }))
 
 export const orderItemsRelations = relations(orderItems, ({ one }) => ({
@@ -77,6 +191,48 @@ export const orderItemsRelations = relations(orderItems, ({ one }) => ({
   }),
 }))
 
+export const refundsRelations = relations(refunds, ({ one, many }) => ({
+  order: one(orders, {
+    fields: [refunds.orderId],
+    references: [orders.id],
+  }),
+  items: many(refundItems),
+}))
+
+export const refundItemsRelations = relations(refundItems, ({ one }) => ({
+  refund: one(refunds, {
+    fields: [refundItems.refundId],
+    references: [refunds.id],
+  }),
+  orderItem: one(orderItems, {
+    fields: [refundItems.orderItemId],
+    references: [orderItems.id],
+  }),
+}))
+
+export const cartsRelations = relations(carts, ({ one, many }) => ({
+  user: one(users, {
+    fields: [carts.userId],
+    references: [users.id],
+  }),
+  items: many(cartItems),
+}))
+
+export const cartItemsRelations = relations(cartItems, ({ one }) => ({
+  cart: one(carts, {
+    fields: [cartItems.cartId],
+    references: [carts.id],
+  }),
+  product: one(products, {
+    fields: [cartItems.productId],
+    references: [products.id],
+  }),
+}))
+
+export const couponsRelations = relations(coupons, ({ many }) => ({
+  orders: many(orders),
+}))
+
 export const productsRelations = relations(products, ({ many }) => ({
   orderItems: many(orderItems),
 }))
@@ -99,6 +255,23 @@ export const createProductSchema = z.object({
   name: z.string().min(1).max(255),
   description: z.string().optional(),
   price: z.number().positive(),
+  stock: z.number().int().min(0).nullable().optional(), // null if not tracked
+})
+
+// Stock changes after creation: delta adjusts tracked stock relative to its
+// current value, track starts tracking untracked stock
+export const adjustStockSchema = z
+  .object({
+    delta: z.number().int().optional(),
+    track: z.number().int().min(0).optional(),
+  })
+  .refine((s) => (s.delta === undefined) !== (s.track === undefined), {
+    message: "Exactly one of delta and track is required",
+  })
+
+export const addCartItemSchema = z.object({
+  productId: z.number().positive(),
+  quantity: z.number().int().positive().max(1000),
 })
 
 export const createOrderSchema = z.object({
@@ -108,4 +281,5 @@ export const createOrderSchema = z.object({
       quantity: z.number().positive(),
     }),
   ),
+  couponCode: z.string().max(64).optional(),
 })